@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// standardBauds are the rates an estimate is snapped to.
+var standardBauds = []int{
+	300, 600, 1200, 2400, 4800, 9600, 14400, 19200, 28800, 38400, 57600,
+	74880, 115200, 230400, 250000, 460800, 500000, 921600, 1000000,
+	1500000, 2000000, 3000000,
+}
+
+// BaudEstimate is the result of measuring a UART capture.
+type BaudEstimate struct {
+	Baud       int     // Nearest standard rate
+	Measured   float64 // Rate derived from the pulse widths
+	Confidence float64 // 0 to 1
+}
+
+// detectBaud estimates the baud rate of the UART traffic on the given
+// channels. The shortest pulse width that occurs repeatedly is taken as one
+// bit; the pulses that are close to whole multiples of it refine the bit
+// time.
+func detectBaud(s *Session, channels []int) (BaudEstimate, error) {
+	if s.SampleRate == 0 {
+		return BaudEstimate{}, fmt.Errorf("capture has no sample rate")
+	}
+
+	var widths []int64
+	for _, ch := range channels {
+		widths = append(widths, pulseWidths(s, ch)...)
+	}
+	if len(widths) < 4 {
+		return BaudEstimate{}, fmt.Errorf("not enough UART activity to measure")
+	}
+	sort.Slice(widths, func(i, j int) bool { return widths[i] < widths[j] })
+
+	// A width is stable when enough other pulses are within 25% of it;
+	// single glitches are skipped this way
+	minCount := max(2, len(widths)/50)
+	var bit float64
+	for i, w := range widths {
+		count := 0
+		for _, o := range widths[i:] {
+			if float64(o) > float64(w)*1.25 {
+				break
+			}
+			count++
+		}
+		if count >= minCount {
+			bit = float64(w)
+			break
+		}
+	}
+	if bit == 0 {
+		return BaudEstimate{}, fmt.Errorf("no stable pulse width found")
+	}
+
+	// Refine using the pulses of up to 10 bits (a whole frame) that are
+	// close to a whole number of bits
+	var total, bits float64
+	fitted := 0
+	for _, w := range widths {
+		n := math.Round(float64(w) / bit)
+		if n < 1 || n > 10 || math.Abs(float64(w)/bit-n) >= 0.2 {
+			continue
+		}
+		total += float64(w)
+		bits += n
+		fitted++
+	}
+	bit = total / bits
+	measured := float64(s.SampleRate) / bit
+
+	nearest := standardBauds[0]
+	for _, b := range standardBauds {
+		if math.Abs(float64(b)-measured) < math.Abs(float64(nearest)-measured) {
+			nearest = b
+		}
+	}
+
+	// Confidence falls with pulses that are not whole bits and with the
+	// distance to the standard rate (5% off counts as no confidence)
+	fit := float64(fitted) / float64(len(widths))
+	offset := math.Abs(measured-float64(nearest)) / float64(nearest)
+	confidence := fit * math.Max(0, 1-offset/0.05)
+
+	return BaudEstimate{Baud: nearest, Measured: measured, Confidence: confidence}, nil
+}
+
+// pulseWidths returns the length of every complete run between two edges
+// on a channel. The runs before the first and after the last edge are idle
+// time and are left out.
+func pulseWidths(s *Session, ch int) []int64 {
+	var widths []int64
+	n := s.NumSamples()
+	last := int64(-1)
+	for i := int64(1); i < n; i++ {
+		if s.Bit(i, ch) == s.Bit(i-1, ch) {
+			continue
+		}
+		if last >= 0 {
+			widths = append(widths, i-last)
+		}
+		last = i
+	}
+	return widths
+}
+
+// uartBaudFromCapture fills uartBaud from the TX and RX lines of the last
+// capture.
+func (m *model) uartBaudFromCapture() {
+	if m.session == nil {
+		m.statusMsg = "Error: no capture to measure"
+		return
+	}
+	s, err := decodableSession(m.session, *m)
+	if err != nil {
+		m.statusMsg = "Error: " + err.Error()
+		return
+	}
+
+	var channels []int
+	for _, line := range []struct{ role, pin string }{{"TX", m.uartTX}, {"RX", m.uartRX}} {
+		ch, err := resolveChannel(s, line.role, line.pin)
+		if err != nil {
+			m.statusMsg = "Error: " + err.Error()
+			return
+		}
+		if ch >= 0 {
+			channels = append(channels, ch)
+		}
+	}
+
+	est, err := detectBaud(s, channels)
+	if err != nil {
+		m.statusMsg = "Error: auto-baud failed: " + err.Error()
+		return
+	}
+	m.uartBaud = fmt.Sprint(est.Baud)
+	m.statusMsg = fmt.Sprintf("Baud: %d (measured %.0f, %.0f%% confidence)",
+		est.Baud, est.Measured, est.Confidence*100)
+}
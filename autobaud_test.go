@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDetectBaudDemo(t *testing.T) {
+	s, err := openSession("demo/capture.sr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, _ := s.Channel("TX")
+	rx, _ := s.Channel("RX")
+
+	est, err := detectBaud(s, []int{tx, rx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if est.Baud != 115200 {
+		t.Errorf("Baud = %d, want 115200 (measured %.0f)", est.Baud, est.Measured)
+	}
+	if est.Confidence < 0.9 {
+		t.Errorf("Confidence = %.2f, want at least 0.9", est.Confidence)
+	}
+}
+
+func TestDetectBaudIgnoresGlitch(t *testing.T) {
+	// 9600 baud at 1 MHz is 104.17 samples per bit
+	frames := [][]int{uartBits(0x55, 8), uartBits(0x0F, 8), uartBits(0x33, 8)}
+	g := newSignal("TX")
+	g.set(0, 1).hold(300)
+	// A one-sample glitch must not be taken as the bit time
+	g.set(0, 0).hold(1).set(0, 1).hold(300)
+	start, k := len(g.samples), 0
+	for _, f := range frames {
+		for _, b := range append(f, 1, 1) {
+			k++
+			end := start + int(float64(k)*1e6/9600)
+			g.set(0, b).hold(end - len(g.samples))
+		}
+	}
+
+	est, err := detectBaud(g.session(1000000), []int{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if est.Baud != 9600 {
+		t.Errorf("Baud = %d, want 9600 (measured %.0f)", est.Baud, est.Measured)
+	}
+}
+
+func TestDetectBaudSkipsMisfits(t *testing.T) {
+	// Pulses of 1.45 bits between the frames must not skew the bit time
+	g := newSignal("TX")
+	g.set(0, 1).hold(300)
+	for range 6 {
+		g.set(0, 0).hold(151).set(0, 1).hold(417)
+	}
+	start, k := len(g.samples), 0
+	for _, f := range [][]int{uartBits(0x55, 8), uartBits(0x0F, 8), uartBits(0x33, 8)} {
+		for _, b := range append(f, 1, 1) {
+			k++
+			end := start + int(float64(k)*1e6/9600)
+			g.set(0, b).hold(end - len(g.samples))
+		}
+	}
+
+	est, err := detectBaud(g.session(1000000), []int{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(est.Measured-9600) > 9600*0.005 {
+		t.Errorf("Measured = %.0f, want 9600 within 0.5%%", est.Measured)
+	}
+}
+
+func TestDetectBaudNoActivity(t *testing.T) {
+	g := newSignal("TX").set(0, 1).hold(1000)
+	if _, err := detectBaud(g.session(1000000), []int{0}); err == nil {
+		t.Error("detectBaud succeeded on an idle line")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Backend is the acquisition source behind LazySig. Decoding happens in
+// LazySig itself on the recorded session file, so a backend only needs to
+// list devices and record captures. Scan returns raw tool output so that the
+// parsing in capture.go is shared between implementations.
+type Backend interface {
+	// Scan lists attached devices in `sigrok-cli --scan` format.
+	Scan() ([]byte, error)
+	// Show describes a device's capabilities in `sigrok-cli --show` format.
+	Show(device string) ([]byte, error)
+	// Acquire records a capture into req.OutputFile as a sigrok session.
+	// Cancelling ctx stops the acquisition and returns ctx.Err().
+	Acquire(ctx context.Context, req AcquireRequest) error
+	// Stream starts a continuous capture. It returns the layout of the
+	// samples as a session without logic data, and a reader of raw samples,
+	// UnitSize bytes each. Closing the reader stops the capture.
+	Stream(req AcquireRequest) (*Session, io.ReadCloser, error)
+}
+
+// AcquireRequest describes a single capture.
+type AcquireRequest struct {
+	Device     string // Full device spec, e.g. "fx2lafw:conn=1.43"
+	Channels   string // Channel assignment, e.g. "D0=MISO,D1=MOSI"
+	SampleRate string
+	Trigger    string // sigrok-cli trigger spec, empty for no trigger
+	PreTrigger int    // Percent of the capture kept before the trigger
+	Duration   string
+	OutputFile string
+
+	DeviceChannels int // Logic channels of the device, 0 if unknown
+}
+
+// layout describes the samples a request produces: channel names indexed
+// by hardware channel, which is how sigrok orders the bits of a sample.
+// Samples are as wide as the device sends them, which can be wider than
+// the channels in use.
+func (req AcquireRequest) layout() (*Session, error) {
+	rate, err := parseSampleRate(req.SampleRate)
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{SampleRate: rate}
+	for _, assignment := range strings.Split(req.Channels, ",") {
+		pin, name, renamed := strings.Cut(assignment, "=")
+		if !renamed {
+			name = pin
+		}
+		ch, ok := channelIndex(pin)
+		if !ok {
+			continue
+		}
+		for len(s.Probes) <= ch {
+			s.Probes = append(s.Probes, "")
+		}
+		s.Probes[ch] = name
+	}
+	s.UnitSize = max(1, (len(s.Probes)+7)/8, (req.DeviceChannels+7)/8)
+	return s, nil
+}
+
+// sigrokBackend drives a locally installed sigrok-cli.
+type sigrokBackend struct {
+	path string
+}
+
+func newSigrokBackend() *sigrokBackend {
+	return &sigrokBackend{path: "sigrok-cli"}
+}
+
+func (b *sigrokBackend) Scan() ([]byte, error) {
+	cmd := exec.Command(b.path, "--scan")
+	return cmd.CombinedOutput()
+}
+
+// args returns the sigrok-cli arguments selecting the device, channels,
+// sample rate and trigger of a request.
+func (req AcquireRequest) args() []string {
+	args := []string{"-d", req.Device}
+	if req.Channels != "" {
+		args = append(args, "--channels", req.Channels)
+	}
+	config := "samplerate=" + req.SampleRate
+	if req.Trigger != "" && req.PreTrigger > 0 {
+		config += fmt.Sprintf(":captureratio=%d", req.PreTrigger)
+	}
+	args = append(args, "--config", config)
+	if req.Trigger != "" {
+		args = append(args, "-t", req.Trigger)
+	}
+	return args
+}
+
+func (b *sigrokBackend) Show(device string) ([]byte, error) {
+	cmd := exec.Command(b.path, "-d", device, "--show")
+	return cmd.CombinedOutput()
+}
+
+func (b *sigrokBackend) Acquire(ctx context.Context, req AcquireRequest) error {
+	args := append(req.args(), "--time", req.Duration)
+	args = append(args, "-o", req.OutputFile)
+
+	// The child is killed on cancel; WaitDelay bounds the wait for its
+	// output pipes in case it left a grandchild holding them open
+	cmd := exec.CommandContext(ctx, b.path, args...)
+	cmd.WaitDelay = time.Second
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: %s", err, lastLine(output))
+	}
+	return nil
+}
+
+func (b *sigrokBackend) Stream(req AcquireRequest) (*Session, io.ReadCloser, error) {
+	layout, err := req.layout()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	args := append(req.args(), "--continuous", "-O", "binary")
+
+	cmd := exec.Command(b.path, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+	return layout, &processStream{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+// processStream is the output of a running sigrok-cli; closing it stops the
+// process.
+type processStream struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (p *processStream) Close() error {
+	p.cmd.Process.Kill()
+	p.ReadCloser.Close()
+	p.cmd.Wait()
+	return nil
+}
+
+// lastLine returns the last non-empty line of tool output, which is where
+// sigrok-cli reports the reason for a failure.
+func lastLine(output []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
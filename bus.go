@@ -0,0 +1,364 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Bus is one decoder of a multi-bus capture, e.g. an SPI flash and an I2C
+// sensor wired to the same analyzer.
+type Bus struct {
+	Label    string
+	Settings Settings // Protocol and its settings, keyed as in config.toml
+}
+
+// busLabel is what a label may contain. It becomes part of channel names,
+// which sigrok-cli splits at "," "=" and "-".
+var busLabel = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// protocolKeys lists the settings that belong to each protocol.
+var protocolKeys = map[Protocol][]string{
+	ProtocolSPI:     {"clk", "mosi", "miso", "cs", "cpol", "cpha", "bit-order", "word-size", "cs-polarity", "spi-trigger"},
+	ProtocolI2C:     {"sda", "scl", "addr", "i2c-trigger"},
+	ProtocolUART:    {"tx", "rx", "baud", "data-bits", "parity", "stop-bits", "invert", "uart-trigger"},
+	ProtocolOneWire: {"dq", "speed", "onewire-trigger"},
+	ProtocolCAN:     {"can-rx", "can-tx", "bitrate", "data-bitrate", "can-trigger"},
+	ProtocolSWD:     {"swclk", "swdio", "swd-trigger"},
+	ProtocolJTAG:    {"tck", "tms", "tdi", "tdo", "jtag-trigger"},
+	ProtocolI2S:     {"bclk", "ws", "sd", "word-length", "justify", "slots", "i2s-trigger"},
+	ProtocolModbus:  {"tx", "rx", "baud", "data-bits", "parity", "stop-bits", "invert", "modbus-trigger"},
+}
+
+// multiBus reports whether captures decode several buses.
+func (m model) multiBus() bool {
+	return len(m.buses) > 1
+}
+
+// busSettings collects the protocol settings currently in the model's
+// fields, which belong to the active bus.
+func (m *model) busSettings() Settings {
+	s := Settings{"protocol": m.protocol.String()}
+	fields := m.settingFields()
+	for _, key := range protocolKeys[m.protocol] {
+		s[key] = *fields[key]
+	}
+	return s
+}
+
+// applyBusSettings loads a bus into the model's fields.
+func (m *model) applyBusSettings(s Settings) {
+	fields := m.settingFields()
+	for key, v := range s {
+		if field, ok := fields[key]; ok {
+			*field = v
+		}
+	}
+	if p, err := parseProtocol(s["protocol"]); err == nil {
+		m.protocol = p
+	}
+}
+
+// busModels returns one model per bus, each with the bus's settings in its
+// fields. Without buses the model itself is the only one.
+func (m model) busModels() []model {
+	if !m.multiBus() {
+		return []model{m}
+	}
+	models := make([]model, len(m.buses))
+	for i, bus := range m.buses {
+		models[i] = m
+		if i != m.activeBus {
+			models[i].applyBusSettings(bus.Settings)
+		}
+	}
+	return models
+}
+
+// addBus adds a decoder for the next protocol that isn't decoded yet and
+// makes it the active bus. The first call also turns the current settings
+// into a bus.
+func (m *model) addBus() {
+	if len(m.buses) == 0 {
+		m.buses = []Bus{{Label: m.nextBusLabel(m.protocol), Settings: m.busSettings()}}
+		m.activeBus = 0
+	}
+	m.buses[m.activeBus].Settings = m.busSettings()
+
+	protocol := m.protocol
+	for i := Protocol(1); i <= Protocol(len(protocolNames)); i++ {
+		p := (m.protocol + i) % Protocol(len(protocolNames))
+		if !slices.ContainsFunc(m.buses, func(b Bus) bool { return b.Settings["protocol"] == p.String() }) {
+			protocol = p
+			break
+		}
+	}
+	m.protocol = protocol
+	m.buses = append(m.buses, Bus{Label: m.nextBusLabel(protocol), Settings: m.busSettings()})
+	m.activeBus = len(m.buses) - 1
+	m.statusMsg = fmt.Sprintf("Bus added: %s (%s)", m.buses[m.activeBus].Label, protocol)
+}
+
+// nextBusLabel names a new bus after its protocol, e.g. "i2c" or "i2c2".
+func (m model) nextBusLabel(p Protocol) string {
+	base := strings.ToLower(strings.ReplaceAll(p.String(), "-", ""))
+	label := base
+	for n := 2; slices.ContainsFunc(m.buses, func(b Bus) bool { return strings.EqualFold(b.Label, label) }); n++ {
+		label = base + strconv.Itoa(n)
+	}
+	return label
+}
+
+// switchBus makes another bus active, by offset from the current one.
+func (m *model) switchBus(offset int) {
+	if !m.multiBus() {
+		return
+	}
+	m.buses[m.activeBus].Settings = m.busSettings()
+	m.activeBus = (m.activeBus + offset + len(m.buses)) % len(m.buses)
+	m.applyBusSettings(m.buses[m.activeBus].Settings)
+	m.statusMsg = fmt.Sprintf("Bus %d/%d: %s", m.activeBus+1, len(m.buses), m.buses[m.activeBus].Label)
+}
+
+// removeBus deletes the active bus. Once one bus is left captures decode
+// it alone again.
+func (m *model) removeBus() {
+	if !m.multiBus() {
+		return
+	}
+	removed := m.buses[m.activeBus].Label
+	m.buses = slices.Delete(m.buses, m.activeBus, m.activeBus+1)
+	m.activeBus = min(m.activeBus, len(m.buses)-1)
+	m.applyBusSettings(m.buses[m.activeBus].Settings)
+	if len(m.buses) == 1 {
+		m.buses = nil
+		m.activeBus = 0
+	}
+	m.statusMsg = "Bus removed: " + removed
+}
+
+// validateBusLabel checks a label typed in the Configuration panel.
+func (m model) validateBusLabel(label string) error {
+	if !busLabel.MatchString(label) {
+		return fmt.Errorf("bus label %q may only use letters, digits and _", label)
+	}
+	for i, b := range m.buses {
+		if i != m.activeBus && strings.EqualFold(b.Label, label) {
+			return fmt.Errorf("bus %s already exists", label)
+		}
+	}
+	return nil
+}
+
+// storeBuses adds the buses to saved settings as "buses", the labels in
+// order, and "bus.LABEL.KEY" for their settings. A single-bus setup saves
+// an empty list, so loading it also leaves multi-bus mode.
+func (m model) storeBuses(s Settings) {
+	var labels []string
+	for i, bus := range m.buses {
+		labels = append(labels, bus.Label)
+		settings := bus.Settings
+		if i == m.activeBus {
+			settings = m.busSettings()
+		}
+		for key, v := range settings {
+			s["bus."+bus.Label+"."+key] = v
+		}
+	}
+	s["buses"] = strings.Join(labels, ",")
+}
+
+// loadBuses restores buses from saved settings. Settings without a bus
+// list leave the buses as they are.
+func (m *model) loadBuses(s Settings) []string {
+	list, ok := s["buses"]
+	if !ok {
+		return nil
+	}
+	var warnings []string
+	m.buses, m.activeBus = nil, 0
+	for _, label := range strings.Split(list, ",") {
+		label = strings.TrimSpace(label)
+		if label == "" {
+			continue
+		}
+		if !busLabel.MatchString(label) {
+			warnings = append(warnings, fmt.Sprintf("bus label %q may only use letters, digits and _", label))
+			continue
+		}
+		bus := Bus{Label: label, Settings: Settings{}}
+		for key, v := range s {
+			if k, ok := strings.CutPrefix(key, "bus."+label+"."); ok {
+				bus.Settings[k] = v
+			}
+		}
+		if _, err := parseProtocol(bus.Settings["protocol"]); err != nil {
+			warnings = append(warnings, fmt.Sprintf("bus %s: %v", label, err))
+			continue
+		}
+		m.buses = append(m.buses, bus)
+	}
+	if len(m.buses) > 0 {
+		m.applyBusSettings(m.buses[0].Settings)
+	}
+	if len(m.buses) < 2 {
+		m.buses = nil
+	}
+	return warnings
+}
+
+// newCaptureDecoder builds the decoder for a capture: the protocol decoder
+// of the model, or with several buses one decoder per bus whose records are
+// merged on one timeline.
+//
+// The merged CSV starts with the time and the bus label, followed by the
+// columns of every protocol involved; each row fills in those of its own
+// protocol. Buses sharing a protocol get columns of their own, named after
+// the bus, e.g. "console.tx".
+func newCaptureDecoder(m model, s *Session) (*protocolDecoder, error) {
+	if !m.multiBus() {
+		return newProtocolDecoder(m, s)
+	}
+
+	var decoders []*protocolDecoder
+	header := []string{"time", "bus"}
+	var columns [][]int // Position of each decoder column in the header
+	models := m.busModels()
+	shared := map[Protocol]int{}
+	for _, bm := range models {
+		shared[bm.protocol]++
+	}
+	for i, bm := range models {
+		d, err := newProtocolDecoder(bm, s)
+		if err != nil {
+			return nil, fmt.Errorf("bus %s: %w", m.buses[i].Label, err)
+		}
+		decoders = append(decoders, d)
+		cols := make([]int, len(d.header))
+		for j, name := range d.header[1:] {
+			if shared[bm.protocol] > 1 {
+				name = m.buses[i].Label + "." + name
+			}
+			k := slices.Index(header, name)
+			if k < 0 {
+				k = len(header)
+				header = append(header, name)
+			}
+			cols[j+1] = k
+		}
+		columns = append(columns, cols)
+	}
+
+	md := &multiDecoder{decoders: decoders}
+	p := &protocolDecoder{
+		sampleDecoder: md,
+		header:        header,
+		flush: func(n int64) {
+			for _, d := range decoders {
+				d.flush(n)
+			}
+			md.flushed = true
+		},
+		pending: md.pendingStart,
+	}
+	p.records = func() []decodedRow {
+		matched := p.matcher != nil && p.matcher.fired
+		for i, d := range decoders {
+			p.shareMatcher(d, models[i].protocol)
+			for _, r := range d.records() {
+				merged := make([]string, len(header))
+				merged[0], merged[1] = r.cells[0], m.buses[i].Label
+				for j, v := range r.cells[1:] {
+					merged[columns[i][j+1]] = v
+				}
+				if r.export != nil {
+					r.export.bus = m.buses[i].Label
+				}
+				md.held = append(md.held, decodedRow{start: r.start, cells: merged, export: r.export})
+			}
+			if !matched {
+				p.collectMatch(d)
+			}
+		}
+		return md.release()
+	}
+	return p, nil
+}
+
+// multiDecoder feeds every sample to several decoders and merges their
+// rows. A bus can finish a record well after it started, e.g. a UART frame
+// held back for the other line, so rows are held until no bus can still
+// produce one that starts earlier.
+type multiDecoder struct {
+	decoders []*protocolDecoder
+	next     int64 // Sample after the last one fed
+	flushed  bool
+	held     []decodedRow
+}
+
+func (md *multiDecoder) feed(n int64, sample uint64) {
+	for _, d := range md.decoders {
+		d.feed(n, sample)
+	}
+	md.next = n + 1
+}
+
+// pendingStart is the earliest start of a held row or of a record a bus is
+// still decoding, or -1.
+func (md *multiDecoder) pendingStart() int64 {
+	start := int64(-1)
+	for _, r := range md.held {
+		start = earliest(start, r.start)
+	}
+	for _, d := range md.decoders {
+		start = earliest(start, d.pending())
+	}
+	return start
+}
+
+// release returns the held rows that start before every record still in
+// progress, in time order.
+func (md *multiDecoder) release() []decodedRow {
+	until := md.next
+	if !md.flushed {
+		for _, d := range md.decoders {
+			until = earliest(until, d.pending())
+		}
+	}
+	sort.SliceStable(md.held, func(a, b int) bool { return md.held[a].start < md.held[b].start })
+	n := len(md.held)
+	if !md.flushed {
+		n, _ = slices.BinarySearchFunc(md.held, until, func(r decodedRow, t int64) int { return cmp.Compare(r.start, t) })
+	}
+	done := slices.Clone(md.held[:n])
+	md.held = append(md.held[:0], md.held[n:]...)
+	return done
+}
+
+// shareMatcher gives a bus decoder its own copy of the software trigger.
+// Each bus keeps its own sequence of words, and an address match only
+// applies to I2C buses.
+func (p *protocolDecoder) shareMatcher(d *protocolDecoder, protocol Protocol) {
+	if p.matcher == nil || d.matcher != nil {
+		return
+	}
+	if p.matcher.t.Address >= 0 && protocol != ProtocolI2C {
+		return
+	}
+	d.matcher = newSoftMatcher(p.matcher.t)
+}
+
+// collectMatch takes the earliest match of the bus decoders that fired in
+// the same drain.
+func (p *protocolDecoder) collectMatch(d *protocolDecoder) {
+	if p.matcher == nil || d.matcher == nil || !d.matcher.fired {
+		return
+	}
+	if !p.matcher.fired || d.matcher.at < p.matcher.at {
+		p.matcher.fire(d.matcher.from, d.matcher.at)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// multiBusModel decodes I2C on D0/D1 and UART on D2/D3 at 100 kBd.
+func multiBusModel(t *testing.T) model {
+	t.Helper()
+	m := initialModel(newDemoBackend())
+	m.protocol = ProtocolI2C
+	m.i2cAddress = "any"
+	m.addBus()
+	if m.protocol != ProtocolUART || len(m.buses) != 2 {
+		t.Fatalf("addBus: protocol %v, buses %+v", m.protocol, m.buses)
+	}
+	m.uartTX, m.uartRX, m.uartBaud = "D2", "D3", "100000"
+	return m
+}
+
+func TestMultiBusDecode(t *testing.T) {
+	g := newSignal("D0", "D1", "D2", "D3")
+	g.set(2, 1).set(3, 1)
+	i2c := &i2cBus{g}
+	i2c.set(0, 1).set(1, 1).hold(10)
+	i2c.start().byte(0x50<<1, true, 0).byte(0xA5, true, 0).stop()
+	for _, b := range uartBits('H', 8) {
+		g.set(2, b).hold(10)
+	}
+	g.hold(20)
+	i2c.start().byte(0x68<<1|1, true, 0).byte(0x3C, false, 0).stop()
+
+	path := filepath.Join(t.TempDir(), "multi.sr")
+	if err := writeSession(path, g.session(1000000)); err != nil {
+		t.Fatal(err)
+	}
+	m := multiBusModel(t)
+	header, rows, err := decodeFile(context.Background(), path, m.protocol, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(header[:2], []string{"time", "bus"}) || !slices.Contains(header, "address") || !slices.Contains(header, "tx") {
+		t.Errorf("header = %v", header)
+	}
+	var got []string
+	for _, row := range rows {
+		got = append(got, row[1])
+	}
+	if want := []string{"i2c", "uart", "i2c"}; !slices.Equal(got, want) {
+		t.Fatalf("buses = %v, want %v", got, want)
+	}
+	tx := slices.Index(header, "tx")
+	address := slices.Index(header, "address")
+	if rows[1][tx] != "48" || rows[1][address] != "" || rows[2][address] != "0x68" {
+		t.Errorf("rows = %v", rows)
+	}
+}
+
+func TestMultiBusStreamOrder(t *testing.T) {
+	// An I2C transaction completes inside a slow UART character, which is
+	// only decoded after it although it started first
+	g := newSignal("D0", "D1", "D2", "D3")
+	g.set(2, 1).set(3, 1)
+	i2c := &i2cBus{g}
+	i2c.set(0, 1).set(1, 1).hold(10)
+	g.set(2, 0).hold(50)
+	i2c.start().byte(0x50<<1, true, 0).byte(0xA5, true, 0).stop()
+	g.hold(1000)
+	for _, b := range uartBits('H', 8)[1:] {
+		g.set(2, b).hold(100)
+	}
+	g.hold(200)
+	s := g.session(1000000)
+
+	m := multiBusModel(t)
+	m.uartBaud = "10000"
+	dec, err := newCaptureDecoder(m, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Drained after every sample, as a stream does with small chunks
+	var rows [][]string
+	for i := int64(0); i < s.NumSamples(); i++ {
+		dec.feed(i, s.Sample(i))
+		rows = append(rows, dec.drain()...)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows before the end of the capture, want 2: %v", len(rows), rows)
+	}
+	dec.flush(s.NumSamples())
+	rows = append(rows, dec.drain()...)
+	var got []string
+	for _, row := range rows {
+		got = append(got, row[1])
+	}
+	if want := []string{"uart", "i2c"}; !slices.Equal(got, want) {
+		t.Errorf("buses = %v, want %v", got, want)
+	}
+}
+
+func TestSameProtocolBuses(t *testing.T) {
+	// Two UARTs, each sending one character on its TX
+	g := newSignal("D0", "D1", "D2", "D3")
+	g.set(0, 1).set(1, 1).set(2, 1).set(3, 1).hold(25)
+	for _, ch := range []int{2, 0} {
+		for _, b := range uartBits('A'+ch, 8) {
+			g.set(ch, b).hold(10)
+		}
+		g.hold(15)
+	}
+	path := filepath.Join(t.TempDir(), "uarts.sr")
+	if err := writeSession(path, g.session(1000000)); err != nil {
+		t.Fatal(err)
+	}
+
+	m := initialModel(newDemoBackend())
+	m.protocol = ProtocolUART
+	m.uartBaud = "100000"
+	m.addBus()
+	m.protocol = ProtocolUART
+	m.uartTX, m.uartRX = "D2", "D3"
+	m.buses[1].Label = "gps"
+	header, rows, err := decodeFile(context.Background(), path, m.protocol, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"time", "bus", "uart.tx", "uart.rx", "uart.error", "gps.tx", "gps.rx", "gps.error"}
+	if !slices.Equal(header, want) {
+		t.Fatalf("header = %v, want %v", header, want)
+	}
+	if len(rows) != 2 || rows[0][1] != "gps" || rows[0][5] != "43" || rows[1][1] != "uart" || rows[1][2] != "41" || rows[1][5] != "" {
+		t.Errorf("rows = %v", rows)
+	}
+}
+
+func TestMultiBusCaptureRequest(t *testing.T) {
+	m := multiBusModel(t)
+	m.uartTrigger = "TX=f"
+	req, err := captureRequest(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The active bus comes first and a bare role refers to it
+	if want := "D2=uart.TX,D3=uart.RX,D0=i2c.SDA,D1=i2c.SCL"; req.Channels != want {
+		t.Errorf("channels = %q, want %q", req.Channels, want)
+	}
+	if req.Trigger != "uart.TX=f" {
+		t.Errorf("trigger = %q", req.Trigger)
+	}
+
+	m.uartTX = "D1"
+	if _, err := captureRequest(m); err == nil || !strings.Contains(err.Error(), "uart.TX and i2c.SCL") {
+		t.Errorf("shared pin: err = %v", err)
+	}
+}
+
+func TestBusSettings(t *testing.T) {
+	m := multiBusModel(t)
+	s := m.settings()
+	if s["buses"] != "i2c,uart" || s["bus.uart.tx"] != "D2" || s["bus.i2c.protocol"] != "I2C" {
+		t.Errorf("settings = %v", s)
+	}
+
+	restored := initialModel(newDemoBackend())
+	if warning := restored.applySettings(s); warning != "" {
+		t.Fatal(warning)
+	}
+	if len(restored.buses) != 2 || restored.protocol != ProtocolI2C || restored.i2cAddress != "any" {
+		t.Fatalf("restored %v with buses %+v", restored.protocol, restored.buses)
+	}
+	restored.switchBus(1)
+	if restored.protocol != ProtocolUART || restored.uartTX != "D2" || restored.uartBaud != "100000" {
+		t.Errorf("uart bus = %s %s %s", restored.protocol, restored.uartTX, restored.uartBaud)
+	}
+
+	// Settings saved with one bus leave multi-bus mode
+	single := initialModel(newDemoBackend())
+	restored.applySettings(single.settings())
+	if restored.multiBus() {
+		t.Errorf("still decoding %+v", restored.buses)
+	}
+}
+
+func TestBusKeys(t *testing.T) {
+	m := initialModel(newDemoBackend())
+	m.protocol = ProtocolSPI
+	m = press(t, m, "2", "a")
+	if !m.multiBus() || m.protocol != ProtocolI2C || m.buses[1].Label != "i2c" {
+		t.Fatalf("a: %v %+v", m.protocol, m.buses)
+	}
+	m.i2cSDA, m.i2cSCL = "D4", "D5"
+
+	// The label is the first row and must stay usable in channel names
+	m = press(t, m, "j", "enter")
+	m.editBuffer = "imu-1"
+	m = press(t, m, "enter")
+	if m.buses[1].Label != "i2c" || !strings.Contains(m.statusMsg, "may only use") {
+		t.Errorf("bad label accepted: %q", m.statusMsg)
+	}
+	m = press(t, m, "enter")
+	m.editBuffer = "imu"
+	m = press(t, m, "enter")
+	if m.buses[1].Label != "imu" {
+		t.Errorf("label = %q", m.buses[1].Label)
+	}
+
+	m = press(t, m, "]")
+	if m.activeBus != 0 || m.protocol != ProtocolSPI {
+		t.Errorf("]: bus %d, %v", m.activeBus, m.protocol)
+	}
+	m = press(t, m, "[")
+	if m.activeBus != 1 || m.i2cSDA != "D4" {
+		t.Errorf("[: bus %d, SDA %s", m.activeBus, m.i2cSDA)
+	}
+
+	m = press(t, m, "-")
+	if m.multiBus() || m.protocol != ProtocolSPI {
+		t.Errorf("-: %v %+v", m.protocol, m.buses)
+	}
+}
+
+func TestProjectBuses(t *testing.T) {
+	p, err := loadProject(writeProject(t, t.TempDir(), `buses:
+  sensor:
+    protocol: i2c
+    addr: any
+  console:
+    protocol: uart
+    tx: D2
+    rx: D3
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := initialModel(newDemoBackend())
+	if warning := m.applyProject(p); warning != "" {
+		t.Fatal(warning)
+	}
+	if len(m.buses) != 2 || m.buses[0].Label != "console" || m.protocol != ProtocolUART || m.uartTX != "D2" {
+		t.Errorf("buses = %+v, active %v", m.buses, m.protocol)
+	}
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// CANConfig selects the pins and bitrates of a CAN or CAN FD bus.
+type CANConfig struct {
+	RX, TX string // Transceiver pins; either may be empty
+
+	Bitrate     int // Nominal bitrate, of the arbitration phase
+	DataBitrate int // Bitrate of CAN FD data phases with BRS set
+}
+
+// CANFrame is a data or remote frame, or as much of one as was received
+// before an error.
+type CANFrame struct {
+	Start, End int64
+	ID         uint32
+	Extended   bool
+	Remote     bool
+	FD         bool
+	BRS        bool // Data phase at the data bitrate
+	ESI        bool // Transmitter was error passive
+	DLC        int
+	Data       []byte
+	CRC        uint32
+	ACK        bool
+	TX         bool // Sent by the node on the TX pin
+
+	// Error is "stuff", "form", "crc" or "ack" when the frame was cut short
+	// by an error; the fields decoded up to then are kept
+	Error string
+}
+
+// canSamplePoint is where in a bit the level is read, as a fraction of the
+// bit time, in both phases.
+const canSamplePoint = 0.75
+
+// canFDLengths maps CAN FD DLCs to data lengths.
+var canFDLengths = [16]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 12, 16, 20, 24, 32, 48, 64}
+
+// dataLen is the number of data bytes the frame carries.
+func (f CANFrame) dataLen() int {
+	switch {
+	case f.FD:
+		return canFDLengths[f.DLC]
+	case f.Remote:
+		return 0
+	}
+	return min(f.DLC, 8)
+}
+
+// canConfig validates the CAN fields of the Configuration panel.
+func canConfig(m model) (CANConfig, error) {
+	cfg := CANConfig{RX: m.canRX, TX: m.canTX}
+	var err error
+	if cfg.Bitrate, err = parseIntRange("bitrate", m.canBitrate, 1000, 1000000); err != nil {
+		return cfg, err
+	}
+	if cfg.DataBitrate, err = parseIntRange("data bitrate", m.canDataBitrate, 1000, 16000000); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// canDecoder samples the bus line once per bit, resynchronizing on every
+// recessive to dominant edge, and removes the stuff bits before the fields
+// are read. Dominant is low, as on the logic side of a transceiver.
+type canDecoder struct {
+	cfg      CANConfig
+	bus, tx  int // Bit indexes; tx is -1 when only one line is decoded
+	nominal  float64
+	data     float64
+	idleBits int // Recessive bits needed before a start of frame
+
+	started  bool
+	prev     int
+	idleFrom int64 // Where the bus last went recessive
+
+	inFrame  bool
+	bitLen   float64
+	sampleAt float64
+	frame    CANFrame
+	bits     []byte // Field bits from SOF, stuff bits removed
+	raw      []byte // Bits from SOF to the end of the data, stuff bits included
+	txMatch  bool   // TX agreed with the bus throughout arbitration
+
+	stuffing   bool
+	runLevel   byte
+	runLen     int
+	stuffCount int
+	fixed      int // Bits of the CAN FD CRC field so far, fixed stuff bits included
+
+	// Positions in bits, known as the header is read; 0 until then
+	arbEnd, fdfAt, dlcAt, crcAt, delimAt int
+
+	frames []CANFrame
+}
+
+func newCANDecoder(s *Session, cfg CANConfig) (*canDecoder, error) {
+	if s.SampleRate == 0 {
+		return nil, fmt.Errorf("capture has no sample rate")
+	}
+	d := &canDecoder{cfg: cfg, tx: -1, idleBits: 11}
+	d.nominal = float64(s.SampleRate) / float64(cfg.Bitrate)
+	d.data = float64(s.SampleRate) / float64(cfg.DataBitrate)
+	if d.nominal < 4 {
+		return nil, fmt.Errorf("sample rate %d is too low for %d bit/s", s.SampleRate, cfg.Bitrate)
+	}
+	if d.data < 4 {
+		return nil, fmt.Errorf("sample rate %d is too low for a data bitrate of %d bit/s", s.SampleRate, cfg.DataBitrate)
+	}
+
+	rx, err := resolveChannel(s, "RX", cfg.RX)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := resolveChannel(s, "TX", cfg.TX)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case rx >= 0:
+		d.bus = rx
+		d.tx = tx
+	case tx >= 0:
+		d.bus = tx
+	default:
+		return nil, errMissingPin("RX or TX")
+	}
+	return d, nil
+}
+
+// decodeCAN decodes every frame in a session.
+func decodeCAN(s *Session, cfg CANConfig) ([]CANFrame, error) {
+	d, err := newCANDecoder(s, cfg)
+	if err != nil {
+		return nil, err
+	}
+	decodeSession(s, d)
+	return d.frames, nil
+}
+
+func (d *canDecoder) feed(n int64, sample uint64) {
+	v := int(sample >> d.bus & 1)
+	if !d.started {
+		d.started = true
+		d.prev = v
+		d.idleFrom = n
+		return
+	}
+	prev := d.prev
+	d.prev = v
+	if v == 1 && prev == 0 {
+		d.idleFrom = n
+	}
+
+	if !d.inFrame {
+		// Hard synchronization on the start of frame
+		if prev == 1 && v == 0 && float64(n-d.idleFrom) >= float64(d.idleBits)*d.nominal-d.nominal/2 {
+			d.startFrame(n)
+		}
+		return
+	}
+	// Resynchronization on every recessive to dominant edge
+	if prev == 1 && v == 0 {
+		d.sampleAt = float64(n) + canSamplePoint*d.bitLen
+	}
+	if float64(n) < d.sampleAt {
+		return
+	}
+	d.sampleAt += d.bitLen
+	if d.tx >= 0 && (d.arbEnd == 0 || len(d.bits) <= d.arbEnd) && int(sample>>d.tx&1) != v {
+		d.txMatch = false
+	}
+	d.rawBit(n, byte(v))
+}
+
+// pendingStart is the start of frame of the frame in progress, or -1.
+func (d *canDecoder) pendingStart() int64 {
+	if d.inFrame {
+		return d.frame.Start
+	}
+	return -1
+}
+
+func (d *canDecoder) startFrame(n int64) {
+	d.inFrame = true
+	d.bitLen = d.nominal
+	d.sampleAt = float64(n) + canSamplePoint*d.bitLen
+	d.frame = CANFrame{Start: n}
+	d.bits, d.raw = d.bits[:0], d.raw[:0]
+	d.txMatch = d.tx >= 0
+	d.stuffing = true
+	d.runLevel, d.runLen, d.stuffCount = 0, 0, 0
+	d.fixed = 0
+	d.arbEnd, d.fdfAt, d.dlcAt, d.crcAt, d.delimAt = 0, 0, 0, 0, 0
+}
+
+// rawBit handles a sampled bit, removing dynamic stuff bits and the fixed
+// stuff bits of the CAN FD CRC field.
+func (d *canDecoder) rawBit(n int64, v byte) {
+	if d.stuffing {
+		if d.runLen == 5 {
+			// After five equal bits comes one of the opposite level
+			if v == d.runLevel {
+				d.fail(n, "stuff")
+				return
+			}
+			d.runLevel, d.runLen = v, 1
+			d.stuffCount++
+			d.raw = append(d.raw, v)
+			return
+		}
+		if v == d.runLevel {
+			d.runLen++
+		} else {
+			d.runLevel, d.runLen = v, 1
+		}
+		if d.crcAt == 0 || len(d.bits) < d.crcAt {
+			d.raw = append(d.raw, v)
+		}
+	} else if d.frame.FD && len(d.bits) >= d.crcAt && len(d.bits) < d.delimAt {
+		// A fixed stuff bit precedes the stuff count and every 4 bits after
+		d.fixed++
+		if d.fixed%5 == 1 {
+			if v == d.bits[len(d.bits)-1] {
+				d.fail(n, "form")
+			}
+			return
+		}
+	}
+	d.bit(n, v)
+}
+
+// bit handles a field bit. Positions further on are worked out as the
+// identifier format, frame format and DLC are read.
+func (d *canDecoder) bit(n int64, v byte) {
+	d.bits = append(d.bits, v)
+	i := len(d.bits) - 1
+	f := &d.frame
+
+	switch {
+	case i == 0:
+		if v != 0 {
+			d.fail(n, "form")
+		}
+	case i <= 11:
+		f.ID = f.ID<<1 | uint32(v)
+	case i == 12:
+		f.Remote = v == 1 // Or SRR, of an extended frame
+	case i == 13:
+		f.Extended = v == 1
+		d.arbEnd, d.fdfAt = 12, 14
+		if f.Extended {
+			d.arbEnd, d.fdfAt = 32, 33
+		}
+	case f.Extended && i <= 31:
+		f.ID = f.ID<<1 | uint32(v)
+	case f.Extended && i == 32:
+		f.Remote = v == 1
+	case i == d.fdfAt:
+		f.FD = v == 1
+		if f.FD {
+			f.Remote = false
+			d.dlcAt = d.fdfAt + 4
+		} else if f.Extended {
+			d.dlcAt = d.fdfAt + 2
+		} else {
+			d.dlcAt = d.fdfAt + 1
+		}
+	case f.FD && i == d.fdfAt+2:
+		f.BRS = v == 1
+		if f.BRS {
+			d.bitLen = d.data
+			d.sampleAt += d.data - d.nominal
+		}
+	case f.FD && i == d.fdfAt+3:
+		f.ESI = v == 1
+	case d.dlcAt > 0 && i >= d.dlcAt && i < d.dlcAt+4:
+		f.DLC = f.DLC<<1 | int(v)
+		if i == d.dlcAt+3 {
+			d.crcAt = i + 1 + 8*f.dataLen()
+			d.delimAt = d.crcAt + 15
+			if f.FD {
+				d.delimAt = d.crcAt + 4 + 17
+				if f.dataLen() > 16 {
+					d.delimAt += 4
+				}
+			}
+			d.fieldsDone(i)
+		}
+	case d.crcAt > 0 && i < d.crcAt:
+		k := i - d.dlcAt - 4
+		if k%8 == 0 {
+			f.Data = append(f.Data, 0)
+		}
+		f.Data[k/8] = f.Data[k/8]<<1 | v
+		d.fieldsDone(i)
+	case d.crcAt > 0 && i < d.delimAt:
+		// The CAN FD stuff count comes first and is checked with the CRC
+		if !f.FD || i >= d.crcAt+4 {
+			f.CRC = f.CRC<<1 | uint32(v)
+		}
+	case i == d.delimAt:
+		d.stuffing = false
+		if f.BRS {
+			d.bitLen = d.nominal
+			d.sampleAt += d.nominal - d.data
+		}
+		if v != 1 {
+			d.fail(n, "form")
+		} else if !d.crcOK() {
+			d.fail(n, "crc")
+		}
+	case i == d.delimAt+1:
+		f.ACK = v == 0
+		if !f.ACK {
+			d.fail(n, "ack")
+		}
+	case i <= d.delimAt+8:
+		// ACK delimiter and the end of frame; a dominant last bit is an
+		// overload frame, which doesn't affect this one
+		if v != 1 {
+			d.fail(n, "form")
+			return
+		}
+		if i == d.delimAt+8 {
+			f.End = n
+			f.TX = d.txMatch
+			d.emit(3) // The last end of frame bit and the intermission
+		}
+	}
+}
+
+// fieldsDone ends dynamic stuffing for CAN FD once the data field is
+// complete; the CRC field has fixed stuff bits instead.
+func (d *canDecoder) fieldsDone(i int) {
+	if d.frame.FD && i == d.crcAt-1 {
+		d.stuffing = false
+	}
+}
+
+// crcOK checks the CRC of the frame. Classical frames use CRC-15 over the
+// field bits; CAN FD frames use CRC-17 or CRC-21 over the bits as sent,
+// dynamic stuff bits included, followed by the stuff count.
+func (d *canDecoder) crcOK() bool {
+	f := d.frame
+	if !f.FD {
+		return canCRC(d.bits[:d.crcAt], 15) == f.CRC
+	}
+	count := d.bits[d.crcAt : d.crcAt+4]
+	if stuffCountBits(d.stuffCount) != [4]byte(count) {
+		return false
+	}
+	width := 17
+	if f.dataLen() > 16 {
+		width = 21
+	}
+	return canCRC(append(append([]byte(nil), d.raw...), count...), width) == f.CRC
+}
+
+// canCRC computes a CAN CRC of 15, 17 or 21 bits. The CAN FD ones start
+// from a set top bit, as in ISO 11898-1:2015.
+func canCRC(bits []byte, width int) uint32 {
+	poly := map[int]uint32{15: 0x4599, 17: 0x3685B, 21: 0x302899}[width]
+	var crc uint32
+	if width > 15 {
+		crc = 1 << (width - 1)
+	}
+	top := uint32(1) << (width - 1)
+	mask := top<<1 - 1
+	for _, b := range bits {
+		next := uint32(b) ^ crc&top>>(width-1)
+		crc = crc << 1 & mask
+		if next != 0 {
+			crc ^= poly
+		}
+	}
+	return crc
+}
+
+// stuffCountBits encodes a CAN FD stuff count: the count modulo 8 in Gray
+// code, then an even parity bit.
+func stuffCountBits(count int) [4]byte {
+	g := count % 8
+	g ^= g >> 1
+	b := [4]byte{byte(g >> 2 & 1), byte(g >> 1 & 1), byte(g & 1)}
+	b[3] = b[0] ^ b[1] ^ b[2]
+	return b
+}
+
+// fail ends the frame with an error. Error flags and delimiters follow, so
+// the next frame is only looked for once the bus has been idle for the
+// length of an error delimiter.
+func (d *canDecoder) fail(n int64, kind string) {
+	d.frame.Error = kind
+	d.frame.End = n
+	d.frame.TX = d.txMatch
+	d.emit(7)
+}
+
+// emit finishes the frame and waits for idleBits recessive bits before
+// the next one.
+func (d *canDecoder) emit(idleBits int) {
+	d.inFrame = false
+	d.idleBits = idleBits
+	d.idleFrom = int64(d.sampleAt - d.bitLen)
+	d.frames = append(d.frames, d.frame)
+}
+
+// canHeader is the CSV header of a CAN bus. With both lines connected a dir
+// column tells the frames the node on TX sent from those it received.
+func canHeader(cfg CANConfig) []string {
+	if cfg.RX != "" && cfg.TX != "" {
+		return []string{"time", "dir", "id", "flags", "dlc", "data", "crc", "ack", "error"}
+	}
+	return []string{"time", "id", "flags", "dlc", "data", "crc", "ack", "error"}
+}
+
+// canRow formats a frame as a CSV row.
+func canRow(s *Session, cfg CANConfig, f CANFrame) []string {
+	id := fmt.Sprintf("%03X", f.ID)
+	var flags []string
+	if f.Extended {
+		id = fmt.Sprintf("%08X", f.ID)
+		flags = append(flags, "EXT")
+	}
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{{f.Remote, "RTR"}, {f.FD, "FD"}, {f.BRS, "BRS"}, {f.ESI, "ESI"}} {
+		if flag.set {
+			flags = append(flags, flag.name)
+		}
+	}
+	var data []string
+	for _, b := range f.Data {
+		data = append(data, fmt.Sprintf("%02X", b))
+	}
+	crc, ack := "", ""
+	if f.Error == "" || f.Error == "ack" {
+		width := 15
+		if f.FD {
+			width = 17
+			if f.dataLen() > 16 {
+				width = 21
+			}
+		}
+		crc = formatHex(f.CRC, width)
+		ack = ackText(f.ACK)
+	}
+
+	row := []string{fmt.Sprintf("%.9f", s.Seconds(f.Start))}
+	if cfg.RX != "" && cfg.TX != "" {
+		row = append(row, map[bool]string{true: "tx", false: "rx"}[f.TX])
+	}
+	return append(row, id, strings.Join(flags, " "), fmt.Sprint(f.DLC), strings.Join(data, " "), crc, ack, f.Error)
+}
+
+// SocketCAN error frame bits, from linux/can/error.h.
+const (
+	canErrFlag      = 0x20000000
+	canErrProt      = 0x00000008
+	canErrAck       = 0x00000020
+	canErrProtForm  = 0x02
+	canErrProtStuff = 0x04
+	canErrLocCRC    = 0x08
+)
+
+// writeCandump writes CAN frames in the log format of candump -l, which
+// canplayer and the other can-utils read. Each bus is an interface named
+// after its label, or can0. Errors are logged as error frames, after the
+// frame itself when only the acknowledgement was missing.
+func writeCandump(path string, records []exportRecord) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, r := range records {
+		iface := r.bus
+		if iface == "" {
+			iface = "can0"
+		}
+		// Times are relative to the start of the capture
+		frame := r.record.(CANFrame)
+		us := frame.Start * 1000000 / int64(max(r.s.SampleRate, 1))
+		prefix := fmt.Sprintf("(%010d.%06d) %s ", us/1000000, us%1000000, iface)
+		// A frame that was not acknowledged was still sent whole
+		if frame.Error == "" || frame.Error == "ack" {
+			fmt.Fprintf(w, "%s%s\n", prefix, candumpFrame(frame))
+		}
+		if frame.Error != "" {
+			fmt.Fprintf(w, "%s%s\n", prefix, candumpError(frame.Error))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// candumpFrame formats a frame of a candump log: the ID, then "#" and the
+// data of a classical frame, or "##", the flags and the data of a CAN FD
+// frame.
+func candumpFrame(f CANFrame) string {
+	id := fmt.Sprintf("%03X", f.ID)
+	if f.Extended {
+		id = fmt.Sprintf("%08X", f.ID)
+	}
+	data := fmt.Sprintf("%X", f.Data)
+	switch {
+	case f.FD:
+		var fd int
+		if f.BRS {
+			fd |= 1
+		}
+		if f.ESI {
+			fd |= 2
+		}
+		return fmt.Sprintf("%s##%X%s", id, fd, data)
+	case f.Remote:
+		if f.DLC > 0 {
+			return fmt.Sprintf("%s#R%X", id, f.DLC)
+		}
+		return id + "#R"
+	case f.DLC > 8:
+		// Classical DLCs above 8 still carry 8 bytes
+		return fmt.Sprintf("%s#%s_%X", id, data, f.DLC)
+	}
+	return id + "#" + data
+}
+
+// candumpError formats the error frame for an error of a frame.
+func candumpError(kind string) string {
+	if kind == "ack" {
+		return fmt.Sprintf("%08X#%X", canErrFlag|canErrAck, make([]byte, 8))
+	}
+	var data [8]byte
+	switch kind {
+	case "stuff":
+		data[2] = canErrProtStuff
+	case "form":
+		data[2] = canErrProtForm
+	case "crc":
+		data[3] = canErrLocCRC
+	}
+	return fmt.Sprintf("%08X#%X", canErrFlag|canErrProt, data[:])
+}
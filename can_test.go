@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// canBus drives RX=0 and TX=1 of a synthetic capture at 16 MHz: 32
+// samples per bit at 500 kbit/s and 8 at 2 Mbit/s.
+type canBus struct {
+	*signal
+}
+
+const canTestRate = 16000000
+
+var canTestConfig = CANConfig{RX: "D0", TX: "D1", Bitrate: 500000, DataBitrate: 2000000}
+
+func newCANBus() *canBus {
+	b := &canBus{newSignal("RX", "TX")}
+	b.set(0, 1).set(1, 1).hold(20 * 32)
+	return b
+}
+
+// canEncoder lays out the bits of a frame as sent and how long each is.
+type canEncoder struct {
+	bits, lens []int
+	bitLen     int
+	stuffing   bool
+	level      int
+	run        int
+	stuffed    int
+	fields     []byte // Bits from SOF, without stuff bits
+	raw        []byte // Bits from SOF, with stuff bits
+}
+
+func (e *canEncoder) put(v int) {
+	e.bits = append(e.bits, v)
+	e.lens = append(e.lens, e.bitLen)
+	e.raw = append(e.raw, byte(v))
+	if v == e.level {
+		e.run++
+	} else {
+		e.level, e.run = v, 1
+	}
+}
+
+// field sends a bit, preceded by a stuff bit after five equal ones.
+func (e *canEncoder) field(v int) {
+	if e.stuffing && e.run == 5 {
+		e.put(1 - e.level)
+		e.stuffed++
+	}
+	e.put(v)
+	e.fields = append(e.fields, byte(v))
+}
+
+func (e *canEncoder) value(v uint32, width int) {
+	for i := width - 1; i >= 0; i-- {
+		e.field(int(v >> i & 1))
+	}
+}
+
+// frame sends f; tx says whether the node on TX sends it, ack whether
+// another node acknowledges it. crcXor corrupts the CRC.
+func (b *canBus) frame(f CANFrame, tx, ack bool, crcXor uint32) *canBus {
+	e := &canEncoder{bitLen: 32, stuffing: true, level: -1}
+	e.field(0)
+	if f.Extended {
+		e.value(f.ID>>18, 11)
+		e.field(1)
+		e.field(1)
+		e.value(f.ID, 18)
+	} else {
+		e.value(f.ID, 11)
+	}
+	rtr := 0
+	if f.Remote {
+		rtr = 1
+	}
+	e.field(rtr)
+	if !f.Extended {
+		e.field(0) // IDE
+	}
+	if f.FD {
+		e.field(1)
+		e.field(0)
+		if f.BRS {
+			e.field(1)
+			e.lens[len(e.lens)-1] = 24 + 2 // Sample point at nominal, rest at data timing
+			e.bitLen = 8
+		} else {
+			e.field(0)
+		}
+		esi := 0
+		if f.ESI {
+			esi = 1
+		}
+		e.field(esi)
+	} else {
+		e.field(0)
+		if f.Extended {
+			e.field(0)
+		}
+	}
+	e.value(uint32(f.DLC), 4)
+	for _, v := range f.Data {
+		e.value(uint32(v), 8)
+	}
+
+	if f.FD {
+		e.stuffing = false
+		count := stuffCountBits(e.stuffed)
+		width := 17
+		if len(f.Data) > 16 {
+			width = 21
+		}
+		crc := canCRC(append(e.raw, count[:]...), width) ^ crcXor
+		var payload []int
+		for _, v := range count {
+			payload = append(payload, int(v))
+		}
+		for i := width - 1; i >= 0; i-- {
+			payload = append(payload, int(crc>>i&1))
+		}
+		for i, v := range payload {
+			if i%4 == 0 {
+				e.put(1 - e.bits[len(e.bits)-1])
+			}
+			e.put(v)
+		}
+	} else {
+		e.value(canCRC(e.fields, 15)^crcXor, 15)
+	}
+
+	e.field(1) // CRC delimiter
+	if f.BRS {
+		e.lens[len(e.lens)-1] = 6 + 8
+		e.bitLen = 32
+	}
+	e.stuffing = false
+	e.put(1) // ACK slot, driven by the receivers
+	ackAt := len(e.bits) - 1
+	for range 1 + 7 + 3 {
+		e.put(1)
+	}
+
+	for i, v := range e.bits {
+		rx, txv := v, 1
+		if tx {
+			txv = v
+		}
+		if i == ackAt && ack {
+			rx = 0
+			if !tx {
+				txv = 0
+			}
+		}
+		b.set(0, rx).set(1, txv).hold(e.lens[i])
+	}
+	return b
+}
+
+func TestDecodeCAN(t *testing.T) {
+	fd := CANFrame{ID: 0x456, FD: true, BRS: true, DLC: 11}
+	for i := range 20 {
+		fd.Data = append(fd.Data, byte(i*13))
+	}
+	bus := newCANBus()
+	bus.frame(CANFrame{ID: 0x123, DLC: 4, Data: []byte{0xDE, 0xAD, 0xBE, 0xEF}}, true, true, 0)
+	bus.frame(CANFrame{ID: 0x18DAF110, Extended: true, DLC: 3, Data: []byte{0x02, 0x10, 0x03}}, false, true, 0)
+	bus.frame(CANFrame{ID: 0x7DF, Remote: true, DLC: 8}, false, true, 0)
+	bus.frame(fd, false, true, 0)
+	bus.frame(CANFrame{ID: 0x1FFFFFFF, Extended: true, FD: true, ESI: true, DLC: 3, Data: []byte{0, 0, 0}}, true, true, 0)
+	bus.frame(CANFrame{ID: 0x100, DLC: 1, Data: []byte{0x55}}, false, true, 0x10)
+	bus.frame(CANFrame{ID: 0x200, DLC: 0}, true, false, 0)
+	// A sixth dominant bit in a row breaks the stuffing rule
+	bus.set(0, 0).hold(12*32).set(0, 1).hold(12 * 32)
+	bus.frame(CANFrame{ID: 0x300, DLC: 2, Data: []byte{0xFF, 0xFF}}, false, true, 0)
+
+	s := bus.session(canTestRate)
+	got, err := decodeCAN(s, canTestConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		dir, id, flags, dlc, data, errorKind string
+	}{
+		{"tx", "123", "", "4", "DE AD BE EF", ""},
+		{"rx", "18DAF110", "EXT", "3", "02 10 03", ""},
+		{"rx", "7DF", "RTR", "8", "", ""},
+		{"rx", "456", "FD BRS", "11", "", ""},
+		{"tx", "1FFFFFFF", "EXT FD ESI", "3", "00 00 00", ""},
+		{"rx", "100", "", "1", "55", "crc"},
+		{"tx", "200", "", "0", "", "ack"},
+		{"rx", "000", "", "0", "", "stuff"},
+		{"rx", "300", "", "2", "FF FF", ""},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d frames, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		row := canRow(s, canTestConfig, got[i])
+		if i == 3 {
+			w.data = row[5] // Checked below
+		}
+		if g := []string{row[1], row[2], row[3], row[4], row[5], row[8]}; !slices.Equal(g, []string{w.dir, w.id, w.flags, w.dlc, w.data, w.errorKind}) {
+			t.Errorf("frame %d = %q", i, row)
+		}
+	}
+	if !slices.Equal(got[3].Data, fd.Data) {
+		t.Errorf("FD data = % X, want % X", got[3].Data, fd.Data)
+	}
+	if row := canRow(s, canTestConfig, got[0]); row[7] != "ACK" || row[6] == "" {
+		t.Errorf("crc/ack = %q", row[6:])
+	}
+}
+
+func TestCandumpLog(t *testing.T) {
+	bus := newCANBus()
+	bus.frame(CANFrame{ID: 0x123, DLC: 2, Data: []byte{0x11, 0x22}}, true, true, 0)
+	bus.frame(CANFrame{ID: 0x12345, Extended: true, Remote: true, DLC: 2}, false, true, 0)
+	bus.frame(CANFrame{ID: 0x42, FD: true, BRS: true, DLC: 9, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}}, false, true, 0)
+	bus.frame(CANFrame{ID: 0x7FF, DLC: 10, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}, false, true, 0)
+	bus.frame(CANFrame{ID: 0x100, DLC: 0}, true, true, 1)
+	bus.frame(CANFrame{ID: 0x200, DLC: 0}, true, false, 0)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "can.sr")
+	if err := writeSession(path, bus.session(canTestRate)); err != nil {
+		t.Fatal(err)
+	}
+	m := initialModel(newDemoBackend())
+	m.protocol = ProtocolCAN
+	m.canRX, m.canTX = "D0", "D1"
+	out := filepath.Join(dir, "can.csv")
+	if err := decodeToCSV(context.Background(), path, out, m.protocol, m); err != nil {
+		t.Fatal(err)
+	}
+	log, err := os.ReadFile(filepath.Join(dir, "can.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"(0000000000.000040) can0 123#1122",
+		"can0 00012345#R2",
+		"can0 042##10102030405060708090A0B0C",
+		"can0 7FF#0102030405060708_A",
+		"can0 20000008#0000000800000000",
+		"can0 200#",
+		"can0 20000020#0000000000000000",
+	}
+	lines := strings.Split(strings.TrimSpace(string(log)), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("log:\n%s", log)
+	}
+	for i, w := range want {
+		if !strings.HasSuffix(lines[i], w) {
+			t.Errorf("line %d = %q, want ...%q", i, lines[i], w)
+		}
+	}
+}
+
+func TestCANConfig(t *testing.T) {
+	m := initialModel(newDemoBackend())
+	m.canBitrate = "fast"
+	if _, err := canConfig(m); err == nil {
+		t.Error("bitrate fast accepted")
+	}
+	// One receiver line is enough
+	cfg := CANConfig{TX: "D0", Bitrate: 500000, DataBitrate: 2000000}
+	if _, err := newCANDecoder(newSignal("D0").session(canTestRate), cfg); err != nil {
+		t.Error(err)
+	}
+	cfg.TX = ""
+	if _, err := newCANDecoder(newSignal("D0").session(canTestRate), cfg); err == nil {
+		t.Error("no pins accepted")
+	}
+	if _, err := newCANDecoder(newSignal("D0").session(1000000), canTestConfig); err == nil {
+		t.Error("1 MHz accepted for 500 kbit/s")
+	}
+	// 4 MHz is fast enough for the arbitration phase but not for 2 Mbit/s data
+	if _, err := newCANDecoder(newSignal("D0", "D1").session(4000000), canTestConfig); err == nil || !strings.Contains(err.Error(), "data bitrate") {
+		t.Errorf("4 MHz for 2 Mbit/s data: %v", err)
+	}
+}
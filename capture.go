@@ -1,398 +1,504 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
 	"fmt"
+	"io"
+	"math"
 	"os"
-	"os/exec"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
 
 type LogicAnalyzer struct {
-	ID          string
+	ID          string // Connection, e.g. "1.43"; empty for virtual devices
 	DisplayName string
+
+	Driver   string   // sigrok driver, e.g. "fx2lafw" or "demo"
+	Spec     string   // Device spec for sigrok-cli -d, as printed by --scan
+	Model    string   // e.g. "Saleae Logic"
+	Channels []string // Channel names, empty if --scan did not list them
+
+	// Capabilities from `sigrok-cli --show`; zero values mean unknown
+	Probed      bool
+	SampleRates []uint64 // Discrete rates, ascending
+	MinRate     uint64   // Continuous range, for devices without a list
+	MaxRate     uint64
+	MaxSamples  uint64 // Sample depth limit, 0 for unlimited (streaming)
+	Triggers    string // Supported trigger matches, e.g. "01rfe"
+}
+
+// LogicChannels returns how many of the device's channels are logic
+// channels, i.e. named like "D3" or "3" rather than "A0".
+func (d LogicAnalyzer) LogicChannels() int {
+	n := 0
+	for _, ch := range d.Channels {
+		if _, ok := channelIndex(ch); ok {
+			n++
+		}
+	}
+	return n
 }
 
+// captureFile is where the TUI records captures before decoding them.
+const captureFile = "capture.sr"
+
+// analogNone is the Analog setting that records no analog channels.
+const analogNone = "none"
+
 type captureCompleteMsg struct {
 	err error
 }
 
-func discoverDevices() ([]LogicAnalyzer, error) {
-	cmd := exec.Command("sigrok-cli", "--scan")
-	output, err := cmd.CombinedOutput()
+func discoverDevices(b Backend) ([]LogicAnalyzer, error) {
+	return rescanDevices(b, nil)
+}
+
+// rescanDevices lists the attached devices. Capabilities are only probed
+// for devices that are not in known, so polling stays cheap.
+func rescanDevices(b Backend, known []LogicAnalyzer) ([]LogicAnalyzer, error) {
+	output, err := b.Scan()
 	if err != nil {
 		return nil, fmt.Errorf("failed to scan for devices: %w", err)
 	}
 
 	var devices []LogicAnalyzer
-	lines := strings.Split(string(output), "\n")
-
-	// Parse output like: fx2lafw:conn=1.43 - fx2lafw - fx2lafw
-	re := regexp.MustCompile(`fx2lafw:conn=([0-9.]+)`)
-
-	for _, line := range lines {
-		matches := re.FindStringSubmatch(line)
-		if len(matches) > 1 {
-			connID := matches[1]
-			devices = append(devices, LogicAnalyzer{
-				ID:          connID,
-				DisplayName: fmt.Sprintf("fx2lafw - USB %s", connID),
-			})
+	for _, line := range strings.Split(string(output), "\n") {
+		d, ok := parseDeviceLine(line)
+		if !ok {
+			continue
 		}
+		if i := slices.IndexFunc(known, func(k LogicAnalyzer) bool { return k.Spec == d.Spec }); i >= 0 {
+			devices = append(devices, known[i])
+			continue
+		}
+		// A device whose capabilities can't be read is still usable
+		if show, err := b.Show(d.Spec); err == nil {
+			d.parseShow(show)
+		}
+		devices = append(devices, d)
 	}
 
 	return devices, nil
 }
 
-// isValidHexData checks if a string contains valid hex byte data
-// Returns true if the string contains at least one complete hex byte (00-FF)
-func isValidHexData(s string) bool {
-	if s == "" {
-		return false
+// deviceLine matches a device as sigrok-cli lists it, e.g.
+//
+//	fx2lafw:conn=1.43 - Saleae Logic with 8 channels: D0 D1 D2 D3 D4 D5 D6 D7
+//	demo - Demo device with 12 channels: D0 D1 D2 D3 D4 D5 D6 D7 A0 A1 A2 A3
+var deviceLine = regexp.MustCompile(`^(\S+) - (.+?)(?: with \d+ channels?(?::\s*(.*))?)?$`)
+
+func parseDeviceLine(line string) (LogicAnalyzer, bool) {
+	matches := deviceLine.FindStringSubmatch(strings.TrimSpace(line))
+	if matches == nil {
+		return LogicAnalyzer{}, false
+	}
+	d := LogicAnalyzer{
+		Spec:     matches[1],
+		Model:    matches[2],
+		Channels: strings.Fields(matches[3]),
+	}
+	// The spec is the driver followed by options: driver:conn=1.43:...
+	options := strings.Split(d.Spec, ":")
+	d.Driver = options[0]
+	for _, opt := range options[1:] {
+		if conn, ok := strings.CutPrefix(opt, "conn="); ok {
+			d.ID = conn
+		}
+	}
+
+	d.DisplayName = d.Model
+	if d.ID != "" {
+		d.DisplayName += " - " + d.ID
 	}
-	// Match hex bytes like "00", "FF", "BC 00", etc.
-	hexBytePattern := regexp.MustCompile(`\b[0-9A-Fa-f]{2}\b`)
-	return hexBytePattern.MatchString(s)
+	return d, true
 }
 
-func startCapture(m model) tea.Cmd {
+func startCapture(ctx context.Context, m model) tea.Cmd {
 	return func() tea.Msg {
-		err := runCapture(m)
+		err := runCapture(ctx, m, captureFile)
 		return captureCompleteMsg{err: err}
 	}
 }
 
-func runCapture(m model) error {
-	tmpFile := "capture.sr"
-
-	// Build sigrok-cli command
-	var args []string
-
-	// Use selected device
-	deviceID := "fx2lafw:conn=" + m.devices[m.selectedDevice].ID
-	args = append(args, "-d", deviceID)
-
-	// Configure channels based on protocol
-	if m.protocol == ProtocolSPI {
-		channels := fmt.Sprintf("%s=MISO,%s=MOSI,%s=CLK,%s=CS",
-			m.spiMISO, m.spiMOSI, m.spiCLK, m.spiCS)
-		args = append(args, "--channels", channels)
-	} else if m.protocol == ProtocolI2C {
-		channels := fmt.Sprintf("%s=SDA,%s=SCL",
-			m.i2cSDA, m.i2cSCL)
-		args = append(args, "--channels", channels)
-	} else if m.protocol == ProtocolUART {
-		channels := fmt.Sprintf("%s=TX,%s=RX",
-			m.uartTX, m.uartRX)
-		args = append(args, "--channels", channels)
+// runCapture records a capture into srFile and decodes it to the output
+// file. When ctx is cancelled the partial capture is removed and ctx.Err()
+// is returned.
+func runCapture(ctx context.Context, m model, srFile string) error {
+	req, err := captureRequest(m)
+	if err != nil {
+		return err
 	}
-
-	args = append(args, "--config", "samplerate="+m.sampleRate)
-
-	// Add trigger on CS falling edge for SPI
-	if m.protocol == ProtocolSPI {
-		args = append(args, "-t", "CS=f")
+	req.OutputFile = srFile
+	trigger, err := softTrigger(m)
+	if err != nil {
+		return err
+	}
+	before, after, err := softWindow(m)
+	if trigger != nil && err != nil {
+		return err
+	}
+	// A software trigger is matched as the samples arrive. Analog samples
+	// only come with a recorded capture, so with analog channels the
+	// recording is cut to the window afterwards.
+	if trigger != nil && strings.EqualFold(m.analogChannels, analogNone) {
+		return runTriggeredCapture(ctx, m, req, trigger, before, after)
 	}
-
-	args = append(args, "--time", m.duration)
-	args = append(args, "-o", tmpFile)
 
 	// Run capture
-	cmd := exec.Command("sigrok-cli", args...)
-	if err := cmd.Run(); err != nil {
+	if err := m.backend.Acquire(ctx, req); err != nil {
+		if ctx.Err() != nil {
+			os.Remove(srFile)
+			return ctx.Err()
+		}
 		return fmt.Errorf("capture failed: %w", err)
 	}
 
+	// Keep only the window around a software trigger match
+	if err := trimToMatch(ctx, srFile, m); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
 	// Decode to CSV
-	if err := decodeToCSV(tmpFile, m.outputFile, m.protocol, m); err != nil {
+	if err := decodeToCSV(ctx, srFile, m.outputFile, m.protocol, m); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return fmt.Errorf("decode failed: %w", err)
 	}
 
 	return nil
 }
 
-func decodeToCSV(srFile, outputFile string, protocol Protocol, m model) error {
-	// Create output CSV
-	outFile, err := os.Create(outputFile)
+// runTriggeredCapture streams a single capture of the requested duration
+// through the decoder, keeping recent samples in a rolling buffer, and
+// saves the window around the first software trigger match.
+func runTriggeredCapture(ctx context.Context, m model, req AcquireRequest, trigger *SoftTrigger, before, after time.Duration) error {
+	d, err := time.ParseDuration(m.duration)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("duration must be a time such as 500ms, got %q", m.duration)
+	}
+	layout, reader, err := m.backend.Stream(req)
+	if err != nil {
+		return fmt.Errorf("capture failed: %w", err)
+	}
+	dec, err := newCaptureDecoder(m, layout)
 	if err != nil {
+		reader.Close()
 		return err
 	}
-	defer outFile.Close()
-
-	writer := csv.NewWriter(outFile)
-	defer writer.Flush()
-
-	if protocol == ProtocolSPI {
-		// Use sigrok-cli to decode SPI - show all annotations
-		cmd := exec.Command("sigrok-cli", "-i", srFile,
-			"-P", "spi:clk=CLK:mosi=MOSI:miso=MISO:cs=CS:wordsize=8",
-			"-A", "spi",
-			"-l", "3")
-
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("SPI decode failed: %w", err)
-		}
+	dec.matcher = newSoftMatcher(trigger)
+
+	c := &captureStream{reader: reader}
+	defer context.AfterFunc(ctx, c.stop)()
+	done := c.decodeTriggered(m, req.OutputFile, layout, dec, samplesIn(d, layout.SampleRate), before, after)
+	c.stop()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return done.err
+}
 
-		// Write header
-		writer.Write([]string{"time", "mosi", "miso"})
+// captureRequest builds the acquisition for the current settings.
+func captureRequest(m model) (AcquireRequest, error) {
+	// Use selected device
+	req := AcquireRequest{
+		Device:     m.devices[m.selectedDevice].Spec,
+		SampleRate: m.sampleRate,
+		Duration:   m.duration,
 
-		// Parse SPI decoder output
-		lines := strings.Split(string(output), "\n")
-		sampleRate, _ := strconv.ParseFloat(m.sampleRate, 64)
+		DeviceChannels: m.devices[m.selectedDevice].LogicChannels(),
+	}
 
-		// Track bytes - group MOSI/MISO that occur at same time
-		type spiData struct {
-			timestamp float64
-			mosi      string
-			miso      string
+	// Configure channels based on protocol. With several buses each role
+	// is prefixed with its bus label, e.g. "flash.CS"; the active bus comes
+	// first so a trigger on a bare role refers to it.
+	var connected []channelAssignment
+	models := m.busModels()
+	order := []int{m.activeBus}
+	for i := range models {
+		if i != m.activeBus {
+			order = append(order, i)
 		}
-		dataMap := make(map[string]*spiData)
-
-		for _, line := range lines {
-			// Only process lines that start with sample numbers
-			if !strings.Contains(line, "-") {
-				continue
-			}
-
-			parts := strings.Fields(line)
-			if len(parts) < 3 {
+	}
+	for _, i := range order {
+		for _, c := range protocolChannels(models[i]) {
+			// Unconnected signals are not captured
+			if c.pin == "" {
 				continue
 			}
-
-			// Check if this is a data line (not debug output)
-			if !strings.HasPrefix(parts[0], "cli:") && strings.Contains(line, "spi-1:") {
-				// Extract sample range (e.g., "123-456")
-				sampleRange := parts[0]
-				samples := strings.Split(sampleRange, "-")
-				if len(samples) < 1 {
-					continue
-				}
-
-				startSample, err := strconv.ParseFloat(samples[0], 64)
-				if err != nil {
-					continue
-				}
-
-				timestamp := startSample / sampleRate
-				key := sampleRange
-
-				// Get or create data entry
-				if dataMap[key] == nil {
-					dataMap[key] = &spiData{timestamp: timestamp}
-				}
-
-				// Extract the data value (everything after "spi-1: ")
-				spiIdx := strings.Index(line, "spi-1:")
-				if spiIdx == -1 {
-					continue
-				}
-
-				dataStr := strings.TrimSpace(line[spiIdx+6:])
-
-				// Remove quotes if present
-				dataStr = strings.Trim(dataStr, "\"")
-
-				// If empty string, skip this line - no actual data
-				if dataStr == "" {
-					continue
-				}
-
-				// Determine if this is MOSI or MISO based on position in output
-				// (sigrok outputs MISO first, then MOSI)
-				if dataMap[key].miso == "" {
-					dataMap[key].miso = dataStr
-				} else if dataMap[key].mosi == "" {
-					dataMap[key].mosi = dataStr
-				}
+			if m.multiBus() {
+				c.role = m.buses[i].Label + "." + c.role
 			}
+			connected = append(connected, c)
 		}
-
-		// Write out the data
-		for _, data := range dataMap {
-			if data.mosi != "" || data.miso != "" {
-				// Apply filtering if enabled
-				if m.filterFrames {
-					// Skip frames that don't have valid hex data bytes
-					// Valid data should be at least "00" or contain hex digits
-					mosiValid := isValidHexData(data.mosi)
-					misoValid := isValidHexData(data.miso)
-
-					if !mosiValid && !misoValid {
-						continue
-					}
-				}
-
-				writer.Write([]string{
-					fmt.Sprintf("%.9f", data.timestamp),
-					data.mosi,
-					data.miso,
-				})
+	}
+	// Analog inputs keep their names unless a protocol already uses them
+	if !strings.EqualFold(m.analogChannels, analogNone) {
+		for _, name := range strings.Split(m.analogChannels, ",") {
+			name = strings.TrimSpace(name)
+			used := slices.ContainsFunc(connected, func(c channelAssignment) bool { return strings.EqualFold(c.pin, name) })
+			if name != "" && !used {
+				connected = append(connected, channelAssignment{pin: name, role: name})
 			}
 		}
-	} else if protocol == ProtocolI2C {
-		// I2C decoding
-		var stdout strings.Builder
-		cmd := exec.Command("sigrok-cli", "-i", srFile,
-			"-P", "i2c:scl=SCL:sda=SDA",
-			"-A", "i2c",
-			"-l", "3")
-		cmd.Stdout = &stdout
-
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("I2C decode failed: %w", err)
-		}
+	}
 
-		// Write header
-		writer.Write([]string{"time", "scl", "sda"})
-
-		// Parse I2C decoder output
-		lines := strings.Split(stdout.String(), "\n")
-		sampleRate, _ := strconv.ParseFloat(m.sampleRate, 64)
-
-		for _, line := range lines {
-			if strings.Contains(line, "i2c-1:") {
-				parts := strings.Fields(line)
-				if len(parts) < 3 {
-					continue
-				}
-
-				sampleRange := parts[0]
-				samples := strings.Split(sampleRange, "-")
-				if len(samples) < 1 {
-					continue
-				}
-
-				startSample, err := strconv.ParseFloat(samples[0], 64)
-				if err != nil {
-					continue
-				}
-
-				timestamp := startSample / sampleRate
-				data := strings.Join(parts[2:], " ")
-
-				writer.Write([]string{
-					fmt.Sprintf("%.9f", timestamp),
-					data,
-					"",
-				})
-			}
-		}
-	} else if protocol == ProtocolUART {
-		// UART decoding
-		var stdout strings.Builder
-		baudRate := m.uartBaud
-		cmd := exec.Command("sigrok-cli", "-i", srFile,
-			"-P", fmt.Sprintf("uart:tx=TX:rx=RX:baudrate=%s", baudRate),
-			"-A", "uart",
-			"-l", "3")
-		cmd.Stdout = &stdout
-
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("UART decode failed: %w", err)
+	conds, err := parseTrigger(m.triggerSpec())
+	if err != nil {
+		return req, err
+	}
+	trigger, channels, err := resolveTrigger(conds, connected)
+	req.Trigger = trigger
+	if err != nil {
+		return req, err
+	}
+	if req.Trigger != "" {
+		if req.PreTrigger, err = parseIntRange("pre-trigger", m.preTrigger, 0, 99); err != nil {
+			return req, err
 		}
+	}
+	if err := validateRequest(m.devices[m.selectedDevice], &req, channels); err != nil {
+		return req, err
+	}
 
-		// Write header
-		writer.Write([]string{"time", "tx", "rx"})
-
-		// Parse UART decoder output
-		lines := strings.Split(stdout.String(), "\n")
-		sampleRate, _ := strconv.ParseFloat(m.sampleRate, 64)
-
-		for _, line := range lines {
-			if strings.Contains(line, "uart-1:") {
-				parts := strings.Fields(line)
-				if len(parts) < 3 {
-					continue
-				}
-
-				sampleRange := parts[0]
-				samples := strings.Split(sampleRange, "-")
-				if len(samples) < 1 {
-					continue
-				}
-
-				startSample, err := strconv.ParseFloat(samples[0], 64)
-				if err != nil {
-					continue
-				}
-
-				timestamp := startSample / sampleRate
-				data := strings.Join(parts[2:], " ")
-
-				// Determine if it's TX or RX based on the line content
-				txData := ""
-				rxData := ""
-				if strings.Contains(line, "TX:") || !strings.Contains(line, "RX:") {
-					txData = data
-				} else {
-					rxData = data
-				}
-
-				writer.Write([]string{
-					fmt.Sprintf("%.9f", timestamp),
-					txData,
-					rxData,
-				})
-			}
+	var names []string
+	for _, c := range channels {
+		if strings.EqualFold(c.pin, c.role) {
+			names = append(names, c.pin)
+		} else {
+			names = append(names, c.pin+"="+c.role)
 		}
 	}
+	req.Channels = strings.Join(names, ",")
+
+	return req, nil
+}
 
+// protocolChannels lists the signals of the model's protocol and their
+// pins.
+func protocolChannels(m model) []channelAssignment {
+	switch m.protocol {
+	case ProtocolSPI:
+		channels := []channelAssignment{{m.spiMISO, "MISO"}, {m.spiMOSI, "MOSI"}, {m.spiCLK, "CLK"}}
+		for i, pin := range csPins(m.spiCS) {
+			channels = append(channels, channelAssignment{pin, csRole(i)})
+		}
+		return channels
+	case ProtocolI2C:
+		return []channelAssignment{{m.i2cSDA, "SDA"}, {m.i2cSCL, "SCL"}}
+	case ProtocolUART, ProtocolModbus:
+		return []channelAssignment{{m.uartTX, "TX"}, {m.uartRX, "RX"}}
+	case ProtocolOneWire:
+		return []channelAssignment{{m.oneWireDQ, "DQ"}}
+	case ProtocolCAN:
+		return []channelAssignment{{m.canRX, "RX"}, {m.canTX, "TX"}}
+	case ProtocolSWD:
+		return []channelAssignment{{m.swdCLK, "SWCLK"}, {m.swdIO, "SWDIO"}}
+	case ProtocolJTAG:
+		return []channelAssignment{{m.jtagTCK, "TCK"}, {m.jtagTMS, "TMS"}, {m.jtagTDI, "TDI"}, {m.jtagTDO, "TDO"}}
+	case ProtocolI2S:
+		return []channelAssignment{{m.i2sBCLK, "BCLK"}, {m.i2sWS, "WS"}, {m.i2sSD, "SD"}}
+	}
 	return nil
 }
 
-func generateASCIITrace(srFile string) []string {
-	cmd := exec.Command("sigrok-cli", "-i", srFile, "-O", "ascii")
-	output, err := cmd.CombinedOutput()
+// decodeToCSV decodes a session file into the output CSV, and the extra
+// formats of its protocols next to it. The CSV is only created once
+// decoding has finished, so cancelling ctx leaves any previous output in
+// place.
+func decodeToCSV(ctx context.Context, srFile, outputFile string, protocol Protocol, m model) error {
+	dec, records, err := runDecoder(ctx, srFile, protocol, m)
 	if err != nil {
-		return []string{"Error generating ASCII trace: " + err.Error()}
-	}
-
-	lines := strings.Split(string(output), "\n")
-
-	// Concatenate channel data - sigrok outputs blocks of 74 chars repeatedly
-	channelData := make(map[string]string)
-
-	for _, line := range lines {
-		if strings.HasPrefix(line, "MISO:") {
-			channelData["MISO"] += strings.TrimPrefix(line, "MISO:")
-		} else if strings.HasPrefix(line, "MOSI:") {
-			channelData["MOSI"] += strings.TrimPrefix(line, "MOSI:")
-		} else if strings.HasPrefix(line, "CLK:") {
-			channelData["CLK"] += strings.TrimPrefix(line, "CLK:")
-		} else if strings.HasPrefix(line, "CS:") {
-			channelData["CS"] += strings.TrimPrefix(line, "CS:")
-		} else if strings.HasPrefix(line, "SDA:") {
-			channelData["SDA"] += strings.TrimPrefix(line, "SDA:")
-		} else if strings.HasPrefix(line, "SCL:") {
-			channelData["SCL"] += strings.TrimPrefix(line, "SCL:")
-		}
+		return err
 	}
 
-	// Build result with channel labels
-	result := []string{}
-	if data, ok := channelData["MISO"]; ok && data != "" {
-		result = append(result, "MISO:"+data)
+	// Create output CSV
+	outFile, err := os.Create(outputFile)
+	if err != nil {
+		return err
 	}
-	if data, ok := channelData["MOSI"]; ok && data != "" {
-		result = append(result, "MOSI:"+data)
+	defer outFile.Close()
+	if err := writeCSV(outFile, dec.header, records); err != nil {
+		return err
+	}
+	return writeExports(outputFile, dec.exports)
+}
+
+// decodeFile decodes a session file with the settings of m and returns the
+// CSV header and records.
+func decodeFile(ctx context.Context, srFile string, protocol Protocol, m model) ([]string, [][]string, error) {
+	dec, records, err := runDecoder(ctx, srFile, protocol, m)
+	if err != nil {
+		return nil, nil, err
 	}
-	if data, ok := channelData["CLK"]; ok && data != "" {
-		result = append(result, "CLK:"+data)
+	return dec.header, records, nil
+}
+
+// runDecoder decodes a session file and returns the drained decoder along
+// with the CSV records.
+func runDecoder(ctx context.Context, srFile string, protocol Protocol, m model) (*protocolDecoder, [][]string, error) {
+	session, err := openSession(srFile)
+	if err != nil {
+		return nil, nil, err
 	}
-	if data, ok := channelData["CS"]; ok && data != "" {
-		result = append(result, "CS:"+data)
+	if session, err = decodableSession(session, m); err != nil {
+		return nil, nil, err
 	}
-	if data, ok := channelData["SDA"]; ok && data != "" {
-		result = append(result, "SDA:"+data)
+	m.protocol = protocol
+	dec, err := newCaptureDecoder(m, session)
+	if err != nil {
+		return nil, nil, err
 	}
-	if data, ok := channelData["SCL"]; ok && data != "" {
-		result = append(result, "SCL:"+data)
+	if err := feedSession(ctx, session, dec); err != nil {
+		return nil, nil, err
 	}
+	return dec, dec.drain(), nil
+}
+
+// writeCSV writes decoded records under their header.
+func writeCSV(w io.Writer, header []string, records [][]string) error {
+	writer := csv.NewWriter(w)
+	writer.Write(header)
+	writer.WriteAll(records)
+	return writer.Error()
+}
 
+// feedSession runs a decoder over every sample of a session and flushes
+// it, stopping early if ctx is cancelled.
+func feedSession(ctx context.Context, s *Session, dec *protocolDecoder) error {
+	n := s.NumSamples()
+	for i := int64(0); i < n; i++ {
+		// Checking every sample would dominate the decode time
+		if i&0xFFFFF == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		dec.feed(i, s.Sample(i))
+	}
+	dec.flush(n)
+	return nil
+}
+
+// generateASCIITrace draws every enabled channel of a session as a
+// waveform that is columns characters wide. Columns that contain an edge
+// are drawn as "|". Analog channels follow as sparklines of the column
+// averages, each with a min/max/mean summary.
+func generateASCIITrace(s *Session, columns int) []string {
+	n := s.NumSamples()
+	for _, ch := range s.Analog {
+		n = max(n, int64(len(ch.Samples)))
+	}
+	if n == 0 || columns <= 0 {
+		return []string{"Capture is empty"}
+	}
+
+	labelWidth := 0
+	for _, probe := range s.Probes {
+		labelWidth = max(labelWidth, len(probe))
+	}
+	for _, ch := range s.Analog {
+		labelWidth = max(labelWidth, len(ch.Name))
+	}
+
+	span := (n + int64(columns) - 1) / int64(columns)
+	rows := make([]strings.Builder, len(s.Probes))
+	for start := int64(0); start < s.NumSamples(); start += span {
+		end := min(start+span, s.NumSamples())
+
+		// Track which channels were high for all or any of the column
+		all, any := ^uint64(0), uint64(0)
+		for i := start; i < end; i++ {
+			v := s.Sample(i)
+			all &= v
+			any |= v
+		}
+
+		for ch := range s.Probes {
+			switch {
+			case all>>ch&1 == 1:
+				rows[ch].WriteString("‾")
+			case any>>ch&1 == 0:
+				rows[ch].WriteString("_")
+			default:
+				rows[ch].WriteString("|")
+			}
+		}
+	}
+
+	result := []string{}
+	for ch, probe := range s.Probes {
+		if probe == "" {
+			continue
+		}
+		result = append(result, fmt.Sprintf("%-*s %s", labelWidth+1, probe+":", rows[ch].String()))
+	}
+	for _, ch := range s.Analog {
+		result = append(result,
+			fmt.Sprintf("%-*s %s", labelWidth+1, ch.Name+":", sparkline(ch.Samples, span)),
+			fmt.Sprintf("%-*s %s", labelWidth+1, "", analogSummary(ch.Samples)))
+	}
 	return result
 }
+
+// sparkline draws the average of every span samples as a bar scaled
+// between the minimum and maximum of the channel.
+func sparkline(samples []float32, span int64) string {
+	bars := []rune("▁▂▃▄▅▆▇█")
+	lo, hi, _ := analogStats(samples)
+	var b strings.Builder
+	for start := int64(0); start < int64(len(samples)); start += span {
+		end := min(start+span, int64(len(samples)))
+		var sum float64
+		for _, v := range samples[start:end] {
+			sum += float64(v)
+		}
+		level := 0
+		if hi > lo {
+			mean := sum / float64(end-start)
+			level = min(int((mean-lo)/(hi-lo)*float64(len(bars))), len(bars)-1)
+		}
+		b.WriteRune(bars[level])
+	}
+	return b.String()
+}
+
+// analogStats returns the minimum, maximum and mean of a channel.
+func analogStats(samples []float32) (lo, hi, mean float64) {
+	if len(samples) == 0 {
+		return 0, 0, 0
+	}
+	lo, hi = math.Inf(1), math.Inf(-1)
+	var sum float64
+	for _, v := range samples {
+		lo = min(lo, float64(v))
+		hi = max(hi, float64(v))
+		sum += float64(v)
+	}
+	return lo, hi, sum / float64(len(samples))
+}
+
+// analogSummary formats the statistics of a channel for the trace view.
+func analogSummary(samples []float32) string {
+	lo, hi, mean := analogStats(samples)
+	return fmt.Sprintf("min %.3f  max %.3f  mean %.3f", lo, hi, mean)
+}
+
+// describeSession summarizes a session for the Output panel.
+func describeSession(s *Session) string {
+	n := s.NumSamples()
+	desc := fmt.Sprintf("%s, %d samples (%.3f ms)",
+		formatSampleRate(strconv.FormatUint(s.SampleRate, 10)), n, s.Seconds(n)*1000)
+	if len(s.Analog) > 0 {
+		desc += fmt.Sprintf(", %d analog", len(s.Analog))
+	}
+	return desc
+}
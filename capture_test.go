@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestDiscoverDevices(t *testing.T) {
+	devices, err := discoverDevices(newDemoBackend())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 {
+		t.Fatalf("got %d devices, want 1", len(devices))
+	}
+	if devices[0].ID != "1.43" {
+		t.Errorf("ID = %q, want %q", devices[0].ID, "1.43")
+	}
+}
+
+func TestDiscoverDrivers(t *testing.T) {
+	scan := `The following devices were found:
+demo - Demo device with 12 channels: D0 D1 D2 D3 D4 D5 D6 D7 A0 A1 A2 A3
+dreamsourcelab-dslogic:conn=2.5 - DreamSourceLab DSLogic Plus [S/N: 1234] with 16 channels: 0 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15
+saleae-logic16:conn=3.7 - Saleae Logic16 with 16 channels: 0 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15
+fx2lafw:conn=1.43 - fx2lafw - fx2lafw
+`
+	backend := newReplayBackend(fstest.MapFS{"scan.txt": {Data: []byte(scan)}})
+	devices, err := discoverDevices(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		driver, spec, id, model, name string
+		channels, logic               int
+	}{
+		{"demo", "demo", "", "Demo device", "Demo device", 12, 8},
+		{"dreamsourcelab-dslogic", "dreamsourcelab-dslogic:conn=2.5", "2.5", "DreamSourceLab DSLogic Plus [S/N: 1234]", "DreamSourceLab DSLogic Plus [S/N: 1234] - 2.5", 16, 16},
+		{"saleae-logic16", "saleae-logic16:conn=3.7", "3.7", "Saleae Logic16", "Saleae Logic16 - 3.7", 16, 16},
+		{"fx2lafw", "fx2lafw:conn=1.43", "1.43", "fx2lafw - fx2lafw", "fx2lafw - fx2lafw - 1.43", 0, 0},
+	}
+	if len(devices) != len(want) {
+		t.Fatalf("got %d devices, want %d: %+v", len(devices), len(want), devices)
+	}
+	for i, w := range want {
+		d := devices[i]
+		if d.Driver != w.driver || d.Spec != w.spec || d.ID != w.id || d.Model != w.model || d.DisplayName != w.name {
+			t.Errorf("device %d = %+v, want %+v", i, d, w)
+		}
+		if len(d.Channels) != w.channels || d.LogicChannels() != w.logic {
+			t.Errorf("device %d has %d channels (%d logic), want %d (%d)", i, len(d.Channels), d.LogicChannels(), w.channels, w.logic)
+		}
+	}
+}
+
+// The goldens are written by hand from the edges of demo/capture.sr at
+// 24 MHz, not from decoder output, so a decoder change cannot rewrite them:
+//   - SPI mode 0: words start at their first rising CLK edge, samples 276,
+//     516, 756 and 996
+//   - I2C: START at sample 600 (SDA falls with SCL high), a write of A5 to
+//     0x50 with SCL held low 240 samples longer after the address ACK,
+//     then a repeated START at 5460 reading 3C, NACKed, and STOP at 10020
+//   - UART 115200 8N1: "Hi" on TX from samples 1000 and 3292, "OK" on RX
+//     from 6083 and 8375
+func TestCaptureGolden(t *testing.T) {
+	tests := []struct {
+		protocol Protocol
+		golden   string
+		trigger  string
+	}{
+		{ProtocolSPI, "example_spi.csv", "CS=f"},
+		{ProtocolI2C, "example_i2c.csv", ""},
+		{ProtocolUART, "example_uart.csv", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			golden, err := filepath.Abs(filepath.Join("examples", tt.golden))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Chdir(t.TempDir())
+
+			backend := newDemoBackend()
+			m := initialModel(backend)
+			m.protocol = tt.protocol
+
+			if err := runCapture(context.Background(), m, captureFile); err != nil {
+				t.Fatal(err)
+			}
+			if len(backend.requests) != 1 {
+				t.Fatalf("got %d acquisitions, want 1", len(backend.requests))
+			}
+			if got := backend.requests[0].Trigger; got != tt.trigger {
+				t.Errorf("trigger = %q, want %q", got, tt.trigger)
+			}
+
+			got, err := os.ReadFile(m.outputFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("output mismatch\ngot:\n%s\nwant:\n%s", got, want)
+			}
+
+			m.loadOutputData()
+			if m.outputData[0] != firstLine(want) {
+				t.Errorf("outputData[0] = %q, want %q", m.outputData[0], firstLine(want))
+			}
+		})
+	}
+}
+
+func firstLine(data []byte) string {
+	for i, c := range data {
+		if c == '\n' {
+			return string(data[:i])
+		}
+	}
+	return string(data)
+}
+
+func TestCaptureCancel(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	backend := newDemoBackend()
+	backend.realTime = true
+	m := initialModel(backend)
+	m.duration = "1m"
+
+	// A decode from an earlier capture must survive the cancelled one
+	if err := os.WriteFile(m.outputFile, []byte("previous\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if err := runCapture(ctx, m, captureFile); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+
+	if _, err := os.Stat(captureFile); !os.IsNotExist(err) {
+		t.Errorf("partial %s left behind", captureFile)
+	}
+	got, err := os.ReadFile(m.outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "previous\n" {
+		t.Errorf("output file overwritten: %q", got)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	ossignal "os/signal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cliCommands are the subcommands that run without the TUI.
+var cliCommands = map[string]func(Backend, []string, io.Writer, io.Writer) error{
+	"capture": runCaptureCommand,
+	"decode":  runDecodeCommand,
+}
+
+// errUsage reports bad command-line arguments; the flag package has
+// already printed the details.
+var errUsage = errors.New("usage")
+
+// runCLI runs a headless subcommand and returns the process exit code:
+// 0 on success, 1 when the capture or decode failed and 2 for bad
+// arguments.
+func runCLI(backend Backend, args []string, stdout, stderr io.Writer) int {
+	run, ok := cliCommands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n", args[0])
+		return 2
+	}
+	if err := run(backend, args[1:], stdout, stderr); err != nil {
+		if errors.Is(err, errUsage) {
+			return 2
+		}
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runCaptureCommand implements `lazysig capture`: a single capture with
+// the settings given as flags, decoded to a CSV file.
+func runCaptureCommand(backend Backend, args []string, stdout, stderr io.Writer) error {
+	m := defaultModel(backend)
+	fs := newFlagSet("capture", "", stderr)
+	opts := settingsFlags(fs, &m)
+	device := fs.String("device", "", "device spec, connection or driver (default: first found)")
+	fs.StringVar(&m.sampleRate, "rate", m.sampleRate, "sample rate, e.g. 24M")
+	fs.StringVar(&m.duration, "duration", m.duration, "capture duration, e.g. 500ms")
+	fs.StringVar(&m.outputFile, "out", m.outputFile, "CSV output file")
+	fs.StringVar(&m.preTrigger, "pre-trigger", m.preTrigger, "percent of the capture kept before the trigger")
+	fs.StringVar(&m.softTrigger, "match", m.softTrigger, "software trigger, e.g. \"bytes=9F\" or \"addr=0x50/w\"")
+	fs.StringVar(&m.softBefore, "before", m.softBefore, "time kept before a match")
+	fs.StringVar(&m.softAfter, "after", m.softAfter, "time kept after a match")
+	fs.StringVar(&m.analogChannels, "analog", m.analogChannels, "analog channels to capture, e.g. A0,A1")
+	keep := fs.String("sr", "", "also keep the raw capture as this .sr file")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("capture takes no file arguments, got %q", fs.Arg(0))
+	}
+
+	devices, err := discoverDevices(backend)
+	if err != nil {
+		return err
+	}
+	m.devices = devices
+	if len(devices) > 0 {
+		m.selectDevice(0)
+	}
+	// A profile may pick another device
+	if err := opts.apply(fs, &m, stderr); err != nil {
+		return err
+	}
+	index, err := findDevice(devices, *device)
+	if err != nil {
+		return err
+	}
+	if *device == "" {
+		index = m.selectedDevice
+	}
+	m.selectDevice(index)
+
+	// Rates such as 24M are passed on as plain numbers, as in the TUI
+	rate, err := parseSampleRate(m.sampleRate)
+	if err != nil {
+		return err
+	}
+	m.sampleRate = strconv.FormatUint(rate, 10)
+	if err := m.nextOutputFile(time.Now()); err != nil {
+		return err
+	}
+
+	// Without --sr the raw capture only lives until it is decoded
+	srFile := *keep
+	if srFile == "" {
+		f, err := os.CreateTemp("", "lazysig-*.sr")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		srFile = f.Name()
+		defer os.Remove(srFile)
+	}
+
+	ctx, stop := ossignal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fmt.Fprintf(stderr, "Capturing %s from %s...\n", m.duration, devices[index].DisplayName)
+	if err := runCapture(ctx, m, srFile); err != nil {
+		if errors.Is(err, context.Canceled) {
+			return errors.New("capture interrupted")
+		}
+		return err
+	}
+	fmt.Fprintf(stderr, "Saved to %s\n", m.outputFile)
+	return nil
+}
+
+// runDecodeCommand implements `lazysig decode`: it decodes an existing
+// session file, writing the CSV to stdout unless --out is given.
+func runDecodeCommand(backend Backend, args []string, stdout, stderr io.Writer) error {
+	m := defaultModel(backend)
+	fs := newFlagSet("decode", " FILE.sr", stderr)
+	opts := settingsFlags(fs, &m)
+	out := fs.String("out", "", "CSV output file (default: stdout)")
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		fs.Usage()
+		return errUsage
+	}
+	if err := opts.apply(fs, &m, stderr); err != nil {
+		return err
+	}
+
+	ctx, stop := ossignal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *out != "" {
+		return decodeToCSV(ctx, files[0], *out, m.protocol, m)
+	}
+	header, records, err := decodeFile(ctx, files[0], m.protocol, m)
+	if err != nil {
+		return err
+	}
+	return writeCSV(stdout, header, records)
+}
+
+// newFlagSet creates the flag set of a subcommand with a usage line.
+func newFlagSet(name, operands string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: lazysig %s [flags]%s\n", name, operands)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses flags that may come before or after the operands, as in
+// `lazysig decode capture.sr --protocol i2c`, and returns the operands.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var operands []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, errUsage
+		}
+		if fs.NArg() == 0 {
+			return operands, nil
+		}
+		operands = append(operands, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// cliSettings are the flags that are applied once all flags are parsed
+// rather than bound to the model.
+type cliSettings struct {
+	protocol, trigger, profile string
+}
+
+// settingsFlags binds the protocol and decoder settings shared by every
+// subcommand to m, with the TUI defaults.
+func settingsFlags(fs *flag.FlagSet, m *model) *cliSettings {
+	opts := &cliSettings{}
+	fs.StringVar(&opts.protocol, "protocol", "", "protocol: spi, i2c, uart, 1-wire, can, swd, jtag, i2s or modbus (default spi)")
+	fs.StringVar(&opts.trigger, "trigger", "", "hardware trigger: a preset such as \"START\", \"none\" or a spec like \"CS=f\" (default: the protocol's default)")
+	fs.StringVar(&opts.profile, "profile", "", "start from a profile saved in the TUI")
+
+	fs.StringVar(&m.spiCLK, "clk", m.spiCLK, "SPI clock pin")
+	fs.StringVar(&m.spiMOSI, "mosi", m.spiMOSI, "SPI MOSI pin")
+	fs.StringVar(&m.spiMISO, "miso", m.spiMISO, "SPI MISO pin")
+	fs.StringVar(&m.spiCS, "cs", m.spiCS, "SPI chip select pin, or pins of several devices, e.g. D3,D4")
+	fs.StringVar(&m.spiCPOL, "cpol", m.spiCPOL, "SPI clock polarity (0 or 1)")
+	fs.StringVar(&m.spiCPHA, "cpha", m.spiCPHA, "SPI clock phase (0 or 1)")
+	fs.StringVar(&m.spiBitOrder, "bit-order", m.spiBitOrder, "SPI bit order (MSB or LSB)")
+	fs.StringVar(&m.spiWordSize, "word-size", m.spiWordSize, "SPI bits per word")
+	fs.StringVar(&m.spiCSPolarity, "cs-polarity", m.spiCSPolarity, "SPI chip select active level (low or high)")
+
+	fs.StringVar(&m.i2cSDA, "sda", m.i2cSDA, "I2C data pin")
+	fs.StringVar(&m.i2cSCL, "scl", m.i2cSCL, "I2C clock pin")
+	fs.StringVar(&m.i2cAddress, "addr", m.i2cAddress, "I2C address filter, or any")
+
+	fs.StringVar(&m.uartTX, "tx", m.uartTX, "UART TX pin")
+	fs.StringVar(&m.uartRX, "rx", m.uartRX, "UART RX pin")
+	fs.StringVar(&m.uartBaud, "baud", m.uartBaud, "UART baud rate")
+	fs.StringVar(&m.uartDataBits, "data-bits", m.uartDataBits, "UART data bits (5-9)")
+	fs.StringVar(&m.uartParity, "parity", m.uartParity, "UART parity (none, odd, even, mark or space)")
+	fs.StringVar(&m.uartStopBits, "stop-bits", m.uartStopBits, "UART stop bits (1, 1.5 or 2)")
+	fs.StringVar(&m.uartInvert, "invert", m.uartInvert, "UART idle-low lines (yes or no)")
+
+	fs.StringVar(&m.oneWireDQ, "dq", m.oneWireDQ, "1-Wire data pin")
+	fs.StringVar(&m.oneWireSpeed, "speed", m.oneWireSpeed, "1-Wire speed after a reset (standard or overdrive)")
+
+	fs.StringVar(&m.canRX, "can-rx", m.canRX, "CAN RX pin")
+	fs.StringVar(&m.canTX, "can-tx", m.canTX, "CAN TX pin, to tell sent frames from received ones")
+	fs.StringVar(&m.canBitrate, "bitrate", m.canBitrate, "CAN nominal bitrate")
+	fs.StringVar(&m.canDataBitrate, "data-bitrate", m.canDataBitrate, "CAN FD data phase bitrate")
+
+	fs.StringVar(&m.swdCLK, "swclk", m.swdCLK, "SWD clock pin")
+	fs.StringVar(&m.swdIO, "swdio", m.swdIO, "SWD data pin")
+
+	fs.StringVar(&m.jtagTCK, "tck", m.jtagTCK, "JTAG clock pin")
+	fs.StringVar(&m.jtagTMS, "tms", m.jtagTMS, "JTAG mode select pin")
+	fs.StringVar(&m.jtagTDI, "tdi", m.jtagTDI, "JTAG data in pin, or empty")
+	fs.StringVar(&m.jtagTDO, "tdo", m.jtagTDO, "JTAG data out pin, or empty")
+
+	fs.StringVar(&m.i2sBCLK, "bclk", m.i2sBCLK, "I2S bit clock pin")
+	fs.StringVar(&m.i2sWS, "ws", m.i2sWS, "I2S word select (LRCLK) or TDM frame sync pin")
+	fs.StringVar(&m.i2sSD, "sd", m.i2sSD, "I2S data pin")
+	fs.StringVar(&m.i2sWordLength, "word-length", m.i2sWordLength, "I2S bits per sample (8-32)")
+	fs.StringVar(&m.i2sJustify, "justify", m.i2sJustify, "I2S sample position (i2s, left or right)")
+	fs.StringVar(&m.i2sSlots, "slots", m.i2sSlots, "I2S slots per frame (2 for stereo, more for TDM)")
+
+	fs.StringVar(&m.analogThreshold, "threshold", m.analogThreshold, "voltage at which analog pins read high")
+	fs.BoolVar(&m.filterFrames, "filter", m.filterFrames, "drop empty frames")
+	return opts
+}
+
+// apply seeds the model from the project file of the working directory
+// and the profile, if any, then selects the protocol and sets its trigger.
+// Flags given on the command line take precedence over both.
+func (opts *cliSettings) apply(fs *flag.FlagSet, m *model, stderr io.Writer) error {
+	explicit := map[string]string{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = f.Value.String() })
+
+	if dir, err := os.Getwd(); err == nil {
+		if path := findProject(dir); path != "" {
+			p, err := loadProject(path)
+			if err != nil {
+				return err
+			}
+			if warning := m.applyProject(p); warning != "" {
+				fmt.Fprintf(stderr, "Warning: %s: %s\n", projectFile, warning)
+			}
+		}
+	}
+
+	if opts.profile != "" {
+		path, err := configPath()
+		if err != nil {
+			return err
+		}
+		if m.config, err = loadConfig(path); err != nil {
+			return err
+		}
+		profile, _ := m.lookupProfile(opts.profile)
+		if profile == nil {
+			return fmt.Errorf("no profile %q in %s", opts.profile, path)
+		}
+		if warning := m.applySettings(profile); warning != "" {
+			fmt.Fprintf(stderr, "Warning: %s: %s\n", opts.profile, warning)
+		}
+	}
+
+	for name, value := range explicit {
+		fs.Set(name, value)
+	}
+	if _, ok := explicit["out"]; ok {
+		m.outputPattern = ""
+	}
+	if opts.protocol != "" {
+		p, err := parseProtocol(opts.protocol)
+		if err != nil {
+			return err
+		}
+		m.protocol = p
+	}
+	if opts.trigger != "" {
+		*m.triggerField() = opts.trigger
+	}
+	return nil
+}
+
+// findDevice picks the device named by a spec, connection or driver, or
+// the first device when name is empty.
+func findDevice(devices []LogicAnalyzer, name string) (int, error) {
+	if len(devices) == 0 {
+		return 0, errors.New("no devices found")
+	}
+	if name == "" {
+		return 0, nil
+	}
+	for i, d := range devices {
+		if name == d.Spec || name == d.ID || name == d.Driver {
+			return i, nil
+		}
+	}
+	var specs []string
+	for _, d := range devices {
+		specs = append(specs, d.Spec)
+	}
+	return 0, fmt.Errorf("no device %q (found %s)", name, strings.Join(specs, ", "))
+}
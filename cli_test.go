@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCLICapture(t *testing.T) {
+	golden, err := os.ReadFile(filepath.Join("examples", "example_i2c.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(t.TempDir())
+
+	backend := newDemoBackend()
+	var stdout, stderr bytes.Buffer
+	args := []string{"capture", "--protocol", "i2c", "--sda", "D0", "--scl", "D1", "--rate", "24M",
+		"--duration", "250ms", "--trigger", "START", "--out", "bus.csv", "--sr", "bus.sr"}
+	if code := runCLI(backend, args, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+
+	req := backend.requests[0]
+	if req.SampleRate != "24000000" || req.Duration != "250ms" || req.Trigger != "SDA=f,SCL=1" {
+		t.Errorf("request = %+v", req)
+	}
+	got, err := os.ReadFile("bus.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(golden) {
+		t.Errorf("output mismatch\ngot:\n%s\nwant:\n%s", got, golden)
+	}
+	if _, err := os.Stat("bus.sr"); err != nil {
+		t.Errorf("raw capture not kept: %v", err)
+	}
+	if _, err := os.Stat(captureFile); !os.IsNotExist(err) {
+		t.Errorf("%s written to the working directory", captureFile)
+	}
+}
+
+func TestCLIDecode(t *testing.T) {
+	golden, err := os.ReadFile(filepath.Join("examples", "example_uart.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srFile, err := filepath.Abs(filepath.Join("demo", "capture.sr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flags may follow the file, and the CSV goes to stdout
+	var stdout, stderr bytes.Buffer
+	if code := runCLI(newDemoBackend(), []string{"decode", srFile, "--protocol", "UART"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+	if stdout.String() != string(golden) {
+		t.Errorf("output mismatch\ngot:\n%s\nwant:\n%s", stdout.String(), golden)
+	}
+}
+
+func TestCLIErrors(t *testing.T) {
+	tests := []struct {
+		args []string
+		code int
+		want string
+	}{
+		{[]string{"decode"}, 2, "Usage: lazysig decode"},
+		{[]string{"decode", "a.sr", "--bogus"}, 2, "flag provided but not defined"},
+		{[]string{"decode", "missing.sr"}, 1, "Error:"},
+		{[]string{"decode", "a.sr", "--protocol", "usb"}, 1, "protocol must be one of"},
+		{[]string{"capture", "--device", "dslogic"}, 1, `no device "dslogic"`},
+		{[]string{"capture", "--rate", "7M"}, 1, "does not support a sample rate"},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		code := runCLI(newDemoBackend(), tt.args, &stdout, &stderr)
+		if code != tt.code || !strings.Contains(stderr.String(), tt.want) {
+			t.Errorf("%q: exit code %d, stderr %q; want %d and %q", tt.args, code, stderr.String(), tt.code, tt.want)
+		}
+	}
+}
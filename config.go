@@ -0,0 +1,361 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Settings are the saved values of a model, keyed by the names of their
+// command-line flags, e.g. "clk" or "rate".
+type Settings map[string]string
+
+// Config is the contents of config.toml: the settings of the last session,
+// restored on launch, and the named profiles.
+type Config struct {
+	Settings Settings
+	Profiles map[string]Settings
+}
+
+func newConfig() *Config {
+	return &Config{Settings: Settings{}, Profiles: map[string]Settings{}}
+}
+
+// configPath returns where the config is kept:
+// $XDG_CONFIG_HOME/lazysig/config.toml, or the platform's config directory
+// when XDG_CONFIG_HOME is unset.
+func configPath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		var err error
+		if dir, err = os.UserConfigDir(); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(dir, "lazysig", "config.toml"), nil
+}
+
+// loadConfig reads a config file. A missing file is an empty config.
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return newConfig(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseConfig(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// parseConfig reads the subset of TOML that saveConfig writes: top-level
+// keys followed by one [profiles."name"] table per profile. Values are
+// strings, booleans or numbers, all kept as strings.
+func parseConfig(data string) (*Config, error) {
+	c := newConfig()
+	table := c.Settings
+	for n, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if header, ok := strings.CutPrefix(line, "["); ok {
+			name, ok := strings.CutPrefix(strings.TrimSpace(header), "profiles.")
+			if !ok {
+				return nil, fmt.Errorf("line %d: unknown table %s", n+1, line)
+			}
+			name, err := tomlTableName(name)
+			if err != nil || name == "" {
+				return nil, fmt.Errorf("line %d: bad profile name in %s", n+1, line)
+			}
+			table = Settings{}
+			c.Profiles[name] = table
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: expected key = value", n+1)
+		}
+		v, rest, err := tomlValue(strings.TrimSpace(value))
+		if err != nil || (rest != "" && rest[0] != '#') {
+			return nil, fmt.Errorf("line %d: bad value for %s", n+1, key)
+		}
+		table[key] = v
+	}
+	return c, nil
+}
+
+// tomlValue reads a quoted or bare value and returns it with the rest of
+// the line.
+func tomlValue(s string) (value, rest string, err error) {
+	if !strings.HasPrefix(s, `"`) {
+		value, rest, _ = strings.Cut(s, "#")
+		if rest != "" {
+			rest = "#" + rest
+		}
+		return strings.TrimSpace(value), rest, nil
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			value, err = strconv.Unquote(s[:i+1])
+			return value, strings.TrimSpace(s[i+1:]), err
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string %s", s)
+}
+
+// tomlTableName reads a quoted or bare table name that is followed by the
+// closing "]" of its header and optionally a comment.
+func tomlTableName(s string) (string, error) {
+	name, rest := "", ""
+	if strings.HasPrefix(s, `"`) {
+		var err error
+		if name, rest, err = tomlValue(s); err != nil {
+			return "", err
+		}
+	} else {
+		i := strings.IndexByte(s, ']')
+		if i < 0 {
+			return "", fmt.Errorf("unterminated table header")
+		}
+		name, rest = strings.TrimSpace(s[:i]), s[i:]
+	}
+	rest, ok := strings.CutPrefix(rest, "]")
+	if rest = strings.TrimSpace(rest); !ok || rest != "" && rest[0] != '#' {
+		return "", fmt.Errorf("unterminated table header")
+	}
+	return name, nil
+}
+
+// tomlQuote quotes a string as a TOML basic string.
+func tomlQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r == 0x7F:
+			fmt.Fprintf(&b, `\u%04X`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// saveConfig writes a config file, replacing the old one only once the new
+// one is complete.
+func saveConfig(path string, c *Config) error {
+	var b strings.Builder
+	b.WriteString("# LazySig settings. The top-level keys are restored on launch; each\n")
+	b.WriteString("# [profiles.\"name\"] table is a saved profile.\n")
+	writeSettings(&b, c.Settings)
+
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "\n[profiles.%s]\n", tomlQuote(name))
+		writeSettings(&b, c.Profiles[name])
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// writeSettings writes settings in the order of settingKeys, followed by
+// any keys this version doesn't know.
+func writeSettings(b *strings.Builder, s Settings) {
+	keys := slices.Clone(settingKeys)
+	var extra []string
+	for key := range s {
+		if !slices.Contains(settingKeys, key) {
+			extra = append(extra, key)
+		}
+	}
+	sort.Strings(extra)
+	for _, key := range append(keys, extra...) {
+		if v, ok := s[key]; ok {
+			fmt.Fprintf(b, "%s = %s\n", key, tomlQuote(v))
+		}
+	}
+}
+
+// settingKeys are the keys of Settings in the order they are saved.
+var settingKeys = []string{
+	"device", "protocol",
+	"clk", "mosi", "miso", "cs", "cpol", "cpha", "bit-order", "word-size", "cs-polarity",
+	"sda", "scl", "addr",
+	"tx", "rx", "baud", "data-bits", "parity", "stop-bits", "invert",
+	"dq", "speed",
+	"can-rx", "can-tx", "bitrate", "data-bitrate",
+	"swclk", "swdio",
+	"tck", "tms", "tdi", "tdo",
+	"bclk", "ws", "sd", "word-length", "justify", "slots",
+	"rate", "duration", "out",
+	"spi-trigger", "i2c-trigger", "uart-trigger", "onewire-trigger", "can-trigger", "swd-trigger", "jtag-trigger", "i2s-trigger", "modbus-trigger", "pre-trigger",
+	"match", "before", "after", "analog", "threshold",
+	"mode", "filter", "buses",
+}
+
+// settingFields maps the string settings to the model fields holding them.
+// The device, protocol, mode and filter are converted separately.
+func (m *model) settingFields() map[string]*string {
+	return map[string]*string{
+		"clk":         &m.spiCLK,
+		"mosi":        &m.spiMOSI,
+		"miso":        &m.spiMISO,
+		"cs":          &m.spiCS,
+		"cpol":        &m.spiCPOL,
+		"cpha":        &m.spiCPHA,
+		"bit-order":   &m.spiBitOrder,
+		"word-size":   &m.spiWordSize,
+		"cs-polarity": &m.spiCSPolarity,
+
+		"sda":  &m.i2cSDA,
+		"scl":  &m.i2cSCL,
+		"addr": &m.i2cAddress,
+
+		"tx":        &m.uartTX,
+		"rx":        &m.uartRX,
+		"baud":      &m.uartBaud,
+		"data-bits": &m.uartDataBits,
+		"parity":    &m.uartParity,
+		"stop-bits": &m.uartStopBits,
+		"invert":    &m.uartInvert,
+
+		"dq":    &m.oneWireDQ,
+		"speed": &m.oneWireSpeed,
+
+		"can-rx":       &m.canRX,
+		"can-tx":       &m.canTX,
+		"bitrate":      &m.canBitrate,
+		"data-bitrate": &m.canDataBitrate,
+
+		"swclk": &m.swdCLK,
+		"swdio": &m.swdIO,
+
+		"tck": &m.jtagTCK,
+		"tms": &m.jtagTMS,
+		"tdi": &m.jtagTDI,
+		"tdo": &m.jtagTDO,
+
+		"bclk":        &m.i2sBCLK,
+		"ws":          &m.i2sWS,
+		"sd":          &m.i2sSD,
+		"word-length": &m.i2sWordLength,
+		"justify":     &m.i2sJustify,
+		"slots":       &m.i2sSlots,
+
+		"rate":            &m.sampleRate,
+		"duration":        &m.duration,
+		"out":             &m.outputFile,
+		"spi-trigger":     &m.spiTrigger,
+		"i2c-trigger":     &m.i2cTrigger,
+		"uart-trigger":    &m.uartTrigger,
+		"onewire-trigger": &m.oneWireTrigger,
+		"can-trigger":     &m.canTrigger,
+		"swd-trigger":     &m.swdTrigger,
+		"jtag-trigger":    &m.jtagTrigger,
+		"i2s-trigger":     &m.i2sTrigger,
+		"modbus-trigger":  &m.modbusTrigger,
+		"pre-trigger":     &m.preTrigger,
+		"match":           &m.softTrigger,
+		"before":          &m.softBefore,
+		"after":           &m.softAfter,
+		"analog":          &m.analogChannels,
+		"threshold":       &m.analogThreshold,
+	}
+}
+
+// settings collects the current settings of the model.
+func (m model) settings() Settings {
+	s := Settings{
+		"protocol": m.protocol.String(),
+		"mode":     map[bool]string{true: "continuous", false: "single"}[m.continuous],
+		"filter":   strconv.FormatBool(m.filterFrames),
+	}
+	if len(m.devices) > 0 {
+		s["device"] = m.devices[m.selectedDevice].Spec
+	}
+	for key, field := range m.settingFields() {
+		s[key] = *field
+	}
+	m.storeBuses(s)
+	return s
+}
+
+// applySettings sets the model from saved settings, leaving settings that
+// are missing as they are. It returns a warning for settings that could not
+// be applied, or "" if there were none.
+func (m *model) applySettings(s Settings) string {
+	var warnings []string
+	fields := m.settingFields()
+	for key, v := range s {
+		if field, ok := fields[key]; ok {
+			*field = v
+		}
+	}
+	// Rates may be written as in the CLI, e.g. 24M
+	if rate, err := parseSampleRate(m.sampleRate); err == nil {
+		m.sampleRate = strconv.FormatUint(rate, 10)
+	}
+	if v, ok := s["protocol"]; ok {
+		if p, err := parseProtocol(v); err == nil {
+			m.protocol = p
+		} else {
+			warnings = append(warnings, err.Error())
+		}
+	}
+	warnings = append(warnings, m.loadBuses(s)...)
+	if v, ok := s["mode"]; ok {
+		m.continuous = strings.EqualFold(v, "continuous")
+	}
+	if v, ok := s["filter"]; ok {
+		m.filterFrames, _ = strconv.ParseBool(v)
+	}
+
+	for i, opt := range m.durationOptions {
+		if opt == m.duration {
+			m.durationCursor = i
+		}
+	}
+	if len(m.devices) > 0 {
+		index := m.selectedDevice
+		if spec := s["device"]; spec != "" {
+			i := slices.IndexFunc(m.devices, func(d LogicAnalyzer) bool { return d.Spec == spec })
+			if i >= 0 {
+				index = i
+			} else {
+				warnings = append(warnings, spec+" is not connected")
+			}
+		}
+		// Also offers the device's rates and checks the saved one
+		m.selectDevice(index)
+	}
+	return strings.Join(warnings, "; ")
+}
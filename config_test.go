@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestConfigRoundTrip(t *testing.T) {
+	m := initialModel(newDemoBackend())
+	m.protocol = ProtocolI2C
+	m.i2cSDA = "D4"
+	m.i2cAddress = "any"
+	m.sampleRate = "12000000"
+	m.duration = "250ms"
+	m.i2cTrigger = "START"
+	m.softTrigger = `text="OK"`
+	m.filterFrames = true
+
+	c := newConfig()
+	c.Settings = m.settings()
+	c.Profiles[`imu "i2c" #1`] = m.settings()
+	c.Profiles["flash-on-board-A"] = Settings{"protocol": "SPI", "cs": "D5", "future-key": "kept"}
+
+	path := filepath.Join(t.TempDir(), "lazysig", "config.toml")
+	if err := saveConfig(path, c); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Profiles) != 2 || got.Profiles["flash-on-board-A"]["future-key"] != "kept" {
+		t.Errorf("profiles = %v", got.Profiles)
+	}
+
+	restored := initialModel(newDemoBackend())
+	if warning := restored.applySettings(got.Profiles[`imu "i2c" #1`]); warning != "" {
+		t.Errorf("warning %q", warning)
+	}
+	if restored.protocol != ProtocolI2C || restored.i2cSDA != "D4" || restored.i2cAddress != "any" ||
+		restored.sampleRate != "12000000" || restored.duration != "250ms" || restored.i2cTrigger != "START" ||
+		restored.softTrigger != `text="OK"` || !restored.filterFrames {
+		t.Errorf("settings not restored: %+v", restored.settings())
+	}
+	if restored.durationOptions[restored.durationCursor] != "250ms" {
+		t.Errorf("duration cursor on %s", restored.durationOptions[restored.durationCursor])
+	}
+
+	// Only the keys a profile has are changed
+	restored.applySettings(got.Profiles["flash-on-board-A"])
+	if restored.protocol != ProtocolSPI || restored.spiCS != "D5" || restored.i2cSDA != "D4" {
+		t.Errorf("partial profile applied wrongly: %+v", restored.settings())
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	c, err := parseConfig(`# comment
+protocol = "UART" # trailing comment
+filter = true
+
+[profiles.bare]
+baud = 9600
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Settings["protocol"] != "UART" || c.Settings["filter"] != "true" || c.Profiles["bare"]["baud"] != "9600" {
+		t.Errorf("config = %+v", c)
+	}
+
+	for _, bad := range []string{
+		"[settings]",
+		"protocol",
+		`protocol = "SPI`,
+		`protocol = "SPI" extra`,
+		`[profiles.""]`,
+		`[profiles."a"`,
+		`[profiles."a"] extra`,
+		`[profiles.a`,
+	} {
+		if _, err := parseConfig(bad); err == nil {
+			t.Errorf("parseConfig(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestConfigProfileNames(t *testing.T) {
+	// Brackets, quotes and comment marks are part of the names
+	names := []string{"a]", "[b]", "]", `say "hi"`, "x # y", "profiles.c", `back\slash`}
+	c := newConfig()
+	for i, name := range names {
+		c.Profiles[name] = Settings{"baud": strconv.Itoa(i)}
+	}
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := saveConfig(path, c); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Profiles, c.Profiles) {
+		t.Errorf("profiles = %v, want %v", got.Profiles, c.Profiles)
+	}
+
+	// Headers may also carry a comment, and bare names end at the "]"
+	got, err = parseConfig("[profiles.\"a]\"] # note\nbaud = 1\n[ profiles.bare ]\nbaud = 2\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Profiles["a]"]["baud"] != "1" || got.Profiles["bare"]["baud"] != "2" {
+		t.Errorf("profiles = %v", got.Profiles)
+	}
+}
+
+// press sends keys to the model, one key per string.
+func press(t *testing.T, m model, keys ...string) model {
+	t.Helper()
+	for _, k := range keys {
+		msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)}
+		switch k {
+		case "enter":
+			msg = tea.KeyMsg{Type: tea.KeyEnter}
+		case "esc":
+			msg = tea.KeyMsg{Type: tea.KeyEsc}
+		}
+		next, _ := m.Update(msg)
+		m = next.(model)
+	}
+	return m
+}
+
+func TestProfileKeys(t *testing.T) {
+	m := initialModel(newDemoBackend())
+	m.configPath = filepath.Join(t.TempDir(), "config.toml")
+	m.protocol = ProtocolUART
+	m.uartBaud = "9600"
+
+	// Save as a new profile
+	m = press(t, m, "p", "n", "i", "m", "u", "enter")
+	if _, ok := m.config.Profiles["imu"]; !ok || m.statusMsg != "Profile saved: imu" {
+		t.Fatalf("profile not saved: %q", m.statusMsg)
+	}
+	saved, err := loadConfig(m.configPath)
+	if err != nil || saved.Profiles["imu"]["baud"] != "9600" {
+		t.Fatalf("profile not written: %v %v", saved, err)
+	}
+
+	// Load it back after changing the settings
+	m = press(t, m, "esc")
+	m.protocol = ProtocolSPI
+	m.uartBaud = "115200"
+	m = press(t, m, "p", "enter")
+	if m.protocol != ProtocolUART || m.uartBaud != "9600" || m.selectingProfile {
+		t.Errorf("profile not loaded: %v %s", m.protocol, m.uartBaud)
+	}
+
+	// Rename, refusing a name that is taken
+	m.config.Profiles["other"] = Settings{}
+	m = press(t, m, "p", "r", "enter")
+	if m.statusMsg != "Profile renamed: imu → imu" {
+		t.Errorf("status = %q", m.statusMsg)
+	}
+	m = press(t, m, "r", "2", "enter")
+	if _, ok := m.config.Profiles["imu2"]; !ok || m.profile != "imu2" {
+		t.Errorf("profile not renamed: %v", m.profileNames())
+	}
+	m = press(t, m, "n", "o", "t", "h", "e", "r", "enter")
+	if !strings.Contains(m.statusMsg, "already exists") {
+		t.Errorf("status = %q", m.statusMsg)
+	}
+
+	// Delete
+	m = press(t, m, "esc", "d")
+	if _, ok := m.config.Profiles["imu2"]; ok || m.profile != "" {
+		t.Errorf("profile not deleted: %v", m.profileNames())
+	}
+	saved, _ = loadConfig(m.configPath)
+	if len(saved.Profiles) != 1 {
+		t.Errorf("deletion not written: %v", saved.Profiles)
+	}
+}
+
+func TestCLIProfile(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	path, err := configPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newConfig()
+	c.Profiles["imu-i2c"] = Settings{"protocol": "I2C", "addr": "0x68", "sda": "D0", "scl": "D1"}
+	if err := saveConfig(path, c); err != nil {
+		t.Fatal(err)
+	}
+	srFile, err := filepath.Abs(filepath.Join("demo", "capture.sr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden, err := os.ReadFile(filepath.Join("examples", "example_i2c.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flags override the profile
+	var stdout, stderr bytes.Buffer
+	args := []string{"decode", srFile, "--profile", "imu-i2c", "--addr", "0x50"}
+	if code := runCLI(newDemoBackend(), args, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+	if stdout.String() != string(golden) {
+		t.Errorf("output mismatch\ngot:\n%s\nwant:\n%s", stdout.String(), golden)
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	if code := runCLI(newDemoBackend(), []string{"decode", srFile, "--profile", "nope"}, &stdout, &stderr); code != 1 {
+		t.Errorf("unknown profile: exit code %d", code)
+	}
+}
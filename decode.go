@@ -0,0 +1,429 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// sampleDecoder is a protocol decoder that consumes logic samples one at a
+// time, so the same code can run over a session file or a live stream.
+type sampleDecoder interface {
+	// feed processes sample n, which holds one bit per channel.
+	feed(n int64, sample uint64)
+}
+
+// decodeSession runs a decoder over every sample of a session.
+func decodeSession(s *Session, d sampleDecoder) {
+	n := s.NumSamples()
+	for i := int64(0); i < n; i++ {
+		d.feed(i, s.Sample(i))
+	}
+}
+
+// protocolDecoder wraps the decoder for the selected protocol and turns its
+// records into CSV rows.
+type protocolDecoder struct {
+	sampleDecoder
+	header  []string
+	flush   func(n int64)       // Called once after the last sample
+	records func() []decodedRow // Rows completed since the previous call
+
+	// pending is the start of the earliest record still being decoded, or
+	// -1; no later row can start before it
+	pending func() int64
+
+	// matcher sees every record as it is drained, before filtering
+	matcher *softMatcher
+
+	// exports collects the drained records of protocols with an exporter
+	exports []exportRecord
+}
+
+// decodedRow is a CSV row and the sample its record starts at.
+type decodedRow struct {
+	start  int64
+	cells  []string
+	export *exportRecord // The record for the protocol's exporter, if any
+}
+
+// drain returns the rows completed since the previous call.
+func (p *protocolDecoder) drain() [][]string {
+	var rows [][]string
+	for _, r := range p.records() {
+		rows = append(rows, r.cells)
+		if r.export != nil {
+			p.exports = append(p.exports, *r.export)
+		}
+	}
+	return rows
+}
+
+// earliest returns the earlier of two record starts, either of which may be
+// -1 for none.
+func earliest(a, b int64) int64 {
+	if a < 0 || (b >= 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// newProtocolDecoder builds the decoder for the model's protocol. The
+// session provides channel names and the sample rate; its samples are not
+// read, so a stream can use a session without logic data.
+func newProtocolDecoder(m model, s *Session) (*protocolDecoder, error) {
+	switch m.protocol {
+	case ProtocolSPI:
+		cfg, err := spiConfig(m)
+		if err != nil {
+			return nil, err
+		}
+		d, err := newSPIDecoder(s, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("SPI decode failed: %w", err)
+		}
+		p := &protocolDecoder{
+			sampleDecoder: d,
+			header:        spiHeader(cfg),
+			flush:         func(int64) {},
+			pending:       d.pendingStart,
+		}
+		p.records = func() []decodedRow {
+			var rows []decodedRow
+			for _, word := range d.words {
+				if d.mosi >= 0 {
+					p.matcher.word(0, word.Select, word.Start, word.MOSI)
+				}
+				if d.miso >= 0 {
+					p.matcher.word(1, word.Select, word.Start, word.MISO)
+				}
+				// Skip words cut off by the start of the capture, and
+				// every word when no data line is connected
+				if m.filterFrames && (word.Partial || d.mosi < 0 && d.miso < 0) {
+					continue
+				}
+				rows = append(rows, decodedRow{start: word.Start, cells: spiRow(s, cfg, word)})
+			}
+			d.words = d.words[:0]
+			return rows
+		}
+		return p, nil
+	case ProtocolI2C:
+		cfg, err := i2cConfig(m)
+		if err != nil {
+			return nil, err
+		}
+		d, err := newI2CDecoder(s, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("I2C decode failed: %w", err)
+		}
+		p := &protocolDecoder{
+			sampleDecoder: d,
+			header:        i2cHeader,
+			flush:         func(n int64) { d.finish(n, false) },
+			pending:       d.pendingStart,
+		}
+		// With a register map each transaction is labelled with the
+		// register it addresses
+		var regs *registerTracker
+		if len(m.registers) > 0 {
+			regs = &registerTracker{names: m.registers, pointer: map[int]int{}}
+			p.header = append(slices.Clone(i2cHeader), "register")
+		}
+		p.records = func() []decodedRow {
+			var rows []decodedRow
+			for _, t := range d.transactions {
+				p.matcher.transaction(t)
+				row := i2cRow(s, t)
+				if regs != nil {
+					row = append(row, regs.name(t))
+				}
+				rows = append(rows, decodedRow{start: t.Start, cells: row})
+			}
+			d.transactions = d.transactions[:0]
+			return rows
+		}
+		return p, nil
+	case ProtocolUART:
+		cfg, err := uartConfig(m)
+		if err != nil {
+			return nil, err
+		}
+		d, err := newUARTDecoder(s, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("UART decode failed: %w", err)
+		}
+		p := &protocolDecoder{
+			sampleDecoder: d,
+			header:        uartHeader,
+			flush:         func(int64) { d.flushed = true },
+			pending:       d.pendingStart,
+		}
+		p.records = func() []decodedRow {
+			var rows []decodedRow
+			for _, f := range d.take() {
+				dir := 0
+				if f.RX {
+					dir = 1
+				}
+				p.matcher.word(dir, f.Start, f.Start, uint32(f.Value))
+				rows = append(rows, decodedRow{start: f.Start, cells: uartRow(s, cfg, f)})
+			}
+			return rows
+		}
+		return p, nil
+	case ProtocolOneWire:
+		cfg, err := oneWireConfig(m)
+		if err != nil {
+			return nil, err
+		}
+		d, err := newOneWireDecoder(s, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("1-Wire decode failed: %w", err)
+		}
+		p := &protocolDecoder{
+			sampleDecoder: d,
+			header:        oneWireHeader,
+			flush:         func(int64) { d.finish() },
+			pending:       d.pendingStart,
+		}
+		p.records = func() []decodedRow {
+			var rows []decodedRow
+			for _, t := range d.transactions {
+				for _, b := range t.bytes() {
+					p.matcher.word(0, t.Start, t.Start, uint32(b))
+				}
+				// Skip resets that nothing followed
+				if m.filterFrames && t.ROMCommand < 0 && t.Function < 0 {
+					continue
+				}
+				rows = append(rows, decodedRow{start: t.Start, cells: oneWireRow(s, t)})
+			}
+			d.transactions = d.transactions[:0]
+			return rows
+		}
+		return p, nil
+	case ProtocolCAN:
+		cfg, err := canConfig(m)
+		if err != nil {
+			return nil, err
+		}
+		d, err := newCANDecoder(s, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("CAN decode failed: %w", err)
+		}
+		p := &protocolDecoder{
+			sampleDecoder: d,
+			header:        canHeader(cfg),
+			flush:         func(int64) {},
+			pending:       d.pendingStart,
+		}
+		p.records = func() []decodedRow {
+			var rows []decodedRow
+			for _, f := range d.frames {
+				for _, b := range f.Data {
+					p.matcher.word(0, f.Start, f.Start, uint32(b))
+				}
+				// Skip frames cut short by errors
+				if m.filterFrames && f.Error != "" {
+					continue
+				}
+				rows = append(rows, decodedRow{start: f.Start, cells: canRow(s, cfg, f), export: &exportRecord{m: m, s: s, record: f}})
+			}
+			d.frames = d.frames[:0]
+			return rows
+		}
+		return p, nil
+	case ProtocolSWD:
+		d, err := newSWDDecoder(s, SWDConfig{CLK: m.swdCLK, IO: m.swdIO})
+		if err != nil {
+			return nil, fmt.Errorf("SWD decode failed: %w", err)
+		}
+		p := &protocolDecoder{
+			sampleDecoder: d,
+			header:        swdHeader,
+			flush:         func(int64) {},
+			pending:       d.pendingStart,
+		}
+		p.records = func() []decodedRow {
+			var rows []decodedRow
+			for _, t := range d.transfers {
+				if t.HasData {
+					dir := 0
+					if t.Read {
+						dir = 1
+					}
+					p.matcher.word(dir, t.Start, t.Start, t.Data)
+				}
+				// Skip WAIT responses, which the debugger retries
+				if m.filterFrames && t.ACK == swdWait {
+					continue
+				}
+				rows = append(rows, decodedRow{start: t.Start, cells: swdRow(s, t)})
+			}
+			d.transfers = d.transfers[:0]
+			return rows
+		}
+		return p, nil
+	case ProtocolJTAG:
+		cfg := JTAGConfig{TCK: m.jtagTCK, TMS: m.jtagTMS, TDI: m.jtagTDI, TDO: m.jtagTDO}
+		d, err := newJTAGDecoder(s, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("JTAG decode failed: %w", err)
+		}
+		p := &protocolDecoder{
+			sampleDecoder: d,
+			header:        jtagHeader,
+			flush:         func(int64) {},
+			pending:       d.pendingStart,
+		}
+		p.records = func() []decodedRow {
+			var rows []decodedRow
+			for _, t := range d.shifts {
+				if !t.IR && len(t.TDI) > 0 && len(t.TDI) <= 32 {
+					p.matcher.word(0, t.Start, t.Start, uint32(bitsValue(t.TDI, 0, 32)))
+					p.matcher.word(1, t.Start, t.Start, uint32(bitsValue(t.TDO, 0, 32)))
+				}
+				// Skip scans through the 1-bit bypass register
+				if m.filterFrames && !t.IR && t.Selected == "BYPASS" {
+					continue
+				}
+				rows = append(rows, decodedRow{start: t.Start, cells: jtagRow(s, cfg, t)})
+			}
+			d.shifts = d.shifts[:0]
+			return rows
+		}
+		return p, nil
+	case ProtocolI2S:
+		cfg, err := i2sConfig(m)
+		if err != nil {
+			return nil, err
+		}
+		d, err := newI2SDecoder(s, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("I2S decode failed: %w", err)
+		}
+		p := &protocolDecoder{
+			sampleDecoder: d,
+			header:        i2sHeader(cfg),
+			flush:         func(int64) {},
+			pending:       d.pendingStart,
+		}
+		p.records = func() []decodedRow {
+			var rows []decodedRow
+			for _, f := range d.frames {
+				for _, v := range f.Samples {
+					p.matcher.word(0, f.Start, f.Start, uint32(v)&(1<<cfg.WordLength-1))
+				}
+				rows = append(rows, decodedRow{start: f.Start, cells: i2sRow(s, cfg, f), export: &exportRecord{m: m, s: s, record: f}})
+			}
+			d.frames = d.frames[:0]
+			return rows
+		}
+		return p, nil
+	case ProtocolModbus:
+		cfg, err := uartConfig(m)
+		if err != nil {
+			return nil, err
+		}
+		d, err := newModbusDecoder(s, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("Modbus decode failed: %w", err)
+		}
+		p := &protocolDecoder{
+			sampleDecoder: d,
+			header:        modbusHeader,
+			flush:         func(int64) { d.finish() },
+			pending:       d.pendingStart,
+		}
+		p.records = func() []decodedRow {
+			d.collect()
+			var rows []decodedRow
+			for _, t := range d.transactions {
+				for _, msg := range []*ModbusMessage{t.Request, t.Response} {
+					if msg == nil {
+						continue
+					}
+					dir := 0
+					if msg.RX {
+						dir = 1
+					}
+					for _, b := range msg.Bytes {
+						p.matcher.word(dir, msg.Start, msg.Start, uint32(b))
+					}
+				}
+				// Skip line noise too short to be a message
+				if m.filterFrames && t.first().short() {
+					continue
+				}
+				rows = append(rows, decodedRow{start: t.first().Start, cells: modbusRow(s, t)})
+			}
+			d.transactions = d.transactions[:0]
+			return rows
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("unknown protocol")
+}
+
+// decodableSession returns the session with its analog channels also
+// available as logic channels, split at the configured threshold.
+func decodableSession(s *Session, m model) (*Session, error) {
+	if len(s.Analog) == 0 {
+		return s, nil
+	}
+	threshold, err := strconv.ParseFloat(strings.TrimSpace(m.analogThreshold), 64)
+	if err != nil {
+		return nil, fmt.Errorf("analog threshold must be a number, got %q", m.analogThreshold)
+	}
+	return s.Digitize(threshold)
+}
+
+// resolveChannel finds the bit index for a protocol signal. Captures made by
+// LazySig name each probe after its role (e.g. "CLK"), so that name is tried
+// first before falling back to the configured pin. Multi-bus captures add
+// the bus label, e.g. "flash.CS", and resolve by pin. An empty pin means the
+// signal is not connected and resolves to -1.
+func resolveChannel(s *Session, role, pin string) (int, error) {
+	if pin == "" {
+		return -1, nil
+	}
+	if ch, err := s.Channel(role); err == nil && s.Probes[ch] != "" {
+		return ch, nil
+	}
+	ch, err := s.Channel(pin)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", role, err)
+	}
+	return ch, nil
+}
+
+// parseChoice matches a config value against a list of allowed values,
+// ignoring case, and returns its index.
+func parseChoice(field, value string, choices ...string) (int, error) {
+	for i, c := range choices {
+		if strings.EqualFold(strings.TrimSpace(value), c) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%s must be one of %s, got %q", field, strings.Join(choices, "/"), value)
+}
+
+// parseIntRange parses a config value and checks it against [lo, hi].
+func parseIntRange(field, value string, lo, hi int) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n < lo || n > hi {
+		return 0, fmt.Errorf("%s must be between %d and %d, got %q", field, lo, hi, value)
+	}
+	return n, nil
+}
+
+// formatHex renders a word with as many hex digits as its bit width needs.
+func formatHex(v uint32, bits int) string {
+	return fmt.Sprintf("%0*X", (bits+3)/4, v)
+}
+
+func errMissingPin(role string) error {
+	return fmt.Errorf("%s pin is required", role)
+}
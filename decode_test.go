@@ -0,0 +1,31 @@
+package main
+
+// signal builds a synthetic capture one level change at a time.
+type signal struct {
+	probes  []string
+	samples []byte
+	level   byte
+}
+
+func newSignal(probes ...string) *signal {
+	return &signal{probes: probes}
+}
+
+// set changes channel ch to v for the following samples.
+func (g *signal) set(ch int, v int) *signal {
+	g.level &^= 1 << ch
+	g.level |= byte(v&1) << ch
+	return g
+}
+
+// hold appends n samples at the current levels.
+func (g *signal) hold(n int) *signal {
+	for i := 0; i < n; i++ {
+		g.samples = append(g.samples, g.level)
+	}
+	return g
+}
+
+func (g *signal) session(rate uint64) *Session {
+	return &Session{SampleRate: rate, UnitSize: 1, Probes: g.probes, Logic: g.samples}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// rateRange matches a continuous sample rate range in `--show` output, e.g.
+// "samplerate (1 Hz - 1 GHz in steps of 1 Hz)".
+var rateRange = regexp.MustCompile(`^samplerate \((.+?) - (.+?) in steps of .+\)`)
+
+// parseShow fills the capabilities of a device from `sigrok-cli --show`
+// output. Sections it doesn't recognise are skipped.
+func (d *LogicAnalyzer) parseShow(output []byte) {
+	d.Probed = true
+	inRates := false
+	for _, raw := range strings.Split(string(output), "\n") {
+		line := strings.TrimSpace(raw)
+
+		// The rate list is indented below its heading
+		if inRates {
+			if strings.HasPrefix(raw, "      ") {
+				v := strings.TrimSuffix(line, "(current)")
+				if rate, err := parseSampleRate(v); err == nil {
+					d.SampleRates = append(d.SampleRates, rate)
+				}
+				continue
+			}
+			inRates = false
+		}
+
+		if desc, ok := parseDeviceLine(line); ok && desc.Spec == d.Spec && len(desc.Channels) > 0 {
+			d.Channels = desc.Channels
+			continue
+		}
+		if v, ok := strings.CutPrefix(line, "Supported triggers:"); ok {
+			d.Triggers = strings.Join(strings.Fields(v), "")
+			continue
+		}
+		if strings.HasPrefix(line, "samplerate - supported samplerates") {
+			inRates = true
+			continue
+		}
+		if m := rateRange.FindStringSubmatch(line); m != nil {
+			d.MinRate, _ = parseSampleRate(m[1])
+			d.MaxRate, _ = parseSampleRate(m[2])
+			continue
+		}
+		if v, ok := strings.CutPrefix(line, "Maximum number of samples:"); ok {
+			d.MaxSamples, _ = strconv.ParseUint(strings.TrimSpace(v), 10, 64)
+		}
+	}
+	sort.Slice(d.SampleRates, func(i, j int) bool { return d.SampleRates[i] < d.SampleRates[j] })
+}
+
+// supportsRate reports whether the device can sample at rate. Devices that
+// were not probed accept any rate.
+func (d LogicAnalyzer) supportsRate(rate uint64) bool {
+	if len(d.SampleRates) > 0 {
+		for _, r := range d.SampleRates {
+			if r == rate {
+				return true
+			}
+		}
+		return false
+	}
+	if d.MaxRate > 0 {
+		return rate >= d.MinRate && rate <= d.MaxRate
+	}
+	return true
+}
+
+// rateOptions lists the sample rates offered in the Capture panel, fastest
+// first: the device's own rates down to 1 MHz where it has a list, or the
+// usual rates within its range.
+func (d LogicAnalyzer) rateOptions() []string {
+	defaults := []uint64{48000000, 24000000, 16000000, 12000000, 8000000, 6000000, 4000000, 2000000, 1000000}
+
+	var options []string
+	if len(d.SampleRates) > 0 {
+		// Slow rates are rarely useful for bus decoding; Custom... still
+		// reaches them
+		for i := len(d.SampleRates) - 1; i >= 0; i-- {
+			if d.SampleRates[i] >= 1000000 || len(options) == 0 {
+				options = append(options, strconv.FormatUint(d.SampleRates[i], 10))
+			}
+		}
+	} else {
+		for _, r := range defaults {
+			if d.supportsRate(r) {
+				options = append(options, strconv.FormatUint(r, 10))
+			}
+		}
+	}
+	return append(options, "Custom...")
+}
+
+// channelName returns the device's own name for a pin, so "D3" can be used
+// with a device that numbers its channels "0" to "15". Devices that did not
+// list their channels accept any pin as given.
+func (d LogicAnalyzer) channelName(pin string) (string, bool) {
+	if len(d.Channels) == 0 {
+		return pin, true
+	}
+	for _, ch := range d.Channels {
+		if strings.EqualFold(ch, pin) || samePin(ch, pin) {
+			return ch, true
+		}
+	}
+	return "", false
+}
+
+// validateRequest checks a capture against the selected device before it
+// starts, and rewrites pins to the device's channel names.
+func validateRequest(d LogicAnalyzer, req *AcquireRequest, channels []channelAssignment) error {
+	// Two roles on one pin is always a configuration mistake
+	for i, a := range channels {
+		for _, b := range channels[:i] {
+			if samePin(a.pin, b.pin) {
+				return fmt.Errorf("%s and %s are both on %s", b.role, a.role, a.pin)
+			}
+		}
+	}
+
+	for i, a := range channels {
+		name, ok := d.channelName(a.pin)
+		if !ok {
+			return fmt.Errorf("%s pin %s is not a channel of %s (%s)",
+				a.role, a.pin, d.Model, strings.Join(d.Channels, " "))
+		}
+		channels[i].pin = name
+	}
+
+	rate, err := parseSampleRate(req.SampleRate)
+	if err != nil {
+		return err
+	}
+	if !d.supportsRate(rate) {
+		return fmt.Errorf("%s does not support a sample rate of %s; pick one from the Rate list",
+			d.Model, formatSampleRate(strconv.FormatUint(rate, 10)))
+	}
+
+	if dur, err := time.ParseDuration(req.Duration); err == nil && d.MaxSamples > 0 {
+		if samples := uint64(dur.Seconds() * float64(rate)); samples > d.MaxSamples {
+			return fmt.Errorf("%s at %s is %d samples, but %s holds at most %d; shorten the duration or lower the rate",
+				req.Duration, formatSampleRate(strconv.FormatUint(rate, 10)), samples, d.Model, d.MaxSamples)
+		}
+	}
+
+	if req.Trigger != "" && d.Probed {
+		if d.Triggers == "" {
+			return fmt.Errorf("%s has no hardware trigger; set Trigger to none", d.Model)
+		}
+		for _, cond := range strings.Split(req.Trigger, ",") {
+			_, match, _ := strings.Cut(cond, "=")
+			if !strings.Contains(d.Triggers, match) {
+				return fmt.Errorf("%s cannot trigger on %q (supports %s)",
+					d.Model, match, strings.Join(strings.Split(d.Triggers, ""), " "))
+			}
+		}
+	}
+	return nil
+}
+
+// devicePollInterval is how often the device list is refreshed in the
+// background.
+const devicePollInterval = 3 * time.Second
+
+// pollDevicesMsg asks for a background rescan.
+type pollDevicesMsg struct{}
+
+// devicesMsg carries the result of a rescan.
+type devicesMsg struct {
+	devices []LogicAnalyzer
+	err     error
+	manual  bool // Requested with the rescan key rather than by polling
+}
+
+// pollDevices schedules the next background rescan.
+func pollDevices() tea.Cmd {
+	return tea.Tick(devicePollInterval, func(time.Time) tea.Msg {
+		return pollDevicesMsg{}
+	})
+}
+
+// scanDevices rescans without blocking the UI.
+func scanDevices(b Backend, known []LogicAnalyzer, manual bool) tea.Cmd {
+	return func() tea.Msg {
+		devices, err := rescanDevices(b, known)
+		return devicesMsg{devices: devices, err: err, manual: manual}
+	}
+}
+
+// updateDevices replaces the device list with a rescan. The selected
+// device is followed by its spec, or by driver and model when it came back
+// on another connection after being replugged.
+func (m *model) updateDevices(msg devicesMsg) {
+	if msg.err != nil {
+		if msg.manual {
+			m.statusMsg = "Error: " + msg.err.Error()
+		}
+		return
+	}
+
+	old := m.devices
+	m.devices = msg.devices
+	if len(m.devices) == 0 {
+		m.selectedDevice = 0
+		if len(old) > 0 || msg.manual {
+			m.statusMsg = "No devices found"
+		}
+		return
+	}
+
+	var selected *LogicAnalyzer
+	if m.selectedDevice < len(old) {
+		selected = &old[m.selectedDevice]
+	}
+	index, status := 0, ""
+	switch {
+	case selected == nil:
+		status = "Device attached: " + m.devices[0].DisplayName
+	case slices.ContainsFunc(m.devices, func(d LogicAnalyzer) bool { return d.Spec == selected.Spec }):
+		index = slices.IndexFunc(m.devices, func(d LogicAnalyzer) bool { return d.Spec == selected.Spec })
+	case slices.ContainsFunc(m.devices, func(d LogicAnalyzer) bool { return sameModel(d, *selected) }):
+		index = slices.IndexFunc(m.devices, func(d LogicAnalyzer) bool { return sameModel(d, *selected) })
+		status = "Device reconnected: " + m.devices[index].DisplayName
+	default:
+		status = "Device removed: " + selected.DisplayName
+	}
+
+	// Report other arrivals and removals when the selection is unaffected
+	if status == "" {
+		var attached, removed []string
+		for _, d := range m.devices {
+			if !slices.ContainsFunc(old, func(o LogicAnalyzer) bool { return o.Spec == d.Spec }) {
+				attached = append(attached, d.DisplayName)
+			}
+		}
+		for _, o := range old {
+			if !slices.ContainsFunc(m.devices, func(d LogicAnalyzer) bool { return d.Spec == o.Spec }) {
+				removed = append(removed, o.DisplayName)
+			}
+		}
+		var changes []string
+		if len(attached) > 0 {
+			changes = append(changes, "Device attached: "+strings.Join(attached, ", "))
+		}
+		if len(removed) > 0 {
+			changes = append(changes, "Device removed: "+strings.Join(removed, ", "))
+		}
+		status = strings.Join(changes, "; ")
+	}
+	if status == "" && msg.manual {
+		status = fmt.Sprintf("Found %d device(s)", len(m.devices))
+	}
+
+	if index != m.selectedDevice || selected == nil || m.devices[index].Spec != selected.Spec {
+		m.selectDevice(index)
+	}
+	if status != "" {
+		m.statusMsg = status
+	}
+}
+
+// sameModel reports whether two devices are the same kind of analyzer.
+func sameModel(a, b LogicAnalyzer) bool {
+	return a.Driver == b.Driver && a.Model == b.Model
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestProbeDevice(t *testing.T) {
+	devices, err := discoverDevices(newDemoBackend())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := devices[0]
+	if !d.Probed {
+		t.Fatal("device was not probed")
+	}
+	if d.Triggers != "01rfe" {
+		t.Errorf("Triggers = %q, want %q", d.Triggers, "01rfe")
+	}
+	if len(d.SampleRates) != 17 || d.SampleRates[0] != 20000 || d.SampleRates[16] != 48000000 {
+		t.Errorf("SampleRates = %v", d.SampleRates)
+	}
+	want := []string{"48000000", "24000000", "16000000", "12000000", "8000000", "6000000", "4000000", "3000000", "2000000", "1000000", "Custom..."}
+	if got := d.rateOptions(); !slices.Equal(got, want) {
+		t.Errorf("rateOptions = %q, want %q", got, want)
+	}
+}
+
+func TestParseShowRange(t *testing.T) {
+	show := `Driver functions:
+    Logic analyzer
+dreamsourcelab-dslogic:conn=2.5 - DreamSourceLab DSLogic Plus with 16 channels: 0 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15
+Supported configuration options:
+    Supported triggers: 0 1 r f 
+    samplerate (10 kHz - 400 MHz in steps of 10 kHz)
+    Maximum number of samples: 268435456
+`
+	d := LogicAnalyzer{Spec: "dreamsourcelab-dslogic:conn=2.5", Model: "DSLogic Plus"}
+	d.parseShow([]byte(show))
+
+	if d.MinRate != 10000 || d.MaxRate != 400000000 {
+		t.Errorf("rate range = %d-%d", d.MinRate, d.MaxRate)
+	}
+	if d.MaxSamples != 268435456 {
+		t.Errorf("MaxSamples = %d", d.MaxSamples)
+	}
+	if len(d.Channels) != 16 {
+		t.Errorf("got %d channels, want 16", len(d.Channels))
+	}
+	if !d.supportsRate(100000000) || d.supportsRate(500000000) {
+		t.Error("range not applied to supportsRate")
+	}
+	if got := d.rateOptions(); len(got) != 10 || got[0] != "48000000" {
+		t.Errorf("rateOptions = %q", got)
+	}
+	if name, ok := d.channelName("D3"); !ok || name != "3" {
+		t.Errorf("channelName(D3) = %q, %v; want 3", name, ok)
+	}
+}
+
+func TestCaptureRequestValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(m *model)
+		want  string // Part of the error
+	}{
+		{"pin off device", func(m *model) { m.spiCLK = "D9" }, "CLK pin D9 is not a channel"},
+		{"shared pin", func(m *model) { m.spiMOSI = "D2" }, "MOSI and CLK are both on D2"},
+		{"rate", func(m *model) { m.sampleRate = "30000000" }, "does not support a sample rate of 30 MHz"},
+		{"trigger type", func(m *model) {
+			m.devices[0].Triggers = "01"
+		}, `cannot trigger on "f"`},
+		{"no trigger", func(m *model) { m.devices[0].Triggers = "" }, "has no hardware trigger"},
+		{"depth", func(m *model) {
+			m.devices[0].MaxSamples = 1000000
+			m.duration = "1s"
+		}, "24000000 samples, but Saleae Logic holds at most 1000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := initialModel(newDemoBackend())
+			tt.setup(&m)
+			_, err := captureRequest(m)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRescanDevices(t *testing.T) {
+	files := fstest.MapFS{"scan.txt": {Data: []byte(
+		"demo - Demo device with 12 channels: D0 D1 D2 D3 D4 D5 D6 D7 A0 A1 A2 A3\n" +
+			"fx2lafw:conn=1.43 - Saleae Logic with 8 channels: D0 D1 D2 D3 D4 D5 D6 D7\n")}}
+	m := initialModel(newReplayBackend(files))
+	m.selectDevice(1)
+
+	rescan := func(scan string) {
+		t.Helper()
+		files["scan.txt"] = &fstest.MapFile{Data: []byte(scan)}
+		updated, cmd := m.Update(scanDevices(m.backend, m.devices, false)())
+		m = updated.(model)
+		if cmd == nil {
+			t.Error("polling stopped after a background rescan")
+		}
+	}
+
+	// Replugging moves the analyzer to another connection
+	rescan("fx2lafw:conn=1.44 - Saleae Logic with 8 channels: D0 D1 D2 D3 D4 D5 D6 D7\n" +
+		"demo - Demo device with 12 channels: D0 D1 D2 D3 D4 D5 D6 D7 A0 A1 A2 A3\n")
+	if m.selectedDevice != 0 || m.devices[0].ID != "1.44" {
+		t.Errorf("selection did not follow the reconnected device: %d %+v", m.selectedDevice, m.devices)
+	}
+	if m.statusMsg != "Device reconnected: Saleae Logic - 1.44" {
+		t.Errorf("statusMsg = %q", m.statusMsg)
+	}
+
+	// Other devices coming and going keep the selection
+	rescan("fx2lafw:conn=1.44 - Saleae Logic with 8 channels: D0 D1 D2 D3 D4 D5 D6 D7\n" +
+		"fx2lafw:conn=1.50 - Saleae Logic with 8 channels: D0 D1 D2 D3 D4 D5 D6 D7\n")
+	if m.selectedDevice != 0 || m.statusMsg != "Device attached: Saleae Logic - 1.50; Device removed: Demo device" {
+		t.Errorf("selected %d, statusMsg = %q", m.selectedDevice, m.statusMsg)
+	}
+
+	rescan("The following devices were found:\n")
+	if len(m.devices) != 0 || m.statusMsg != "No devices found" {
+		t.Errorf("devices = %+v, statusMsg = %q", m.devices, m.statusMsg)
+	}
+
+	rescan("fx2lafw:conn=1.60 - Saleae Logic with 8 channels: D0 D1 D2 D3 D4 D5 D6 D7\n")
+	if len(m.devices) != 1 || m.statusMsg != "Device attached: Saleae Logic - 1.60" {
+		t.Errorf("devices = %+v, statusMsg = %q", m.devices, m.statusMsg)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// exporter writes the records of a protocol in another format next to the
+// CSV, e.g. CAN frames as a candump log.
+type exporter struct {
+	ext   string // Replaces the extension of the CSV
+	write func(path string, records []exportRecord) error
+}
+
+// exporters lists the extra output formats by protocol.
+var exporters = map[Protocol]exporter{
+	ProtocolCAN: {".log", writeCandump},
+	ProtocolI2S: {".wav", writeWAV},
+}
+
+// exportRecord is a decoded record of one bus, e.g. a CANFrame, as the
+// decoder produced it.
+type exportRecord struct {
+	bus    string   // Label of the bus, empty without buses
+	m      model    // The bus's settings
+	s      *Session // The session decoded, for its sample rate
+	record any
+}
+
+// exportPath names the file an exporter writes for a CSV, e.g. "out.log"
+// for "out.csv".
+func exportPath(csvPath, ext string) string {
+	return strings.TrimSuffix(csvPath, filepath.Ext(csvPath)) + ext
+}
+
+// writeExports writes the extra formats of every protocol decoded. Each
+// gets the records of its buses in time order.
+func writeExports(csvPath string, records []exportRecord) error {
+	byProtocol := map[Protocol][]exportRecord{}
+	for _, r := range records {
+		byProtocol[r.m.protocol] = append(byProtocol[r.m.protocol], r)
+	}
+	for p, recs := range byProtocol {
+		e := exporters[p]
+		if err := e.write(exportPath(csvPath, e.ext), recs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
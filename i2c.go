@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// I2CConfig selects the pins of an I2C bus and an optional target filter.
+type I2CConfig struct {
+	SDA, SCL string
+	Address  int // Only keep transactions to this address, -1 for all
+}
+
+// I2CByte is a data byte and the acknowledge bit that followed it.
+type I2CByte struct {
+	Value byte
+	ACK   bool
+}
+
+// I2CTransaction is everything between a (repeated) START and the next
+// START or STOP.
+type I2CTransaction struct {
+	Start, End int64
+	Repeated   bool // Began with a repeated START
+	Stop       bool // Ended with STOP rather than another START
+
+	Address    int
+	TenBit     bool
+	Read       bool
+	AddressACK bool
+	Data       []I2CByte
+
+	Stretches []int64 // Clock-stretch durations in samples
+}
+
+// i2cConfig validates the I2C fields of the Configuration panel.
+func i2cConfig(m model) (I2CConfig, error) {
+	cfg := I2CConfig{SDA: m.i2cSDA, SCL: m.i2cSCL, Address: -1}
+	addr := strings.TrimSpace(m.i2cAddress)
+	if addr == "" || strings.EqualFold(addr, "any") {
+		return cfg, nil
+	}
+	// Addresses are hex with or without 0x, as the decoder prints them
+	v, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(addr), "0x"), 16, 16)
+	if err != nil || v > 0x3FF {
+		return cfg, fmt.Errorf("address must be a 7- or 10-bit hex number or \"any\", got %q", m.i2cAddress)
+	}
+	cfg.Address = int(v)
+	return cfg, nil
+}
+
+// i2cDecoder follows START/STOP conditions and samples SDA on every rising
+// edge of SCL.
+type i2cDecoder struct {
+	cfg      I2CConfig
+	sda, scl int
+
+	started bool
+	prev    uint64
+
+	current   *I2CTransaction
+	addressed bool // Address byte received
+	tenBitLow bool // Waiting for the low byte of a 10-bit address
+	bits      int
+	value     byte
+	sclFall   int64   // Sample where SCL last went low
+	lowTimes  []int64 // SCL low periods within the current transaction
+
+	last         *I2CTransaction // Previous transaction, for 10-bit reads
+	transactions []I2CTransaction
+}
+
+func newI2CDecoder(s *Session, cfg I2CConfig) (*i2cDecoder, error) {
+	d := &i2cDecoder{cfg: cfg}
+	var err error
+	if d.sda, err = resolveChannel(s, "SDA", cfg.SDA); err != nil {
+		return nil, err
+	}
+	if d.scl, err = resolveChannel(s, "SCL", cfg.SCL); err != nil {
+		return nil, err
+	}
+	if d.sda < 0 {
+		return nil, errMissingPin("SDA")
+	}
+	if d.scl < 0 {
+		return nil, errMissingPin("SCL")
+	}
+	return d, nil
+}
+
+// decodeI2C decodes every transaction in a session that matches the
+// address filter.
+func decodeI2C(s *Session, cfg I2CConfig) ([]I2CTransaction, error) {
+	d, err := newI2CDecoder(s, cfg)
+	if err != nil {
+		return nil, err
+	}
+	decodeSession(s, d)
+	d.finish(s.NumSamples(), false)
+	return d.transactions, nil
+}
+
+func (d *i2cDecoder) feed(n int64, sample uint64) {
+	if !d.started {
+		d.started = true
+		d.prev = sample
+		return
+	}
+	prev := d.prev
+	d.prev = sample
+
+	sda, prevSDA := sample>>d.sda&1, prev>>d.sda&1
+	scl, prevSCL := sample>>d.scl&1, prev>>d.scl&1
+
+	// SDA changing while SCL stays high is a START or STOP
+	if scl == 1 && prevSCL == 1 && sda != prevSDA {
+		if sda == 0 {
+			repeated := d.current != nil
+			d.finish(n, false)
+			d.current = &I2CTransaction{Start: n, Repeated: repeated}
+			d.addressed = false
+			d.tenBitLow = false
+			d.bits = 0
+			d.value = 0
+			d.sclFall = -1
+		} else {
+			d.finish(n, true)
+		}
+		return
+	}
+
+	if d.current == nil || scl == prevSCL {
+		return
+	}
+	if scl == 0 {
+		d.sclFall = n
+		return
+	}
+
+	// Rising edge of SCL: sample SDA
+	if d.sclFall >= 0 {
+		d.lowTimes = append(d.lowTimes, n-d.sclFall)
+	}
+	if d.bits < 8 {
+		d.value = d.value<<1 | byte(sda)
+		d.bits++
+		return
+	}
+	d.byteDone(d.value, sda == 0)
+	d.bits = 0
+	d.value = 0
+}
+
+// pendingStart is the START of the transaction in progress, or -1.
+func (d *i2cDecoder) pendingStart() int64 {
+	if d.current != nil {
+		return d.current.Start
+	}
+	return -1
+}
+
+// byteDone handles a complete byte and its ACK bit.
+func (d *i2cDecoder) byteDone(v byte, ack bool) {
+	t := d.current
+	switch {
+	case !d.addressed:
+		d.addressed = true
+		t.Read = v&1 == 1
+		t.AddressACK = ack
+		if v&0xF8 != 0xF0 {
+			t.Address = int(v >> 1)
+			return
+		}
+		// 10-bit addressing: 11110 A9 A8 R/W. Writes send the low byte
+		// next; reads reuse the address of the preceding write.
+		t.TenBit = true
+		t.Address = int(v>>1&0x03) << 8
+		if !t.Read {
+			d.tenBitLow = true
+		} else if d.last != nil && d.last.TenBit && d.last.Address>>8 == t.Address>>8 {
+			t.Address = d.last.Address
+		}
+	case d.tenBitLow:
+		d.tenBitLow = false
+		t.Address |= int(v)
+		t.AddressACK = ack
+	default:
+		t.Data = append(t.Data, I2CByte{Value: v, ACK: ack})
+	}
+}
+
+// finish closes the current transaction, if any.
+func (d *i2cDecoder) finish(n int64, stop bool) {
+	t := d.current
+	d.current = nil
+	lowTimes := d.lowTimes
+	d.lowTimes = nil
+	if t == nil {
+		return
+	}
+	t.End = n
+	t.Stop = stop
+	addressed := d.addressed
+	d.addressed = false
+
+	// SCL held low for more than twice the typical low period is stretched
+	if len(lowTimes) > 2 {
+		sorted := append([]int64(nil), lowTimes...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		nominal := sorted[len(sorted)/2]
+		for _, low := range lowTimes {
+			if low > 2*nominal {
+				t.Stretches = append(t.Stretches, low-nominal)
+			}
+		}
+	}
+
+	// A START immediately followed by STOP carries nothing worth reporting
+	if !addressed {
+		return
+	}
+	d.last = t
+	if d.cfg.Address >= 0 && t.Address != d.cfg.Address {
+		return
+	}
+	d.transactions = append(d.transactions, *t)
+}
+
+// i2cRow formats a transaction as a CSV row.
+func i2cRow(s *Session, t I2CTransaction) []string {
+	start := "START"
+	if t.Repeated {
+		start = "REPEATED START"
+	}
+	address := fmt.Sprintf("0x%02X", t.Address)
+	if t.TenBit {
+		address = fmt.Sprintf("0x%03X", t.Address)
+	}
+	rw := "W"
+	if t.Read {
+		rw = "R"
+	}
+
+	var data, acks, stretches []string
+	for _, b := range t.Data {
+		data = append(data, fmt.Sprintf("%02X", b.Value))
+		acks = append(acks, ackText(b.ACK))
+	}
+	for _, st := range t.Stretches {
+		stretches = append(stretches, fmt.Sprintf("%.3f", s.Seconds(st)*1e6))
+	}
+	stop := ""
+	if t.Stop {
+		stop = "STOP"
+	}
+
+	return []string{
+		fmt.Sprintf("%.9f", s.Seconds(t.Start)),
+		start,
+		address,
+		rw,
+		ackText(t.AddressACK),
+		strings.Join(data, " "),
+		strings.Join(acks, " "),
+		strings.Join(stretches, " "),
+		stop,
+	}
+}
+
+var i2cHeader = []string{"time", "start", "address", "rw", "addr_ack", "data", "data_ack", "stretch_us", "stop"}
+
+func ackText(ack bool) string {
+	if ack {
+		return "ACK"
+	}
+	return "NACK"
+}
+
+// registerTracker names the registers that I2C transactions address. A
+// write selects the register in its first data byte; a read continues from
+// the register last selected on that device.
+type registerTracker struct {
+	names   map[int]map[int]string // By device address, then register
+	pointer map[int]int            // Register last selected per device
+}
+
+func (r *registerTracker) name(t I2CTransaction) string {
+	regs, ok := r.names[t.Address]
+	if !ok {
+		return ""
+	}
+	if !t.Read && len(t.Data) > 0 {
+		r.pointer[t.Address] = int(t.Data[0].Value)
+	}
+	reg, ok := r.pointer[t.Address]
+	if !ok {
+		return ""
+	}
+	if name, ok := regs[reg]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02X", reg)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// i2cBus drives SDA=0 and SCL=1 of a synthetic capture, 10 samples per bit.
+type i2cBus struct{ *signal }
+
+func newI2CBus() *i2cBus {
+	b := &i2cBus{newSignal("SDA", "SCL")}
+	b.set(0, 1).set(1, 1).hold(10)
+	return b
+}
+
+func (b *i2cBus) start() *i2cBus {
+	b.set(0, 1).hold(2).set(1, 1).hold(3)
+	b.set(0, 0).hold(3).set(1, 0).hold(2)
+	return b
+}
+
+func (b *i2cBus) stop() *i2cBus {
+	b.set(0, 0).hold(2).set(1, 1).hold(3).set(0, 1).hold(5)
+	return b
+}
+
+// byte clocks out v followed by the ACK bit; stretch extends SCL low
+// before the byte.
+func (b *i2cBus) byte(v byte, ack bool, stretch int) *i2cBus {
+	b.hold(stretch)
+	for i := 7; i >= 0; i-- {
+		b.bit(int(v >> i & 1))
+	}
+	if ack {
+		return b.bit(0)
+	}
+	return b.bit(1)
+}
+
+func (b *i2cBus) bit(v int) *i2cBus {
+	b.set(0, v).hold(3).set(1, 1).hold(4).set(1, 0).hold(3)
+	return b
+}
+
+func TestDecodeI2C(t *testing.T) {
+	bus := newI2CBus()
+	bus.start().byte(0x50<<1, true, 0).byte(0x10, true, 40)
+	bus.start().byte(0x50<<1|1, true, 0).byte(0xAB, true, 0).byte(0xCD, false, 0).stop()
+	// Another device, removed by the address filter
+	bus.start().byte(0x68<<1, false, 0).stop()
+
+	cfg := I2CConfig{SDA: "D0", SCL: "D1", Address: -1}
+	got, err := decodeI2C(bus.session(1000000), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %d transactions, want 3: %+v", len(got), got)
+	}
+
+	w := got[0]
+	if w.Address != 0x50 || w.Read || !w.AddressACK || w.Repeated || w.Stop {
+		t.Errorf("write = %+v", w)
+	}
+	if len(w.Data) != 1 || w.Data[0] != (I2CByte{0x10, true}) {
+		t.Errorf("write data = %+v", w.Data)
+	}
+	if len(w.Stretches) != 1 || w.Stretches[0] != 40 {
+		t.Errorf("write stretches = %v, want [40]", w.Stretches)
+	}
+
+	r := got[1]
+	if r.Address != 0x50 || !r.Read || !r.Repeated || !r.Stop {
+		t.Errorf("read = %+v", r)
+	}
+	wantData := []I2CByte{{0xAB, true}, {0xCD, false}}
+	if len(r.Data) != 2 || r.Data[0] != wantData[0] || r.Data[1] != wantData[1] {
+		t.Errorf("read data = %+v, want %+v", r.Data, wantData)
+	}
+
+	if got[2].Address != 0x68 || got[2].AddressACK {
+		t.Errorf("third = %+v", got[2])
+	}
+
+	cfg.Address = 0x50
+	filtered, err := decodeI2C(bus.session(1000000), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filtered) != 2 {
+		t.Errorf("filter kept %d transactions, want 2", len(filtered))
+	}
+}
+
+func TestDecodeI2CTenBit(t *testing.T) {
+	bus := newI2CBus()
+	// Write to 0x2A5, then read it back after a repeated START
+	bus.start().byte(0xF0|0x02<<1, true, 0).byte(0xA5, true, 0).byte(0x01, true, 0)
+	bus.start().byte(0xF0|0x02<<1|1, true, 0).byte(0x99, false, 0).stop()
+
+	got, err := decodeI2C(bus.session(1000000), I2CConfig{SDA: "D0", SCL: "D1", Address: 0x2A5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d transactions, want 2: %+v", len(got), got)
+	}
+	for i, tr := range got {
+		if !tr.TenBit || tr.Address != 0x2A5 {
+			t.Errorf("transaction %d address = %#x (10-bit %v), want 0x2A5", i, tr.Address, tr.TenBit)
+		}
+	}
+	if len(got[0].Data) != 1 || got[0].Data[0].Value != 0x01 {
+		t.Errorf("write data = %+v", got[0].Data)
+	}
+	if !got[1].Read || len(got[1].Data) != 1 || got[1].Data[0].Value != 0x99 {
+		t.Errorf("read = %+v", got[1])
+	}
+}
+
+func TestI2CAddressFilterDefault(t *testing.T) {
+	// A fresh setup keeps every device
+	m := initialModel(newDemoBackend())
+	m.protocol = ProtocolI2C
+	if cfg, err := i2cConfig(m); err != nil || cfg.Address != -1 {
+		t.Errorf("default address filter = %d, %v", cfg.Address, err)
+	}
+	if strings.Contains(m.renderCapturePanel(60, 18), "only") {
+		t.Error("capture panel shows a filter by default")
+	}
+
+	// Bare addresses are hex like the decoded ones
+	m.i2cAddress = "50"
+	if cfg, err := i2cConfig(m); err != nil || cfg.Address != 0x50 {
+		t.Errorf("address 50 = %#x, %v", cfg.Address, err)
+	}
+	m.i2cAddress = "0x50"
+	if panel := m.renderCapturePanel(60, 18); !strings.Contains(panel, "I2C 0x50 only") {
+		t.Errorf("capture panel does not show the address filter:\n%s", panel)
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// I2SConfig selects the pins and frame format of an I2S or TDM audio bus.
+type I2SConfig struct {
+	BCLK, WS, SD string
+	WordLength   int // Bits per sample
+	Justify      int // i2sPhilips, i2sLeft or i2sRight
+	Slots        int // Samples per frame; 2 for stereo I2S, more for TDM
+}
+
+// Justifications: where a sample sits in its slot.
+const (
+	i2sPhilips = iota // MSB one BCLK after the WS edge (I2S, or DSP mode A)
+	i2sLeft           // MSB on the WS edge (left-justified, or DSP mode B)
+	i2sRight          // LSB at the end of the slot (right-justified)
+)
+
+var i2sJustifications = []string{"i2s", "left", "right"}
+
+// I2SFrame is one sample per slot, from one frame sync to the next.
+type I2SFrame struct {
+	Start, End int64
+	Samples    []int32
+	Error      string // Set when the frame has too few bits for its slots
+}
+
+// i2sConfig validates the I2S fields of the Configuration panel.
+func i2sConfig(m model) (I2SConfig, error) {
+	cfg := I2SConfig{BCLK: m.i2sBCLK, WS: m.i2sWS, SD: m.i2sSD}
+	var err error
+	if cfg.WordLength, err = parseIntRange("word length", m.i2sWordLength, 8, 32); err != nil {
+		return cfg, err
+	}
+	if cfg.Justify, err = parseChoice("justify", m.i2sJustify, i2sJustifications...); err != nil {
+		return cfg, err
+	}
+	if cfg.Slots, err = parseIntRange("slots", m.i2sSlots, 1, 32); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// frameLevel is the WS level a frame starts with. Stereo I2S starts with
+// the left channel, WS low; the other formats with WS going high.
+func (cfg I2SConfig) frameLevel() byte {
+	if cfg.Slots == 2 && cfg.Justify == i2sPhilips {
+		return 0
+	}
+	return 1
+}
+
+// i2sDecoder samples WS and SD on rising edges of BCLK. The bits between
+// two frame syncs are split evenly into the slots.
+type i2sDecoder struct {
+	cfg        I2SConfig
+	bclk, ws   int
+	sd         int
+	prevBCLK   int
+	prevWS     byte
+	started    bool
+	framed     bool // A frame sync has been seen
+	frameStart int64
+	bits       []byte
+	frames     []I2SFrame
+}
+
+func newI2SDecoder(s *Session, cfg I2SConfig) (*i2sDecoder, error) {
+	d := &i2sDecoder{cfg: cfg}
+	var err error
+	if d.bclk, err = resolveChannel(s, "BCLK", cfg.BCLK); err != nil {
+		return nil, err
+	}
+	if d.ws, err = resolveChannel(s, "WS", cfg.WS); err != nil {
+		return nil, err
+	}
+	if d.sd, err = resolveChannel(s, "SD", cfg.SD); err != nil {
+		return nil, err
+	}
+	for _, pin := range []struct {
+		ch   int
+		role string
+	}{{d.bclk, "BCLK"}, {d.ws, "WS"}, {d.sd, "SD"}} {
+		if pin.ch < 0 {
+			return nil, errMissingPin(pin.role)
+		}
+	}
+	return d, nil
+}
+
+// decodeI2S decodes every complete frame in a session.
+func decodeI2S(s *Session, cfg I2SConfig) ([]I2SFrame, error) {
+	d, err := newI2SDecoder(s, cfg)
+	if err != nil {
+		return nil, err
+	}
+	decodeSession(s, d)
+	return d.frames, nil
+}
+
+func (d *i2sDecoder) feed(n int64, sample uint64) {
+	bclk := int(sample >> d.bclk & 1)
+	if !d.started {
+		d.started = true
+		d.prevBCLK = bclk
+		d.prevWS = byte(sample >> d.ws & 1)
+		return
+	}
+	rising := bclk == 1 && d.prevBCLK == 0
+	d.prevBCLK = bclk
+	if !rising {
+		return
+	}
+	ws, sd := byte(sample>>d.ws&1), byte(sample>>d.sd&1)
+	sync := ws != d.prevWS && ws == d.cfg.frameLevel()
+	d.prevWS = ws
+
+	if sync {
+		if d.framed {
+			// The last bit of a delayed frame comes with the next sync
+			bits := d.bits
+			if d.cfg.Justify == i2sPhilips {
+				bits = append(bits[1:], sd)
+			}
+			d.frames = append(d.frames, d.frame(d.frameStart, n, bits))
+		}
+		d.framed = true
+		d.frameStart = n
+		d.bits = d.bits[:0]
+	}
+	if d.framed {
+		d.bits = append(d.bits, sd)
+	}
+}
+
+// pendingStart is the start of the frame being received, or -1.
+func (d *i2sDecoder) pendingStart() int64 {
+	if d.framed {
+		return d.frameStart
+	}
+	return -1
+}
+
+// frame splits the bits of a frame into slots and reads a sample from
+// each, MSB first.
+func (d *i2sDecoder) frame(start, end int64, bits []byte) I2SFrame {
+	f := I2SFrame{Start: start, End: end}
+	width := len(bits) / d.cfg.Slots
+	if width < d.cfg.WordLength {
+		f.Error = fmt.Sprintf("%d bits for %d slots", len(bits), d.cfg.Slots)
+		return f
+	}
+	for slot := 0; slot < d.cfg.Slots; slot++ {
+		from := slot * width
+		if d.cfg.Justify == i2sRight {
+			from += width - d.cfg.WordLength
+		}
+		var v uint32
+		for _, b := range bits[from : from+d.cfg.WordLength] {
+			v = v<<1 | uint32(b)
+		}
+		// Two's complement
+		shift := 32 - d.cfg.WordLength
+		f.Samples = append(f.Samples, int32(v<<shift)>>shift)
+	}
+	return f
+}
+
+// i2sHeader is the CSV header of an I2S bus: left and right for stereo,
+// numbered slots for TDM.
+func i2sHeader(cfg I2SConfig) []string {
+	header := []string{"time"}
+	header = append(header, i2sSlotNames(cfg)...)
+	return append(header, "error")
+}
+
+func i2sSlotNames(cfg I2SConfig) []string {
+	if cfg.Slots == 2 {
+		return []string{"left", "right"}
+	}
+	var names []string
+	for i := 0; i < cfg.Slots; i++ {
+		names = append(names, fmt.Sprintf("slot%d", i))
+	}
+	return names
+}
+
+// i2sRow formats a frame as a CSV row of signed samples.
+func i2sRow(s *Session, cfg I2SConfig, f I2SFrame) []string {
+	row := []string{fmt.Sprintf("%.9f", s.Seconds(f.Start))}
+	for i := 0; i < cfg.Slots; i++ {
+		if i < len(f.Samples) {
+			row = append(row, strconv.Itoa(int(f.Samples[i])))
+		} else {
+			row = append(row, "")
+		}
+	}
+	return append(row, f.Error)
+}
+
+// wavRates are the standard audio sample rates a measured frame rate is
+// rounded to.
+var wavRates = []int{8000, 11025, 16000, 22050, 24000, 32000, 44100, 48000, 88200, 96000, 176400, 192000}
+
+// wavRate works out the sample rate from the starts of the first and last
+// frame, as sample indices at sampleRate, rounded to a standard rate when
+// within 1% of one.
+func wavRate(first, last int64, frames int, sampleRate uint64) int {
+	if frames < 2 || last <= first || sampleRate == 0 {
+		return 48000
+	}
+	rate := float64(frames-1) * float64(sampleRate) / float64(last-first)
+	for _, r := range wavRates {
+		if math.Abs(rate-float64(r)) < float64(r)/100 {
+			return r
+		}
+	}
+	return int(math.Round(rate))
+}
+
+// WAV format tags: plain PCM, and PCM described by a wavExtension.
+const (
+	wavePCM        = 0x0001
+	waveExtensible = 0xFFFE
+)
+
+// wavFormat is the fmt chunk of a WAV file, after its size.
+type wavFormat struct {
+	Format, Channels     uint16
+	Rate, ByteRate       uint32
+	BlockAlign, BitDepth uint16
+}
+
+// wavExtension follows wavFormat in WAVE_FORMAT_EXTENSIBLE files.
+type wavExtension struct {
+	Size        uint16 // Bytes that follow
+	ValidBits   uint16 // Bits of each sample in use, from the top
+	ChannelMask uint32 // Speaker positions of the channels
+	SubFormat   [16]byte
+}
+
+// wavSubFormatPCM is KSDATAFORMAT_SUBTYPE_PCM.
+var wavSubFormatPCM = [16]byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71}
+
+// wavChannelMask assigns slots to the speaker positions in order: front
+// left and right for stereo. Beyond the 18 positions defined slots are not
+// assigned any.
+func wavChannelMask(channels int) uint32 {
+	if channels > 18 {
+		return 0
+	}
+	return 1<<channels - 1
+}
+
+// writeWAV writes I2S frames as PCM WAV files, one channel per slot. The
+// sample rate is measured from the frames. Frames with an error carry no
+// samples and are left out. Samples are stored in the
+// smallest container of 8, 16, 24 or 32 bits, shifted up to its MSB.
+// With several I2S buses each gets its own file, named after its label.
+func writeWAV(path string, records []exportRecord) error {
+	var labels []string
+	byBus := map[string][]exportRecord{}
+	for _, r := range records {
+		if _, ok := byBus[r.bus]; !ok {
+			labels = append(labels, r.bus)
+		}
+		byBus[r.bus] = append(byBus[r.bus], r)
+	}
+	for _, label := range labels {
+		p := path
+		if len(labels) > 1 {
+			p = strings.TrimSuffix(path, ".wav") + "-" + label + ".wav"
+		}
+		if err := writeBusWAV(p, byBus[label]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeBusWAV(path string, records []exportRecord) error {
+	cfg, err := i2sConfig(records[0].m)
+	if err != nil {
+		return err
+	}
+	container := (cfg.WordLength + 7) / 8
+	first, last := records[0].record.(I2SFrame), records[len(records)-1].record.(I2SFrame)
+	rate := wavRate(first.Start, last.Start, len(records), records[0].s.SampleRate)
+	var frames []I2SFrame
+	for _, r := range records {
+		if f := r.record.(I2SFrame); f.Error == "" {
+			frames = append(frames, f)
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	blockAlign := cfg.Slots * container
+	dataSize := len(frames) * blockAlign
+	format := wavFormat{
+		Format:     wavePCM,
+		Channels:   uint16(cfg.Slots),
+		Rate:       uint32(rate),
+		ByteRate:   uint32(rate * blockAlign),
+		BlockAlign: uint16(blockAlign),
+		BitDepth:   uint16(container * 8),
+	}
+	var ext *wavExtension
+	// Players only take more than two channels, or samples wider than
+	// 16 bits or than the word, from the extensible format
+	if cfg.Slots > 2 || container > 2 || cfg.WordLength != container*8 {
+		format.Format = waveExtensible
+		ext = &wavExtension{
+			Size:        22,
+			ValidBits:   uint16(cfg.WordLength),
+			ChannelMask: wavChannelMask(cfg.Slots),
+			SubFormat:   wavSubFormatPCM,
+		}
+	}
+	fmtSize := binary.Size(format)
+	if ext != nil {
+		fmtSize += binary.Size(ext)
+	}
+	le := binary.LittleEndian
+	w.WriteString("RIFF")
+	binary.Write(w, le, uint32(4+8+fmtSize+8+dataSize+dataSize%2))
+	w.WriteString("WAVEfmt ")
+	binary.Write(w, le, uint32(fmtSize))
+	binary.Write(w, le, format)
+	if ext != nil {
+		binary.Write(w, le, ext)
+	}
+	w.WriteString("data")
+	binary.Write(w, le, uint32(dataSize))
+
+	buf := make([]byte, 4)
+	for _, frame := range frames {
+		for _, v := range frame.Samples {
+			u := uint32(v) << (container*8 - cfg.WordLength)
+			if container == 1 {
+				u += 0x80 // 8-bit WAV samples are unsigned
+			}
+			le.PutUint32(buf, u)
+			w.Write(buf[:container])
+		}
+	}
+	if dataSize%2 == 1 {
+		w.WriteByte(0)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// i2sBus drives BCLK=0, WS=1 and SD=2 of a synthetic capture, 4 samples
+// per bit, changing WS and SD while BCLK is low.
+type i2sBus struct {
+	*signal
+}
+
+// i2sFrames sends frames of width-bit slots in the format of cfg,
+// followed by the first bit of another frame that completes the last one.
+func i2sFrames(cfg I2SConfig, width int, frames ...[]int32) *i2sBus {
+	b := &i2sBus{newSignal("BCLK", "WS", "SD")}
+	b.set(1, int(1-cfg.frameLevel())).hold(4)
+	var data, ws []int
+	for _, f := range frames {
+		for slot, v := range f {
+			from := 0
+			if cfg.Justify == i2sRight {
+				from = width - cfg.WordLength
+			}
+			for i := 0; i < width; i++ {
+				bit := 0
+				if k := i - from; k >= 0 && k < cfg.WordLength {
+					bit = int(uint32(v) >> (cfg.WordLength - 1 - k) & 1)
+				}
+				data = append(data, bit)
+				level := int(cfg.frameLevel())
+				switch {
+				case cfg.Slots == 2 && slot == 1:
+					level = 1 - level
+				case cfg.Slots != 2 && (slot > 0 || i > 0):
+					level = 0 // A one-bit frame sync pulse
+				}
+				ws = append(ws, level)
+			}
+		}
+	}
+	// Delayed by a bit, the last one comes with the next frame
+	if cfg.Justify == i2sPhilips {
+		data = append([]int{0}, data...)
+	} else {
+		data = append(data, 0)
+	}
+	for j, level := range append(ws, ws[0]) {
+		b.set(0, 0).set(1, level).set(2, data[j]).hold(2).set(0, 1).hold(2)
+	}
+	return b
+}
+
+func TestDecodeI2S(t *testing.T) {
+	stereo := [][]int32{{-1000, 8388607}, {123456, -8388608}}
+	tdm := [][]int32{{1, -2, 3, -4, 5, -6, 7, -8}}
+	for _, tc := range []struct {
+		name   string
+		cfg    I2SConfig
+		width  int
+		frames [][]int32
+	}{
+		{"i2s", I2SConfig{WordLength: 24, Justify: i2sPhilips, Slots: 2}, 32, stereo},
+		{"left", I2SConfig{WordLength: 24, Justify: i2sLeft, Slots: 2}, 24, stereo},
+		{"right", I2SConfig{WordLength: 24, Justify: i2sRight, Slots: 2}, 32, stereo},
+		{"tdm", I2SConfig{WordLength: 16, Justify: i2sPhilips, Slots: 8}, 16, tdm},
+		{"tdm left", I2SConfig{WordLength: 12, Justify: i2sLeft, Slots: 8}, 16, tdm},
+	} {
+		tc.cfg.BCLK, tc.cfg.WS, tc.cfg.SD = "D0", "D1", "D2"
+		got, err := decodeI2S(i2sFrames(tc.cfg, tc.width, tc.frames...).session(1000000), tc.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(tc.frames) {
+			t.Errorf("%s: got %d frames, want %d: %+v", tc.name, len(got), len(tc.frames), got)
+			continue
+		}
+		for i, f := range got {
+			if !slices.Equal(f.Samples, tc.frames[i]) {
+				t.Errorf("%s: frame %d = %v, want %v", tc.name, i, f.Samples, tc.frames[i])
+			}
+		}
+	}
+
+	// Slots narrower than the word length
+	cfg := I2SConfig{BCLK: "D0", WS: "D1", SD: "D2", WordLength: 24, Justify: i2sLeft, Slots: 2}
+	got, _ := decodeI2S(i2sFrames(cfg, 16, stereo...).session(1000000), cfg)
+	if len(got) != 2 || got[0].Error != "32 bits for 2 slots" {
+		t.Errorf("narrow slots = %+v", got)
+	}
+}
+
+func TestI2SWAV(t *testing.T) {
+	cfg := I2SConfig{WordLength: 20, Justify: i2sPhilips, Slots: 2}
+	frames := [][]int32{{-1, 1}, {0x7FFFF, -0x80000}, {100, -100}}
+	// 64 bits of 4 samples per frame at 48 kHz
+	s := i2sFrames(cfg, 32, frames...).session(64 * 4 * 48000)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audio.sr")
+	if err := writeSession(path, s); err != nil {
+		t.Fatal(err)
+	}
+	m := initialModel(newDemoBackend())
+	m.protocol = ProtocolI2S
+	m.i2sWordLength = "20"
+	if err := decodeToCSV(context.Background(), path, filepath.Join(dir, "audio.csv"), m.protocol, m); err != nil {
+		t.Fatal(err)
+	}
+	wav, err := os.ReadFile(filepath.Join(dir, "audio.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	le := binary.LittleEndian
+	if string(wav[:4]) != "RIFF" || string(wav[8:16]) != "WAVEfmt " || string(wav[60:64]) != "data" {
+		t.Fatalf("header = %q", wav[:68])
+	}
+	if size := le.Uint32(wav[4:]); int(size) != len(wav)-8 {
+		t.Errorf("RIFF size %d, file %d bytes", size, len(wav))
+	}
+	// 20 of 24 bits need the extensible format
+	format, channels, rate, bits := le.Uint16(wav[20:]), le.Uint16(wav[22:]), le.Uint32(wav[24:]), le.Uint16(wav[34:])
+	if format != waveExtensible || channels != 2 || rate != 48000 || bits != 24 || le.Uint32(wav[64:]) != 3*2*3 {
+		t.Errorf("format %X, channels %d, rate %d, bits %d, data %d", format, channels, rate, bits, le.Uint32(wav[64:]))
+	}
+	if valid, mask := le.Uint16(wav[38:]), le.Uint32(wav[40:]); valid != 20 || mask != 3 || !slices.Equal(wav[44:60], wavSubFormatPCM[:]) {
+		t.Errorf("valid bits %d, channel mask %X, subformat % X", valid, mask, wav[44:60])
+	}
+	// 20-bit samples fill the top of 24-bit ones
+	want := []byte{0xF0, 0xFF, 0xFF, 0x10, 0x00, 0x00, 0xF0, 0xFF, 0x7F, 0x00, 0x00, 0x80}
+	if got := wav[68:80]; !slices.Equal(got, want) {
+		t.Errorf("samples = % X, want % X", got, want)
+	}
+}
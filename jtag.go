@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// JTAGConfig selects the pins of a JTAG port. TDI and TDO may be left
+// empty; their columns are then blank.
+type JTAGConfig struct {
+	TCK, TMS, TDI, TDO string
+}
+
+// JTAGShift is a scan through the instruction or data register, or a
+// reset of the TAP controller.
+type JTAGShift struct {
+	Start, End int64
+	Reset      bool // Entered Test-Logic-Reset
+	IR         bool // Instruction register rather than data register
+	TDI, TDO   []byte
+	Paused     bool   // Went through Pause-IR or Pause-DR
+	Selected   string // Instruction in IR when a data register was shifted
+	Select     uint32 // Arm DP SELECT at the time, for APACC register names
+}
+
+// tapState is a state of the TAP controller.
+type tapState int
+
+const (
+	tapReset tapState = iota
+	tapIdle
+	tapSelectDR
+	tapCaptureDR
+	tapShiftDR
+	tapExit1DR
+	tapPauseDR
+	tapExit2DR
+	tapUpdateDR
+	tapSelectIR
+	tapCaptureIR
+	tapShiftIR
+	tapExit1IR
+	tapPauseIR
+	tapExit2IR
+	tapUpdateIR
+	tapUnknown
+)
+
+// tapNext is the state each state moves to with TMS low and high.
+var tapNext = [...][2]tapState{
+	tapReset:     {tapIdle, tapReset},
+	tapIdle:      {tapIdle, tapSelectDR},
+	tapSelectDR:  {tapCaptureDR, tapSelectIR},
+	tapCaptureDR: {tapShiftDR, tapExit1DR},
+	tapShiftDR:   {tapShiftDR, tapExit1DR},
+	tapExit1DR:   {tapPauseDR, tapUpdateDR},
+	tapPauseDR:   {tapPauseDR, tapExit2DR},
+	tapExit2DR:   {tapShiftDR, tapUpdateDR},
+	tapUpdateDR:  {tapIdle, tapSelectDR},
+	tapSelectIR:  {tapCaptureIR, tapReset},
+	tapCaptureIR: {tapShiftIR, tapExit1IR},
+	tapShiftIR:   {tapShiftIR, tapExit1IR},
+	tapExit1IR:   {tapPauseIR, tapUpdateIR},
+	tapPauseIR:   {tapPauseIR, tapExit2IR},
+	tapExit2IR:   {tapShiftIR, tapUpdateIR},
+	tapUpdateIR:  {tapIdle, tapSelectDR},
+}
+
+// jtagDecoder follows the TAP controller from TMS, sampled with TDI and
+// TDO on rising edges of TCK. Until five clocks with TMS high force
+// Test-Logic-Reset the state is unknown and nothing is decoded.
+type jtagDecoder struct {
+	tck, tms, tdi, tdo int
+	prevTCK            int
+	started            bool
+
+	state   tapState
+	highTMS int // Consecutive clocks with TMS high
+	cur     JTAGShift
+	ir      []byte // Last instruction shifted in
+	sel     uint32 // Last value written to the DP SELECT register
+
+	shifts []JTAGShift
+}
+
+func newJTAGDecoder(s *Session, cfg JTAGConfig) (*jtagDecoder, error) {
+	d := &jtagDecoder{state: tapUnknown}
+	var err error
+	for _, pin := range []struct {
+		ch        *int
+		role, pin string
+	}{{&d.tck, "TCK", cfg.TCK}, {&d.tms, "TMS", cfg.TMS}, {&d.tdi, "TDI", cfg.TDI}, {&d.tdo, "TDO", cfg.TDO}} {
+		if *pin.ch, err = resolveChannel(s, pin.role, pin.pin); err != nil {
+			return nil, err
+		}
+	}
+	if d.tck < 0 {
+		return nil, errMissingPin("TCK")
+	}
+	if d.tms < 0 {
+		return nil, errMissingPin("TMS")
+	}
+	return d, nil
+}
+
+// decodeJTAG decodes every scan in a session.
+func decodeJTAG(s *Session, cfg JTAGConfig) ([]JTAGShift, error) {
+	d, err := newJTAGDecoder(s, cfg)
+	if err != nil {
+		return nil, err
+	}
+	decodeSession(s, d)
+	return d.shifts, nil
+}
+
+func (d *jtagDecoder) feed(n int64, sample uint64) {
+	tck := int(sample >> d.tck & 1)
+	if !d.started {
+		d.started = true
+		d.prevTCK = tck
+		return
+	}
+	rising := tck == 1 && d.prevTCK == 0
+	d.prevTCK = tck
+	if !rising {
+		return
+	}
+	tms := int(sample >> d.tms & 1)
+
+	if d.state == tapShiftDR || d.state == tapShiftIR {
+		d.cur.TDI = append(d.cur.TDI, optionalBit(sample, d.tdi))
+		d.cur.TDO = append(d.cur.TDO, optionalBit(sample, d.tdo))
+	}
+
+	if tms == 1 {
+		d.highTMS++
+	} else {
+		d.highTMS = 0
+	}
+	next := tapReset
+	if d.state != tapUnknown {
+		next = tapNext[d.state][tms]
+	} else if d.highTMS < 5 {
+		return
+	}
+
+	switch next {
+	case tapReset:
+		if d.state != tapReset {
+			d.shifts = append(d.shifts, JTAGShift{Start: n, End: n, Reset: true})
+			// IDCODE, or BYPASS without one, is selected by a reset
+			d.ir = nil
+		}
+	case tapCaptureDR, tapCaptureIR:
+		d.cur = JTAGShift{Start: n, IR: next == tapCaptureIR, Selected: d.instruction(), Select: d.sel}
+	case tapPauseDR, tapPauseIR:
+		d.cur.Paused = true
+	case tapUpdateDR, tapUpdateIR:
+		d.cur.End = n
+		if d.cur.IR {
+			d.ir = d.cur.TDI
+		}
+		// A write to SELECT picks the AP registers of later APACC scans
+		if t := d.cur; t.Selected == "DPACC" && len(t.TDI) == 35 && t.TDI[0] == 0 && bitsValue(t.TDI, 1, 2)<<2 == 0x8 {
+			d.sel = uint32(bitsValue(t.TDI, 3, 32))
+		}
+		d.shifts = append(d.shifts, d.cur)
+	}
+	d.state = next
+}
+
+// pendingStart is the start of the scan in progress, or -1.
+func (d *jtagDecoder) pendingStart() int64 {
+	switch d.state {
+	case tapCaptureDR, tapShiftDR, tapExit1DR, tapPauseDR, tapExit2DR,
+		tapCaptureIR, tapShiftIR, tapExit1IR, tapPauseIR, tapExit2IR:
+		return d.cur.Start
+	}
+	return -1
+}
+
+// optionalBit samples an optional pin; unconnected pins read 0.
+func optionalBit(sample uint64, ch int) byte {
+	if ch < 0 {
+		return 0
+	}
+	return byte(sample >> ch & 1)
+}
+
+// jtagInstructions names the instructions of an Arm JTAG-DP, whose IR is 4
+// bits long.
+var jtagInstructions = map[uint64]string{
+	0x8: "ABORT",
+	0xA: "DPACC",
+	0xB: "APACC",
+	0xE: "IDCODE",
+	0xF: "BYPASS",
+}
+
+// instruction names the instruction last shifted into IR. A reset selects
+// IDCODE; all ones is BYPASS on any TAP.
+func (d *jtagDecoder) instruction() string {
+	if d.ir == nil {
+		return "IDCODE"
+	}
+	return instructionName(d.ir)
+}
+
+func instructionName(ir []byte) string {
+	v, ones := uint64(0), true
+	for i, b := range ir {
+		if i < 64 {
+			v |= uint64(b) << i
+		}
+		ones = ones && b == 1
+	}
+	if name, ok := jtagInstructions[v]; ok && len(ir) == 4 {
+		return name
+	}
+	if ones {
+		return "BYPASS"
+	}
+	return ""
+}
+
+// bitsHex formats bits shifted LSB first as a hex number, e.g. "0x4BA00477"
+// for an IDCODE.
+func bitsHex(bits []byte) string {
+	if len(bits) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("0x")
+	for hi := (len(bits) - 1) / 4 * 4; hi >= 0; hi -= 4 {
+		var nibble byte
+		for i := min(hi+3, len(bits)-1); i >= hi; i-- {
+			nibble = nibble<<1 | bits[i]
+		}
+		fmt.Fprintf(&b, "%X", nibble)
+	}
+	return b.String()
+}
+
+// bitsValue returns up to 64 bits shifted LSB first, from bit from on.
+func bitsValue(bits []byte, from, n int) uint64 {
+	var v uint64
+	for i := 0; i < n && from+i < len(bits); i++ {
+		v |= uint64(bits[from+i]) << i
+	}
+	return v
+}
+
+// idcodeText decodes an IDCODE: version, part number and manufacturer.
+func idcodeText(v uint32) string {
+	return fmt.Sprintf("IDCODE 0x%08X: %s, part 0x%04X, version %d", v, jep106Name(v>>1&0x7FF), v>>12&0xFFFF, v>>28)
+}
+
+// jtagInfo describes a scan: the instruction shifted in, the IDCODEs of
+// the devices in the chain, or the register a DPACC or APACC scan reaches.
+func jtagInfo(t JTAGShift, tdo bool) string {
+	var info []string
+	switch {
+	case t.IR:
+		if name := instructionName(t.TDI); name != "" {
+			info = append(info, name)
+		}
+	case t.Selected == "IDCODE" && tdo:
+		// One 32-bit IDCODE per device, nearest TDO first; bit 0 is always 1
+		for i := 0; i+32 <= len(t.TDO) && t.TDO[i] == 1; i += 32 {
+			info = append(info, idcodeText(uint32(bitsValue(t.TDO, i, 32))))
+		}
+	case (t.Selected == "DPACC" || t.Selected == "APACC") && len(t.TDI) == 35:
+		// RnW, A[3:2] and data in; the ACK and the previous result out
+		ap, read := t.Selected == "APACC", t.TDI[0] == 1
+		addr := uint8(bitsValue(t.TDI, 1, 2) << 2)
+		reg := swdRegister(ap, read, addr, t.Select)
+		if read {
+			info = append(info, "R "+reg)
+		} else {
+			info = append(info, fmt.Sprintf("W %s 0x%08X", reg, bitsValue(t.TDI, 3, 32)))
+		}
+		if tdo {
+			ack := map[uint64]string{0b010: "OK/FAULT", 0b001: "WAIT"}[bitsValue(t.TDO, 0, 3)]
+			info = append(info, fmt.Sprintf("ACK %s, previous 0x%08X", ack, bitsValue(t.TDO, 3, 32)))
+		}
+	}
+	if t.Paused {
+		info = append(info, "paused")
+	}
+	return strings.Join(info, "; ")
+}
+
+// jtagHeader is the CSV header of a JTAG port.
+var jtagHeader = []string{"time", "op", "bits", "tdi", "tdo", "info"}
+
+// jtagRow formats a scan as a CSV row.
+func jtagRow(s *Session, cfg JTAGConfig, t JTAGShift) []string {
+	time := fmt.Sprintf("%.9f", s.Seconds(t.Start))
+	if t.Reset {
+		return []string{time, "RESET", "", "", "", "Test-Logic-Reset"}
+	}
+	op, tdi, tdo := "DR", "", ""
+	if t.IR {
+		op = "IR"
+	}
+	if cfg.TDI != "" {
+		tdi = bitsHex(t.TDI)
+	}
+	if cfg.TDO != "" {
+		tdo = bitsHex(t.TDO)
+	}
+	return []string{time, op, fmt.Sprint(len(t.TDI)), tdi, tdo, jtagInfo(t, cfg.TDO != "")}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// jtagBus drives TCK=0, TMS=1, TDI=2 and TDO=3 of a synthetic capture, 10
+// samples per clock, changing the other lines while TCK is low.
+type jtagBus struct {
+	*signal
+}
+
+func newJTAGBus() *jtagBus {
+	b := &jtagBus{newSignal("TCK", "TMS", "TDI", "TDO")}
+	b.hold(10)
+	return b
+}
+
+func (b *jtagBus) clock(tms, tdi, tdo int) *jtagBus {
+	b.set(0, 0).set(1, tms).set(2, tdi).set(3, tdo).hold(5).set(0, 1).hold(5)
+	return b
+}
+
+func (b *jtagBus) tms(bits ...int) *jtagBus {
+	for _, v := range bits {
+		b.clock(v, 0, 0)
+	}
+	return b
+}
+
+// scan shifts n bits of tdi in and tdo out, LSB first, from Run-Test/Idle
+// back to it. pauseAt > 0 goes through Pause after that many bits.
+func (b *jtagBus) scan(ir bool, n int, tdi, tdo uint64, pauseAt int) *jtagBus {
+	b.tms(1)
+	if ir {
+		b.tms(1)
+	}
+	b.tms(0, 0)
+	for i := 0; i < n; i++ {
+		tms := 0
+		if i == n-1 || i == pauseAt-1 {
+			tms = 1
+		}
+		b.clock(tms, int(tdi>>i&1), int(tdo>>i&1))
+		if i == pauseAt-1 && i < n-1 {
+			b.tms(0, 0, 1, 0) // Pause, stay, Exit2, back to Shift
+		}
+	}
+	return b.tms(1, 0)
+}
+
+// dpacc builds the 35 bits of a DPACC or APACC scan: RnW, A[3:2], data.
+func dpacc(read bool, addr uint8, data uint32) uint64 {
+	v := uint64(data)<<3 | uint64(addr>>2)<<1
+	if read {
+		v |= 1
+	}
+	return v
+}
+
+func TestDecodeJTAG(t *testing.T) {
+	bus := newJTAGBus()
+	bus.tms(0, 1, 1, 1, 1, 1, 0)
+	bus.scan(false, 32, 0, 0x4BA00477, 0)
+	bus.scan(true, 4, 0xA, 0x1, 0)
+	bus.scan(false, 35, dpacc(false, 0x8, 0xF0), 0b010, 0)
+	bus.scan(true, 4, 0xB, 0x1, 0)
+	bus.scan(false, 35, dpacc(true, 0xC, 0), 0b010|0x12345678<<3, 20)
+	bus.tms(1, 1, 1, 1, 1)
+
+	s := bus.session(1000000)
+	cfg := JTAGConfig{TCK: "D0", TMS: "D1", TDI: "D2", TDO: "D3"}
+	got, err := decodeJTAG(s, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"RESET", "", "", "", "Test-Logic-Reset"},
+		{"DR", "32", "0x00000000", "0x4BA00477", "IDCODE 0x4BA00477: Arm, part 0xBA00, version 4"},
+		{"IR", "4", "0xA", "0x1", "DPACC"},
+		{"DR", "35", "0x000000784", "0x000000002", "W SELECT 0x000000F0; ACK OK/FAULT, previous 0x00000000"},
+		{"IR", "4", "0xB", "0x1", "APACC"},
+		{"DR", "35", "0x000000007", "0x091A2B3C2", "R IDR; ACK OK/FAULT, previous 0x12345678; paused"},
+		{"RESET", "", "", "", "Test-Logic-Reset"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d scans, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if row := jtagRow(s, cfg, got[i]); !slices.Equal(row[1:], w) {
+			t.Errorf("scan %d = %q\nwant %q", i, row[1:], w)
+		}
+	}
+
+	// Without TDO there is nothing to read IDCODEs from
+	cfg.TDO = ""
+	if row := jtagRow(s, cfg, got[1]); row[4] != "" || row[5] != "" {
+		t.Errorf("row without TDO = %q", row)
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"strings"
@@ -16,8 +19,37 @@ const (
 	ProtocolSPI Protocol = iota
 	ProtocolI2C
 	ProtocolUART
+	ProtocolOneWire
+	ProtocolCAN
+	ProtocolSWD
+	ProtocolJTAG
+	ProtocolI2S
+	ProtocolModbus
 )
 
+// protocolNames are the protocol names shown in the UI and written to
+// config files, indexed by Protocol.
+var protocolNames = []string{"SPI", "I2C", "UART", "1-Wire", "CAN", "SWD", "JTAG", "I2S", "Modbus"}
+
+func (p Protocol) String() string {
+	if int(p) < len(protocolNames) {
+		return protocolNames[p]
+	}
+	return fmt.Sprintf("Protocol(%d)", int(p))
+}
+
+// overUART reports whether a protocol is carried by UART characters and
+// shares the UART settings.
+func (p Protocol) overUART() bool {
+	return p == ProtocolUART || p == ProtocolModbus
+}
+
+// parseProtocol reads a protocol name in any case, e.g. "i2c".
+func parseProtocol(name string) (Protocol, error) {
+	p, err := parseChoice("protocol", name, protocolNames...)
+	return Protocol(p), err
+}
+
 type panel int
 
 const (
@@ -28,6 +60,10 @@ const (
 	panelStatus
 )
 
+// captureStartSlot is the last cursor position of the Capture panel, its
+// Start button.
+const captureStartSlot = 12
+
 type tickMsg time.Time
 
 type model struct {
@@ -35,9 +71,13 @@ type model struct {
 	cursor      int
 	protocol    Protocol
 
+	// Acquisition source
+	backend Backend
+
 	// Device selection
 	devices        []LogicAnalyzer
 	selectedDevice int
+	scanning       bool // A rescan is running
 
 	// SPI config
 	spiCLK  string
@@ -47,6 +87,10 @@ type model struct {
 	spiCPOL string // Clock polarity (0 or 1)
 	spiCPHA string // Clock phase (0 or 1)
 
+	spiBitOrder   string // MSB or LSB first
+	spiWordSize   string // Bits per word (4-32)
+	spiCSPolarity string // CS active level (low or high)
+
 	// I2C config
 	i2cSDA     string
 	i2cSCL     string
@@ -57,16 +101,79 @@ type model struct {
 	uartRX   string
 	uartBaud string
 
+	uartDataBits string // 5-9
+	uartParity   string // none, odd, even, mark or space
+	uartStopBits string // 1, 1.5 or 2
+	uartInvert   string // yes for idle-low lines
+
+	// 1-Wire config
+	oneWireDQ    string
+	oneWireSpeed string // standard or overdrive, the speed after a reset
+
+	// CAN config
+	canRX          string
+	canTX          string // Optional; tells the node's own frames apart
+	canBitrate     string
+	canDataBitrate string // CAN FD data phase
+
+	// SWD config
+	swdCLK string
+	swdIO  string
+
+	// JTAG config
+	jtagTCK string
+	jtagTMS string
+	jtagTDI string
+	jtagTDO string
+
+	// I2S config
+	i2sBCLK       string
+	i2sWS         string
+	i2sSD         string
+	i2sWordLength string
+	i2sJustify    string // i2s, left or right
+	i2sSlots      string // 2 for stereo, more for TDM
+
 	// Capture settings
-	duration     string
-	outputFile   string
+	duration      string
+	outputFile    string
+	outputPattern string // Names the output file of each capture, see outputName
 	sampleRate   string
 	filterFrames bool // Filter out frames without valid data bytes
+	continuous   bool // Stream and decode until stopped or duration elapses
+
+	// Trigger per protocol: a preset name or a custom sigrok-cli spec
+	spiTrigger     string
+	i2cTrigger     string
+	uartTrigger    string
+	oneWireTrigger string
+	canTrigger     string
+	swdTrigger     string
+	jtagTrigger    string
+	i2sTrigger     string
+	modbusTrigger  string
+	preTrigger     string // Percent of the capture kept before the trigger
+
+	// Software trigger on decoded data and the time kept around a match
+	softTrigger string
+	softBefore  string
+	softAfter   string
+
+	// Analog inputs to capture, e.g. "A0,A1", and the level that splits
+	// them into high and low when used as protocol pins
+	analogChannels  string
+	analogThreshold string
 
 	// State
 	capturing      bool
 	captureErr     error
+	stream         *captureStream // Running continuous capture
+	cancelCapture  context.CancelFunc
 	outputData     []string // Captured output lines
+	session        *Session // Last capture or opened .sr file
+	sessionFile    string
+	showTrace      bool     // Output panel shows waveforms instead of CSV
+	trace          []string // Rendered waveforms for the current width
 	statusMsg      string
 	editing        bool
 	editBuffer     string
@@ -77,6 +184,26 @@ type model struct {
 	selectingSampleRate bool // True when selecting from sample rate dropdown
 	sampleRateOptions   []string
 	sampleRateCursor    int
+	selectingTrigger bool // True when selecting from trigger presets
+	triggerCursor    int
+
+	// Saved settings and profiles
+	configPath       string // Empty when settings are not persisted
+	config           *Config
+	profile          string // Last loaded or saved profile
+	selectingProfile bool   // Output panel lists the profiles
+	profileCursor    int
+	namingProfile    bool   // Typing a profile name into editBuffer
+	renamingProfile  string // Profile being renamed; empty for a new one
+
+	// .lazysig.yaml of the current repository, if any
+	project   *Project
+	registers map[int]map[int]string // I2C register names by device address
+
+	// Buses decoded together; empty when only the protocol above is decoded.
+	// The fields above hold the settings of the active bus.
+	buses     []Bus
+	activeBus int
 
 	// UI dimensions
 	width  int
@@ -115,27 +242,38 @@ var (
 	errorStyle = lipgloss.NewStyle().
 			Foreground(lipgloss.Color("196"))
 
+	warningStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("214"))
+
 	statusBarStyle = lipgloss.NewStyle().
 			Foreground(lipgloss.Color("241")).
 			Background(lipgloss.Color("235")).
 			Padding(0, 1)
 )
 
-func initialModel() model {
-	devices, err := discoverDevices()
+func initialModel(backend Backend) model {
+	devices, err := discoverDevices(backend)
 	if err != nil {
 		devices = []LogicAnalyzer{}
 	}
 
-	statusMsg := "Ready"
+	m := defaultModel(backend)
+	m.devices = devices
 	if len(devices) == 0 {
-		statusMsg = "No devices found"
+		m.statusMsg = "No devices found"
+	} else {
+		m.selectDevice(0)
 	}
+	return m
+}
 
+// defaultModel returns the default settings without scanning for devices.
+func defaultModel(backend Backend) model {
 	return model{
 		activePanel:    panelDevices,
+		backend:        backend,
 		cursor:         0,
-		devices:        devices,
+		devices:        []LogicAnalyzer{},
 		selectedDevice: 0,
 		protocol:       ProtocolSPI,
 		spiCLK:         "D2",
@@ -144,28 +282,88 @@ func initialModel() model {
 		spiCS:          "D3",
 		spiCPOL:        "0",
 		spiCPHA:        "0",
+		spiBitOrder:    "MSB",
+		spiWordSize:    "8",
+		spiCSPolarity:  "low",
 		i2cSDA:         "D0",
 		i2cSCL:         "D1",
-		i2cAddress:     "0x50",
+		i2cAddress:     "any",
 		uartTX:         "D0",
 		uartRX:         "D1",
 		uartBaud:       "115200",
+		uartDataBits:   "8",
+		uartParity:     "none",
+		uartStopBits:   "1",
+		uartInvert:     "no",
+		oneWireDQ:      "D0",
+		oneWireSpeed:   "standard",
+		canRX:          "D0",
+		canBitrate:     "500000",
+		canDataBitrate: "2000000",
+		swdCLK:         "D0",
+		swdIO:          "D1",
+		jtagTCK:        "D0",
+		jtagTMS:        "D1",
+		jtagTDI:        "D2",
+		jtagTDO:        "D3",
+		i2sBCLK:        "D0",
+		i2sWS:          "D1",
+		i2sSD:          "D2",
+		i2sWordLength:  "16",
+		i2sJustify:     "i2s",
+		i2sSlots:       "2",
 		duration:       "500ms",
 		outputFile:     "output.csv",
 		sampleRate:     "24000000",
 		filterFrames:   false,
+		spiTrigger:     "CS active",
+		i2cTrigger:     triggerNone,
+		uartTrigger:    triggerNone,
+		oneWireTrigger: triggerNone,
+		canTrigger:     triggerNone,
+		swdTrigger:     triggerNone,
+		jtagTrigger:    triggerNone,
+		i2sTrigger:     triggerNone,
+		modbusTrigger:  triggerNone,
+		preTrigger:     "10",
+		softTrigger:    triggerNone,
+		softBefore:     "1ms",
+		softAfter:      "10ms",
+		analogChannels:  analogNone,
+		analogThreshold: "1.5",
 		durationOptions:     []string{"2000ms", "1000ms", "500ms", "250ms", "Custom..."},
 		durationCursor:      2, // Default to 500ms
 		sampleRateOptions:   []string{"48000000", "24000000", "16000000", "12000000", "8000000", "6000000", "4000000", "2000000", "1000000", "Custom..."},
 		sampleRateCursor:    1, // Default to 24MHz
-		statusMsg:           statusMsg,
+		statusMsg:           "Ready",
+		config:              newConfig(),
 		outputData:          []string{},
 		capturing:           false,
 	}
 }
 
+// selectDevice makes device i the capture device and offers its sample
+// rates.
+func (m *model) selectDevice(i int) {
+	m.selectedDevice = i
+	d := m.devices[i]
+	m.sampleRateOptions = d.rateOptions()
+	m.sampleRateCursor = 0
+	for j, opt := range m.sampleRateOptions {
+		if opt == m.sampleRate {
+			m.sampleRateCursor = j
+		}
+	}
+
+	rate, err := parseSampleRate(m.sampleRate)
+	if err == nil && !d.supportsRate(rate) {
+		m.statusMsg = fmt.Sprintf("Warning: %s does not support %s; pick another rate",
+			d.Model, formatSampleRate(m.sampleRate))
+	}
+}
+
 func (m model) Init() tea.Cmd {
-	return nil
+	return pollDevices()
 }
 
 func tick() tea.Cmd {
@@ -179,6 +377,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
 		m.height = msg.Height
+		m.renderTrace()
 		return m, nil
 	case tea.KeyMsg:
 		// Handle sample rate dropdown selection
@@ -209,6 +408,39 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 		}
 
+		// Handle the profile list
+		if m.selectingProfile {
+			return m.updateProfiles(msg)
+		}
+
+		// Handle trigger dropdown selection
+		if m.selectingTrigger {
+			options := m.triggerOptions()
+			switch msg.String() {
+			case "up", "k":
+				if m.triggerCursor > 0 {
+					m.triggerCursor--
+				}
+			case "down", "j":
+				if m.triggerCursor < len(options)-1 {
+					m.triggerCursor++
+				}
+			case "enter":
+				selected := options[m.triggerCursor]
+				m.selectingTrigger = false
+				if selected == "Custom..." {
+					// Edit the current trigger as a spec
+					m.editing = true
+					m.editBuffer = m.triggerSpec()
+				} else {
+					*m.triggerField() = selected
+				}
+			case "esc":
+				m.selectingTrigger = false
+			}
+			return m, nil
+		}
+
 		// Handle duration dropdown selection
 		if m.selectingDuration {
 			switch msg.String() {
@@ -263,16 +495,45 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		// Normal navigation mode
 		switch msg.String() {
 		case "ctrl+c", "q":
+			// Don't leave sigrok-cli running behind
+			if m.cancelCapture != nil {
+				m.cancelCapture()
+			}
+			if m.stream != nil {
+				m.stream.stop()
+			}
+			// Restored on the next launch
+			m.saveSettings()
 			return m, tea.Quit
 		case "s":
 			// Quick start capture with current settings
-			if len(m.devices) == 0 {
-				m.statusMsg = "Error: No device selected"
-			} else if !m.capturing {
-				m.capturing = true
-				m.captureSpinner = 0
-				m.statusMsg = "Capturing..."
-				return m, tea.Batch(startCapture(m), tick())
+			if !m.capturing {
+				return m.beginCapture()
+			}
+		case "x":
+			// Stop a continuous capture or cancel a single one
+			if m.stream != nil {
+				m.stream.stop()
+				m.statusMsg = "Stopping..."
+			} else if m.cancelCapture != nil {
+				m.cancelCapture()
+				m.statusMsg = "Cancelling..."
+			}
+		case "r":
+			// Rescan for devices
+			if !m.scanning && !m.capturing {
+				m.scanning = true
+				m.statusMsg = "Scanning for devices..."
+				return m, scanDevices(m.backend, m.devices, true)
+			}
+		case "p":
+			// Open the profile list
+			m.selectingProfile = true
+			m.profileCursor = 0
+			for i, name := range m.profileNames() {
+				if name == m.profile {
+					m.profileCursor = i
+				}
 			}
 		case "f":
 			// Toggle filter
@@ -282,6 +543,34 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			} else {
 				m.statusMsg = "Filter: OFF"
 			}
+		case "w":
+			// Toggle waveform view
+			if m.session == nil {
+				m.statusMsg = "No capture to show"
+			} else {
+				m.showTrace = !m.showTrace
+			}
+		case "b":
+			// Fill the baud rate from the last capture
+			if m.activePanel == panelConfiguration && m.protocol.overUART() {
+				m.uartBaudFromCapture()
+			}
+		case "a":
+			// Decode another bus alongside the current one
+			if m.activePanel == panelConfiguration {
+				m.addBus()
+				m.cursor = 0
+			}
+		case "[", "]":
+			if m.activePanel == panelConfiguration {
+				m.switchBus(map[string]int{"[": -1, "]": 1}[msg.String()])
+				m.cursor = 0
+			}
+		case "-":
+			if m.activePanel == panelConfiguration {
+				m.removeBus()
+				m.cursor = 0
+			}
 		case "d":
 			// Jump to duration and open dropdown
 			m.activePanel = panelCaptureSettings
@@ -322,7 +611,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.cursor--
 			}
 		case "down", "j":
-			m.cursor++
+			if m.cursor < m.lastCursor() {
+				m.cursor++
+			}
 		case "enter":
 			return m.handleEnter()
 		}
@@ -332,41 +623,141 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.captureSpinner++
 			return m, tick()
 		}
+	case pollDevicesMsg:
+		// Scanning while capturing could disturb the device
+		if m.scanning || m.capturing {
+			return m, pollDevices()
+		}
+		m.scanning = true
+		return m, scanDevices(m.backend, m.devices, false)
+	case devicesMsg:
+		m.scanning = false
+		m.updateDevices(msg)
+		if m.activePanel == panelDevices && m.cursor >= len(m.devices) {
+			m.cursor = max(len(m.devices)-1, 0)
+		}
+		if !msg.manual {
+			return m, pollDevices()
+		}
 	case captureCompleteMsg:
 		m.capturing = false
 		m.captureErr = msg.err
-		if msg.err != nil {
+		if m.cancelCapture != nil {
+			m.cancelCapture()
+			m.cancelCapture = nil
+		}
+		if errors.Is(msg.err, context.Canceled) {
+			m.statusMsg = "Capture cancelled"
+		} else if msg.err != nil {
 			m.statusMsg = "Capture failed: " + msg.err.Error()
 		} else {
 			m.statusMsg = "Capture complete: " + m.outputFile
 			// Load output data
 			m.loadOutputData()
+			if err := m.openCapture(captureFile); err != nil {
+				m.statusMsg = "Capture complete, but " + err.Error()
+			}
 		}
 		return m, nil
+	case streamRowsMsg:
+		for _, row := range msg.rows {
+			m.outputData = append(m.outputData, strings.Join(row, ","))
+		}
+		// Keep the header and the most recent rows
+		if over := len(m.outputData) - maxLiveLines; over > 0 {
+			m.outputData = append(m.outputData[:1], m.outputData[1+over:]...)
+		}
+		if m.stream != nil {
+			return m, m.stream.wait()
+		}
+	case streamDoneMsg:
+		m.capturing = false
+		m.stream = nil
+		m.captureErr = msg.err
+		switch {
+		case msg.err != nil:
+			m.statusMsg = "Capture failed: " + msg.err.Error()
+		case msg.triggered:
+			m.statusMsg = "Capture complete: software trigger matched: " + m.outputFile
+			m.loadOutputData()
+			if err := m.openCapture(captureFile); err != nil {
+				m.statusMsg = "Capture complete, but " + err.Error()
+			}
+		case msg.limited:
+			m.statusMsg = fmt.Sprintf("Capture complete: %d records, limit reached: %s", msg.records, m.outputFile)
+		default:
+			m.statusMsg = fmt.Sprintf("Capture complete: %d records, stopped: %s", msg.records, m.outputFile)
+		}
 	}
 	return m, nil
 }
 
+// beginCapture starts a single or continuous capture with the current
+// settings.
+func (m model) beginCapture() (model, tea.Cmd) {
+	if len(m.devices) == 0 {
+		m.statusMsg = "Error: No device selected"
+		return m, nil
+	}
+	// Settings the device can't capture are reported before starting
+	if _, err := captureRequest(m); err != nil {
+		m.statusMsg = "Error: " + err.Error()
+		return m, nil
+	}
+	if err := m.nextOutputFile(time.Now()); err != nil {
+		m.statusMsg = "Error: " + err.Error()
+		return m, nil
+	}
+
+	if m.continuous {
+		stream, cmd, err := startStream(m)
+		if err != nil {
+			m.statusMsg = "Capture failed: " + err.Error()
+			return m, nil
+		}
+		m.stream = stream
+		m.outputData = nil
+		m.showTrace = false
+		m.capturing = true
+		m.captureSpinner = 0
+		m.statusMsg = "Capturing continuously..."
+		return m, tea.Batch(cmd, tick())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancelCapture = cancel
+	m.capturing = true
+	m.captureSpinner = 0
+	m.statusMsg = "Capturing..."
+	return m, tea.Batch(startCapture(ctx, m), tick())
+}
+
+// lastCursor is the highest cursor position of the active panel.
+func (m model) lastCursor() int {
+	switch m.activePanel {
+	case panelDevices:
+		return max(len(m.devices)-1, 0)
+	case panelConfiguration:
+		return len(m.configFields()) // After the protocol
+	case panelCaptureSettings:
+		return captureStartSlot
+	}
+	return 0
+}
+
 func (m model) handleEnter() (tea.Model, tea.Cmd) {
 	switch m.activePanel {
 	case panelDevices:
 		if len(m.devices) > 0 && m.cursor < len(m.devices) {
-			m.selectedDevice = m.cursor
-			m.statusMsg = "Device selected: " + m.devices[m.selectedDevice].DisplayName
+			m.statusMsg = "Device selected: " + m.devices[m.cursor].DisplayName
+			m.selectDevice(m.cursor)
 		}
 	case panelConfiguration:
 		// Toggle protocol or edit pin values
 		if m.cursor == 0 {
 			// Cycle through protocols
-			m.protocol = (m.protocol + 1) % 3
-			switch m.protocol {
-			case ProtocolSPI:
-				m.statusMsg = "Protocol: SPI"
-			case ProtocolI2C:
-				m.statusMsg = "Protocol: I2C"
-			case ProtocolUART:
-				m.statusMsg = "Protocol: UART"
-			}
+			m.protocol = (m.protocol + 1) % Protocol(len(protocolNames))
+			m.statusMsg = "Protocol: " + m.protocol.String()
 		} else {
 			// Edit pin configuration
 			m.editing = true
@@ -396,17 +787,46 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 			m.editing = true
 			m.editBuffer = m.outputFile
 		} else if m.cursor == 3 {
+			// Trigger dropdown
+			m.selectingTrigger = true
+			m.triggerCursor = len(m.triggerOptions()) - 1 // Custom...
+			for i, opt := range m.triggerOptions() {
+				if strings.EqualFold(opt, *m.triggerField()) {
+					m.triggerCursor = i
+					break
+				}
+			}
+		} else if m.cursor == 4 {
+			// Pre-trigger percentage
+			m.editing = true
+			m.editBuffer = m.preTrigger
+		} else if m.cursor == 5 {
+			// Software trigger
+			m.editing = true
+			m.editBuffer = m.softTrigger
+		} else if m.cursor == 6 {
+			m.editing = true
+			m.editBuffer = m.softBefore
+		} else if m.cursor == 7 {
+			m.editing = true
+			m.editBuffer = m.softAfter
+		} else if m.cursor == 8 {
+			// Analog channels
+			m.editing = true
+			m.editBuffer = m.analogChannels
+		} else if m.cursor == 9 {
+			m.editing = true
+			m.editBuffer = m.analogThreshold
+		} else if m.cursor == 10 {
+			// Toggle single or continuous capture
+			m.continuous = !m.continuous
+		} else if m.cursor == 11 {
 			// Toggle filter
 			m.filterFrames = !m.filterFrames
-		} else if m.cursor == 4 {
+		} else if m.cursor == captureStartSlot {
 			// Start capture
-			if len(m.devices) == 0 {
-				m.statusMsg = "Error: No device selected"
-			} else {
-				m.capturing = true
-				m.captureSpinner = 0
-				m.statusMsg = "Capturing..."
-				return m, tea.Batch(startCapture(m), tick())
+			if !m.capturing {
+				return m.beginCapture()
 			}
 		}
 	}
@@ -416,39 +836,17 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 func (m *model) saveEdit() {
 	switch m.activePanel {
 	case panelConfiguration:
-		if m.protocol == ProtocolSPI {
-			switch m.cursor - 1 { // cursor 0 is protocol toggle
-			case 0:
-				m.spiCLK = m.editBuffer
-			case 1:
-				m.spiMOSI = m.editBuffer
-			case 2:
-				m.spiMISO = m.editBuffer
-			case 3:
-				m.spiCS = m.editBuffer
-			case 4:
-				m.spiCPOL = m.editBuffer
-			case 5:
-				m.spiCPHA = m.editBuffer
-			}
-		} else if m.protocol == ProtocolI2C {
-			switch m.cursor - 1 {
-			case 0:
-				m.i2cSDA = m.editBuffer
-			case 1:
-				m.i2cSCL = m.editBuffer
-			case 2:
-				m.i2cAddress = m.editBuffer
-			}
-		} else if m.protocol == ProtocolUART {
-			switch m.cursor - 1 {
-			case 0:
-				m.uartTX = m.editBuffer
-			case 1:
-				m.uartRX = m.editBuffer
-			case 2:
-				m.uartBaud = m.editBuffer
+		// cursor 0 is protocol toggle
+		fields := m.configFields()
+		if i := m.cursor - 1; i >= 0 && i < len(fields) {
+			if m.multiBus() && i == 0 {
+				// The bus label becomes part of channel names
+				if err := m.validateBusLabel(m.editBuffer); err != nil {
+					m.statusMsg = "Error: " + err.Error()
+					return
+				}
 			}
+			*fields[i].value = m.editBuffer
 		}
 	case panelCaptureSettings:
 		switch m.cursor {
@@ -460,45 +858,147 @@ func (m *model) saveEdit() {
 		case 1:
 			m.duration = m.editBuffer
 		case 2:
+			// A file typed in replaces the project's naming
 			m.outputFile = m.editBuffer
+			m.outputPattern = ""
+		case 3:
+			// Custom trigger - keep the previous one if it doesn't parse
+			if _, err := parseTrigger(m.editBuffer); err != nil {
+				m.statusMsg = "Error: " + err.Error()
+				return
+			}
+			*m.triggerField() = strings.TrimSpace(m.editBuffer)
+			if *m.triggerField() == "" {
+				*m.triggerField() = triggerNone
+			}
+		case 4:
+			if _, err := parseIntRange("pre-trigger", m.editBuffer, 0, 99); err != nil {
+				m.statusMsg = "Error: " + err.Error()
+				return
+			}
+			m.preTrigger = strings.TrimSpace(m.editBuffer)
+		case 5:
+			if _, err := parseSoftTrigger(m.editBuffer); err != nil {
+				m.statusMsg = "Error: " + err.Error()
+				return
+			}
+			m.softTrigger = strings.TrimSpace(m.editBuffer)
+			if m.softTrigger == "" {
+				m.softTrigger = triggerNone
+			}
+		case 6:
+			m.softBefore = strings.TrimSpace(m.editBuffer)
+		case 7:
+			m.softAfter = strings.TrimSpace(m.editBuffer)
+		case 8:
+			m.analogChannels = strings.TrimSpace(m.editBuffer)
+			if m.analogChannels == "" {
+				m.analogChannels = analogNone
+			}
+		case 9:
+			m.analogThreshold = strings.TrimSpace(m.editBuffer)
 		}
 	}
 }
 
-func (m model) getCurrentConfigValue() string {
-	if m.protocol == ProtocolSPI {
-		switch m.cursor - 1 {
-		case 0:
-			return m.spiCLK
-		case 1:
-			return m.spiMOSI
-		case 2:
-			return m.spiMISO
-		case 3:
-			return m.spiCS
-		case 4:
-			return m.spiCPOL
-		case 5:
-			return m.spiCPHA
+// configField is one editable row of the Configuration panel.
+type configField struct {
+	label string
+	value *string
+}
+
+// configFields lists the editable rows for the selected protocol, in display
+// order. Row i is at cursor i+1 since cursor 0 is the protocol toggle. With
+// several buses the label of the active one comes first.
+func (m *model) configFields() []configField {
+	if m.multiBus() {
+		return append([]configField{{"Label", &m.buses[m.activeBus].Label}}, m.protocolFields()...)
+	}
+	return m.protocolFields()
+}
+
+// protocolFields lists the settings of the selected protocol.
+func (m *model) protocolFields() []configField {
+	switch m.protocol {
+	case ProtocolSPI:
+		return []configField{
+			{"CLK", &m.spiCLK},
+			{"MOSI", &m.spiMOSI},
+			{"MISO", &m.spiMISO},
+			{"CS", &m.spiCS},
+			{"CPOL", &m.spiCPOL},
+			{"CPHA", &m.spiCPHA},
+			{"Order", &m.spiBitOrder},
+			{"Bits", &m.spiWordSize},
+			{"CS Pol", &m.spiCSPolarity},
 		}
-	} else if m.protocol == ProtocolI2C {
-		switch m.cursor - 1 {
-		case 0:
-			return m.i2cSDA
-		case 1:
-			return m.i2cSCL
-		case 2:
-			return m.i2cAddress
+	case ProtocolI2C:
+		return []configField{
+			{"SDA", &m.i2cSDA},
+			{"SCL", &m.i2cSCL},
+			{"Addr", &m.i2cAddress},
 		}
-	} else if m.protocol == ProtocolUART {
-		switch m.cursor - 1 {
-		case 0:
-			return m.uartTX
-		case 1:
-			return m.uartRX
-		case 2:
-			return m.uartBaud
+	case ProtocolUART, ProtocolModbus:
+		return []configField{
+			{"TX", &m.uartTX},
+			{"RX", &m.uartRX},
+			{"Baud", &m.uartBaud},
+			{"Bits", &m.uartDataBits},
+			{"Parity", &m.uartParity},
+			{"Stop", &m.uartStopBits},
+			{"Invert", &m.uartInvert},
+		}
+	case ProtocolOneWire:
+		return []configField{
+			{"DQ", &m.oneWireDQ},
+			{"Speed", &m.oneWireSpeed},
+		}
+	case ProtocolCAN:
+		return []configField{
+			{"RX", &m.canRX},
+			{"TX", &m.canTX},
+			{"Bitrate", &m.canBitrate},
+			{"FD Rate", &m.canDataBitrate},
+		}
+	case ProtocolSWD:
+		return []configField{
+			{"SWCLK", &m.swdCLK},
+			{"SWDIO", &m.swdIO},
 		}
+	case ProtocolJTAG:
+		return []configField{
+			{"TCK", &m.jtagTCK},
+			{"TMS", &m.jtagTMS},
+			{"TDI", &m.jtagTDI},
+			{"TDO", &m.jtagTDO},
+		}
+	case ProtocolI2S:
+		return []configField{
+			{"BCLK", &m.i2sBCLK},
+			{"WS", &m.i2sWS},
+			{"SD", &m.i2sSD},
+			{"Bits", &m.i2sWordLength},
+			{"Justify", &m.i2sJustify},
+			{"Slots", &m.i2sSlots},
+		}
+	}
+	return nil
+}
+
+// triggerOptions lists the trigger dropdown: the presets of the selected
+// protocol followed by a custom entry.
+func (m model) triggerOptions() []string {
+	var options []string
+	for _, p := range m.triggerPresets() {
+		options = append(options, p.name)
+	}
+	return append(options, "Custom...")
+}
+
+func (m model) getCurrentConfigValue() string {
+	fields := m.configFields()
+	if i := m.cursor - 1; i >= 0 && i < len(fields) {
+		return *fields[i].value
 	}
 	return ""
 }
@@ -516,6 +1016,28 @@ func (m *model) loadOutputData() {
 	}
 }
 
+// openCapture loads a session file for the waveform view.
+func (m *model) openCapture(path string) error {
+	s, err := openSession(path)
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %w", path, err)
+	}
+	m.session = s
+	m.sessionFile = path
+	m.renderTrace()
+	return nil
+}
+
+// renderTrace redraws the waveforms to fit the Output panel.
+func (m *model) renderTrace() {
+	if m.session == nil || m.width == 0 {
+		return
+	}
+	// Output panel width minus borders, padding and channel labels
+	columns := max(m.width-35-6-6-8, 10)
+	m.trace = generateASCIITrace(m.session, columns)
+}
+
 func (m model) View() string {
 	if m.width == 0 {
 		return "Loading..."
@@ -536,7 +1058,7 @@ func (m model) View() string {
 	// Left panels heights
 	devicesHeight := 8
 	configHeight := 12
-	captureHeight := 10
+	captureHeight := 18
 	leftTotalHeight := devicesHeight + configHeight + captureHeight + 6 // +6 for borders/padding
 
 	// Right panels heights - match left total
@@ -585,7 +1107,45 @@ func (m model) View() string {
 
 
 func main() {
-	p := tea.NewProgram(initialModel())
+	demo := flag.Bool("demo", false, "replay a recorded capture instead of using hardware")
+	flag.Parse()
+
+	var backend Backend = newSigrokBackend()
+	if *demo {
+		demoBackend := newDemoBackend()
+		demoBackend.realTime = true
+		backend = demoBackend
+	}
+
+	// Subcommands such as `lazysig capture` run without the TUI
+	if _, ok := cliCommands[flag.Arg(0)]; ok {
+		os.Exit(runCLI(backend, flag.Args(), os.Stdout, os.Stderr))
+	}
+
+	m := initialModel(backend)
+	if path, err := configPath(); err == nil {
+		m.configPath = path
+		m.loadSettings()
+	}
+	// The repository's wiring takes precedence over the last session
+	if dir, err := os.Getwd(); err == nil {
+		if path := findProject(dir); path != "" {
+			m.useProject(path)
+		}
+	}
+
+	// An existing capture can be opened without sigrok-cli installed
+	if flag.NArg() > 0 {
+		if err := m.openCapture(flag.Arg(0)); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		m.showTrace = true
+		m.activePanel = panelOutput
+		m.statusMsg = "Opened " + flag.Arg(0)
+	}
+
+	p := tea.NewProgram(m)
 	if _, err := p.Run(); err != nil {
 		fmt.Printf("Error: %v", err)
 		os.Exit(1)
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ModbusMessage is one Modbus RTU frame: the characters on one line
+// between silences of 3.5 characters.
+type ModbusMessage struct {
+	Start, End int64
+	RX         bool
+	Bytes      []byte // Slave ID, function code, data and CRC
+
+	// Set when any of the characters had the error
+	ParityError, FramingError bool
+}
+
+// Modbus function codes with decoded fields. Responses with the top bit of
+// the function code set are exceptions.
+const (
+	modbusReadHolding   = 0x03
+	modbusReadInput     = 0x04
+	modbusWriteSingle   = 0x06
+	modbusWriteMultiple = 0x10
+	modbusException     = 0x80
+)
+
+var modbusFunctions = map[byte]string{
+	0x01: "Read Coils",
+	0x02: "Read Discrete Inputs",
+	0x03: "Read Holding Registers",
+	0x04: "Read Input Registers",
+	0x05: "Write Single Coil",
+	0x06: "Write Single Register",
+	0x07: "Read Exception Status",
+	0x08: "Diagnostics",
+	0x0B: "Get Comm Event Counter",
+	0x0F: "Write Multiple Coils",
+	0x10: "Write Multiple Registers",
+	0x11: "Report Server ID",
+	0x16: "Mask Write Register",
+	0x17: "Read/Write Multiple Registers",
+	0x2B: "Encapsulated Interface Transport",
+}
+
+var modbusExceptions = map[byte]string{
+	0x01: "Illegal Function",
+	0x02: "Illegal Data Address",
+	0x03: "Illegal Data Value",
+	0x04: "Server Device Failure",
+	0x05: "Acknowledge",
+	0x06: "Server Device Busy",
+	0x08: "Memory Parity Error",
+	0x0A: "Gateway Path Unavailable",
+	0x0B: "Gateway Target Device Failed to Respond",
+}
+
+func (msg *ModbusMessage) slave() byte    { return msg.Bytes[0] }
+func (msg *ModbusMessage) function() byte { return msg.Bytes[1] }
+
+// pdu is the data after the function code, without the CRC.
+func (msg *ModbusMessage) pdu() []byte { return msg.Bytes[2 : len(msg.Bytes)-2] }
+
+// short reports whether the message is too short for a slave ID, function
+// code and CRC, like line noise or a message cut off by the capture.
+func (msg *ModbusMessage) short() bool { return len(msg.Bytes) < 4 }
+
+// crcOK checks the CRC16 at the end of the message, sent low byte first.
+func (msg *ModbusMessage) crcOK() bool {
+	n := len(msg.Bytes)
+	return modbusCRC(msg.Bytes[:n-2]) == uint16(msg.Bytes[n-2])|uint16(msg.Bytes[n-1])<<8
+}
+
+// modbusCRC is the CRC-16/MODBUS of data: polynomial 0x8005 reflected,
+// starting from 0xFFFF.
+func modbusCRC(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// isRequest reports whether a message has the length of a request for its
+// function code. Unknown function codes are taken as requests.
+func (msg *ModbusMessage) isRequest() bool {
+	switch msg.function() {
+	case 0x01, 0x02, modbusReadHolding, modbusReadInput, 0x05, modbusWriteSingle:
+		return len(msg.Bytes) == 8
+	case 0x0F, modbusWriteMultiple:
+		return len(msg.Bytes) >= 9 && len(msg.Bytes) == 9+int(msg.Bytes[6])
+	}
+	return msg.function()&modbusException == 0
+}
+
+// answers reports whether a message can be the response to req: the same
+// slave and function, and the length of a response to it.
+func (msg *ModbusMessage) answers(req *ModbusMessage) bool {
+	if msg.slave() != req.slave() {
+		return false
+	}
+	switch msg.function() {
+	case req.function() | modbusException:
+		return len(msg.Bytes) == 5
+	case req.function():
+	default:
+		return false
+	}
+	switch msg.function() {
+	case 0x01, 0x02, modbusReadHolding, modbusReadInput:
+		return len(msg.Bytes) == 5+int(msg.Bytes[2])
+	case 0x05, modbusWriteSingle, 0x0F, modbusWriteMultiple:
+		return len(msg.Bytes) == 8
+	}
+	return true
+}
+
+// ModbusTransaction is a request and its response. Broadcasts and
+// requests that got no answer have no response; a response seen without
+// its request, e.g. at the start of a capture, has no request.
+type ModbusTransaction struct {
+	Request, Response *ModbusMessage
+}
+
+// first is the earlier of the two messages.
+func (t ModbusTransaction) first() *ModbusMessage {
+	if t.Request != nil {
+		return t.Request
+	}
+	return t.Response
+}
+
+// modbusDecoder frames the characters of a UART decoder into Modbus RTU
+// messages and pairs requests with responses. Each line is framed on its
+// own, so a master and a slave on TX and RX work as well as both on the
+// one line of a half-duplex RS-485 bus.
+type modbusDecoder struct {
+	*uartDecoder
+	gap     int64 // Samples of silence that end a message
+	n       int64 // Last sample fed
+	open    map[bool]*ModbusMessage
+	pending *ModbusMessage // Request waiting for its response
+
+	transactions []ModbusTransaction
+}
+
+func newModbusDecoder(s *Session, cfg UARTConfig) (*modbusDecoder, error) {
+	u, err := newUARTDecoder(s, cfg)
+	if err != nil {
+		return nil, err
+	}
+	bits := 1 + float64(cfg.DataBits) + cfg.StopBits
+	if cfg.Parity != ParityNone {
+		bits++
+	}
+	d := &modbusDecoder{uartDecoder: u, open: map[bool]*ModbusMessage{}}
+	d.gap = int64(3.5 * bits * float64(s.SampleRate) / float64(cfg.Baud))
+	// Above 19200 baud the spec fixes the silence at 1.75 ms
+	if cfg.Baud > 19200 {
+		d.gap = int64(s.SampleRate) * 1750 / 1000000
+	}
+	return d, nil
+}
+
+// decodeModbus decodes every transaction in a session.
+func decodeModbus(s *Session, cfg UARTConfig) ([]ModbusTransaction, error) {
+	d, err := newModbusDecoder(s, cfg)
+	if err != nil {
+		return nil, err
+	}
+	decodeSession(s, d)
+	d.finish()
+	return d.transactions, nil
+}
+
+func (d *modbusDecoder) feed(n int64, sample uint64) {
+	d.uartDecoder.feed(n, sample)
+	d.n = n
+}
+
+// collect frames the characters the UART decoder has finished and ends
+// messages the line has been silent after for long enough.
+func (d *modbusDecoder) collect() {
+	for _, f := range d.take() {
+		// Characters come in time order, so any line silent since a gap
+		// before this one has ended its message
+		for _, rx := range []bool{false, true} {
+			if msg := d.open[rx]; msg != nil && f.Start-msg.End > d.gap {
+				d.message(msg)
+			}
+		}
+		msg := d.open[f.RX]
+		if msg == nil {
+			msg = &ModbusMessage{Start: f.Start, RX: f.RX}
+			d.open[f.RX] = msg
+		}
+		msg.End = f.End
+		msg.Bytes = append(msg.Bytes, byte(f.Value))
+		msg.ParityError = msg.ParityError || f.ParityError
+		msg.FramingError = msg.FramingError || f.FramingError
+	}
+	for _, rx := range []bool{false, true} {
+		if msg := d.open[rx]; msg != nil && d.silent(rx, msg.End) {
+			d.message(msg)
+		}
+	}
+}
+
+// silent reports whether a line has carried nothing for a gap since end,
+// including characters still held back by the UART decoder.
+func (d *modbusDecoder) silent(rx bool, end int64) bool {
+	if d.n-end <= d.gap {
+		return false
+	}
+	for _, f := range d.frames {
+		if f.RX == rx {
+			return false
+		}
+	}
+	for _, l := range d.lines {
+		if l.rx == rx && l.inFrame {
+			return false
+		}
+	}
+	return true
+}
+
+// pendingStart is the start of the earliest transaction that may still be
+// completed: a request waiting for its response, a message still open, or
+// characters not yet framed.
+func (d *modbusDecoder) pendingStart() int64 {
+	start := d.uartDecoder.pendingStart()
+	if d.pending != nil {
+		start = earliest(start, d.pending.Start)
+	}
+	for _, msg := range d.open {
+		start = earliest(start, msg.Start)
+	}
+	return start
+}
+
+// finish ends the open messages and the pending request once the capture
+// is over.
+func (d *modbusDecoder) finish() {
+	d.flushed = true
+	d.collect()
+	tx, rx := d.open[false], d.open[true]
+	if tx != nil && rx != nil && rx.Start < tx.Start {
+		tx, rx = rx, tx
+	}
+	for _, msg := range []*ModbusMessage{tx, rx} {
+		if msg != nil {
+			d.message(msg)
+		}
+	}
+	if d.pending != nil {
+		d.transactions = append(d.transactions, ModbusTransaction{Request: d.pending})
+		d.pending = nil
+	}
+}
+
+// message pairs a complete message with the pending request, or starts a
+// transaction of its own.
+func (d *modbusDecoder) message(msg *ModbusMessage) {
+	delete(d.open, msg.RX)
+	if !msg.short() && d.pending != nil && msg.answers(d.pending) {
+		d.transactions = append(d.transactions, ModbusTransaction{Request: d.pending, Response: msg})
+		d.pending = nil
+		return
+	}
+	if d.pending != nil {
+		d.transactions = append(d.transactions, ModbusTransaction{Request: d.pending})
+		d.pending = nil
+	}
+	switch {
+	case msg.short():
+		d.transactions = append(d.transactions, ModbusTransaction{Request: msg})
+	case !msg.isRequest():
+		d.transactions = append(d.transactions, ModbusTransaction{Response: msg})
+	case msg.slave() == 0 || !msg.crcOK():
+		// Broadcasts are not answered, and slaves ignore corrupt requests
+		d.transactions = append(d.transactions, ModbusTransaction{Request: msg})
+	default:
+		d.pending = msg
+	}
+}
+
+var modbusHeader = []string{"time", "slave", "function", "address", "count", "values", "result", "error"}
+
+// modbusRow formats a transaction as a CSV row. Register addresses and
+// counts are decimal, register values hex; the data of functions without
+// decoded fields is listed as bytes.
+func modbusRow(s *Session, t ModbusTransaction) []string {
+	first := t.first()
+	row := []string{fmt.Sprintf("%.9f", s.Seconds(first.Start)), "", "", "", "", "", "", ""}
+	req, resp := t.Request, t.Response
+	if first.short() {
+		row[7] = "short frame"
+		return row
+	}
+	var errs []string
+	for _, m := range []struct {
+		msg  *ModbusMessage
+		name string
+	}{{req, "request"}, {resp, "response"}} {
+		if m.msg == nil {
+			continue
+		}
+		if m.msg.ParityError {
+			errs = append(errs, m.name+" parity")
+		}
+		if m.msg.FramingError {
+			errs = append(errs, m.name+" framing")
+		}
+		if !m.msg.crcOK() {
+			errs = append(errs, m.name+" CRC")
+		}
+	}
+	if req == nil {
+		errs = append(errs, "no request")
+	}
+
+	fc := first.function() &^ modbusException
+	row[1] = fmt.Sprint(first.slave())
+	row[2] = modbusFunctionName(fc)
+	address, count, values := modbusFields(fc, req, resp)
+	row[3], row[4], row[5] = address, count, values
+
+	switch {
+	case resp != nil && resp.function()&modbusException != 0:
+		// An exception carries one code byte; noise or a response cut off
+		// by the start of the capture may have none
+		row[6] = "exception"
+		if pdu := resp.pdu(); len(pdu) != 1 {
+			errs = append(errs, "malformed exception")
+		} else {
+			row[6] = fmt.Sprintf("exception %d", pdu[0])
+			if name, ok := modbusExceptions[pdu[0]]; ok {
+				row[6] += ": " + name
+			}
+		}
+	case resp != nil:
+		row[6] = "OK"
+	case req.slave() == 0:
+		row[6] = "broadcast"
+	default:
+		row[6] = "no response"
+	}
+	row[7] = strings.Join(errs, ", ")
+	return row
+}
+
+func modbusFunctionName(fc byte) string {
+	if name, ok := modbusFunctions[fc]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02X", fc)
+}
+
+// modbusFields reads the register address, count and values of a
+// transaction from whichever of its messages carries them.
+func modbusFields(fc byte, req, resp *ModbusMessage) (address, count, values string) {
+	if resp != nil && resp.function()&modbusException != 0 {
+		resp = nil
+	}
+	switch fc {
+	case modbusReadHolding, modbusReadInput:
+		if req != nil && req.isRequest() {
+			address, count = registerField(req.pdu(), 0), registerField(req.pdu(), 2)
+		}
+		if resp != nil && len(resp.pdu()) > 0 {
+			values = registerValues(resp.pdu()[1:])
+		}
+		return address, count, values
+	case modbusWriteSingle:
+		msg := req
+		if msg == nil {
+			msg = resp
+		}
+		if msg != nil && len(msg.pdu()) == 4 {
+			return registerField(msg.pdu(), 0), "1", registerValues(msg.pdu()[2:])
+		}
+		return "", "", ""
+	case modbusWriteMultiple:
+		if req != nil && req.isRequest() {
+			return registerField(req.pdu(), 0), registerField(req.pdu(), 2), registerValues(req.pdu()[5:])
+		}
+		if resp != nil && len(resp.pdu()) == 4 {
+			return registerField(resp.pdu(), 0), registerField(resp.pdu(), 2), ""
+		}
+		return "", "", ""
+	}
+	// Other functions: the data of both messages as bytes
+	var data []string
+	for _, msg := range []*ModbusMessage{req, resp} {
+		if msg == nil || len(msg.pdu()) == 0 {
+			continue
+		}
+		data = append(data, fmt.Sprintf("% X", msg.pdu()))
+	}
+	return "", "", strings.Join(data, " / ")
+}
+
+// registerField reads the big-endian 16-bit field at offset i as decimal.
+func registerField(pdu []byte, i int) string {
+	if len(pdu) < i+2 {
+		return ""
+	}
+	return fmt.Sprint(int(pdu[i])<<8 | int(pdu[i+1]))
+}
+
+// registerValues lists big-endian 16-bit registers in hex.
+func registerValues(data []byte) string {
+	var values []string
+	for i := 0; i+1 < len(data); i += 2 {
+		values = append(values, fmt.Sprintf("0x%02X%02X", data[i], data[i+1]))
+	}
+	return strings.Join(values, " ")
+}
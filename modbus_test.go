@@ -0,0 +1,130 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// modbusBus sends 8E1 characters on TX=0 and RX=1 at 10 samples per bit,
+// back to back within a message and 5 characters apart between messages.
+type modbusBus struct {
+	*signal
+}
+
+func newModbusBus() *modbusBus {
+	b := &modbusBus{newSignal("TX", "RX")}
+	b.set(0, 1).set(1, 1).hold(100)
+	return b
+}
+
+// send transmits a message with its CRC; badCRC corrupts the CRC.
+func (b *modbusBus) send(ch int, badCRC bool, data ...byte) *modbusBus {
+	crc := modbusCRC(data)
+	if badCRC {
+		crc ^= 1
+	}
+	b.chars(ch, append(data, byte(crc), byte(crc>>8))...)
+	b.hold(550)
+	return b
+}
+
+// chars transmits characters back to back.
+func (b *modbusBus) chars(ch int, data ...byte) *modbusBus {
+	for _, v := range data {
+		parity := 0
+		for i := 0; i < 8; i++ {
+			parity ^= int(v >> i & 1)
+		}
+		for _, bit := range uartBits(int(v), 8, parity)[:11] {
+			b.set(ch, bit).hold(10)
+		}
+	}
+	return b
+}
+
+func TestModbusCRC(t *testing.T) {
+	if got := modbusCRC([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01}); got != 0x0A84 {
+		t.Errorf("CRC = 0x%04X, want 0x0A84", got)
+	}
+}
+
+func TestDecodeModbus(t *testing.T) {
+	bus := newModbusBus()
+	// A response whose request was before the capture
+	bus.send(0, false, 0x07, 0x03, 0x02, 0x12, 0x34)
+	bus.send(0, false, 0x01, 0x03, 0x00, 0x6B, 0x00, 0x03)
+	bus.send(1, false, 0x01, 0x03, 0x06, 0x02, 0x2B, 0x00, 0x00, 0x00, 0x64)
+	bus.send(0, false, 0x11, 0x06, 0x00, 0x01, 0x00, 0x03)
+	bus.send(0, false, 0x11, 0x06, 0x00, 0x01, 0x00, 0x03)
+	bus.send(0, false, 0x11, 0x10, 0x00, 0x01, 0x00, 0x02, 0x04, 0x00, 0x0A, 0x01, 0x02)
+	bus.send(0, false, 0x11, 0x10, 0x00, 0x01, 0x00, 0x02)
+	bus.send(0, false, 0x0A, 0x03, 0x01, 0x00, 0x00, 0x01)
+	bus.send(0, false, 0x0A, 0x83, 0x02)
+	bus.send(0, false, 0x00, 0x06, 0x00, 0x10, 0x12, 0x34)
+	bus.send(0, false, 0x05, 0x03, 0x00, 0x00, 0x00, 0x02)
+	bus.send(0, true, 0x01, 0x06, 0x00, 0x02, 0x00, 0x07)
+	bus.send(0, false, 0x01, 0x06, 0x00, 0x02, 0x00, 0x07)
+	bus.send(0, false, 0x01, 0x06, 0x00, 0x02, 0x00, 0x07)
+	bus.send(0, false, 0x01, 0x11)
+	bus.send(0, false, 0x01, 0x11, 0x02, 0x01, 0xFF)
+	bus.set(0, 0).hold(10).set(0, 1).hold(550)
+	// An exception function code without its code byte
+	bus.chars(1, 0x05, 0x9A, 0x11, 0x22).hold(550)
+
+	s := bus.session(96000)
+	got, err := decodeModbus(s, UARTConfig{TX: "D0", RX: "D1", Baud: 9600, DataBits: 8, Parity: ParityEven, StopBits: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"7", "Read Holding Registers", "", "", "0x1234", "OK", "no request"},
+		{"1", "Read Holding Registers", "107", "3", "0x022B 0x0000 0x0064", "OK", ""},
+		{"17", "Write Single Register", "1", "1", "0x0003", "OK", ""},
+		{"17", "Write Multiple Registers", "1", "2", "0x000A 0x0102", "OK", ""},
+		{"10", "Read Holding Registers", "256", "1", "", "exception 2: Illegal Data Address", ""},
+		{"0", "Write Single Register", "16", "1", "0x1234", "broadcast", ""},
+		{"5", "Read Holding Registers", "0", "2", "", "no response", ""},
+		{"1", "Write Single Register", "2", "1", "0x0007", "no response", "request CRC"},
+		{"1", "Write Single Register", "2", "1", "0x0007", "OK", ""},
+		{"1", "Report Server ID", "", "", "02 01 FF", "OK", ""},
+		{"", "", "", "", "", "", "short frame"},
+		{"5", "0x1A", "", "", "", "exception", "response CRC, no request, malformed exception"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d transactions, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if row := modbusRow(s, got[i]); !slices.Equal(row[1:], w) {
+			t.Errorf("transaction %d = %q\nwant %q", i, row[1:], w)
+		}
+	}
+}
+
+func TestModbusSilence(t *testing.T) {
+	// 3.5 characters of silence split a message, 3 do not
+	frame := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0A}
+	for _, tc := range []struct {
+		pause int
+		want  int
+	}{{330, 1}, {390, 2}} {
+		bus := newModbusBus()
+		bus.chars(0, frame[:3]...).hold(tc.pause)
+		bus.chars(0, frame[3:]...).hold(550)
+		got, err := decodeModbus(bus.session(96000), UARTConfig{TX: "D0", Baud: 9600, DataBits: 8, Parity: ParityEven, StopBits: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != tc.want {
+			t.Errorf("pause of %d samples: got %d transactions, want %d", tc.pause, len(got), tc.want)
+		}
+	}
+
+	// Above 19200 baud the silence is 1.75 ms whatever the baud rate
+	d, err := newModbusDecoder(newModbusBus().session(1000000), UARTConfig{TX: "D0", Baud: 115200, DataBits: 8, StopBits: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.gap != 1750 {
+		t.Errorf("gap = %d samples, want 1750", d.gap)
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OneWireConfig selects the data pin of a 1-Wire bus and the speed it
+// starts at.
+type OneWireConfig struct {
+	DQ        string
+	Overdrive bool // Start at overdrive speed rather than standard
+}
+
+// OneWireTransaction is everything from a reset pulse to the next one: the
+// ROM command that selects a device, then the function command and its
+// data.
+type OneWireTransaction struct {
+	Start, End int64
+	Reset      bool // Began with a reset; the first one of a capture may not
+	Presence   bool // A device answered the reset
+
+	ROMCommand int    // -1 if there was none
+	ROM        []byte // Family code, serial number and CRC, as sent
+	Family     int    // From the ROM, or the last one seen on the bus; -1 if unknown
+	Function   int    // -1 if there was none
+	Data       []byte
+
+	Overdrive bool // Ended at overdrive speed
+	Partial   int  // Bits of an incomplete final byte or ROM
+}
+
+// 1-Wire ROM commands.
+const (
+	oneWireReadROM          = 0x33
+	oneWireMatchROM         = 0x55
+	oneWireSkipROM          = 0xCC
+	oneWireSearchROM        = 0xF0
+	oneWireAlarmSearch      = 0xEC
+	oneWireOverdriveSkipROM = 0x3C
+	oneWireOverdriveMatch   = 0x69
+)
+
+var oneWireROMCommands = map[int]string{
+	oneWireReadROM:          "Read ROM",
+	oneWireMatchROM:         "Match ROM",
+	oneWireSkipROM:          "Skip ROM",
+	oneWireSearchROM:        "Search ROM",
+	oneWireAlarmSearch:      "Alarm Search",
+	oneWireOverdriveSkipROM: "Overdrive Skip ROM",
+	oneWireOverdriveMatch:   "Overdrive Match ROM",
+}
+
+// oneWireFamilies names common devices by the family code in their ROM.
+var oneWireFamilies = map[int]string{
+	0x01: "DS2401",
+	0x10: "DS18S20",
+	0x22: "DS1822",
+	0x23: "DS2433",
+	0x26: "DS2438",
+	0x28: "DS18B20",
+	0x2D: "DS2431",
+	0x3A: "DS2413",
+	0x3B: "MAX31850",
+	0x42: "DS28EA00",
+}
+
+// thermometerCommands are the function commands of the DS18x20
+// thermometers.
+var thermometerCommands = map[int]string{
+	0x44: "Convert T",
+	0x4E: "Write Scratchpad",
+	0xBE: "Read Scratchpad",
+	0x48: "Copy Scratchpad",
+	0xB8: "Recall E2",
+	0xB4: "Read Power Supply",
+}
+
+// thermometerFamilies maps the DS18x20 family codes to the temperature
+// step of their scratchpad, in °C.
+var thermometerFamilies = map[int]float64{0x10: 0.5, 0x22: 0.0625, 0x28: 0.0625, 0x42: 0.0625}
+
+// 1-Wire timing in microseconds, standard speed first, then overdrive.
+// Lows longer than a slot but shorter than a reset are taken as resets,
+// and a device answers a reset within the recovery time that follows it.
+var (
+	oneWireReset    = [2]float64{300, 32} // Slots last at most 120/16
+	oneWireRecovery = [2]float64{480, 48} // Presence pulses start within this
+	oneWireSampleAt = [2]float64{15, 2}   // A line still low here reads 0
+)
+
+// oneWireConfig validates the 1-Wire fields of the Configuration panel.
+func oneWireConfig(m model) (OneWireConfig, error) {
+	cfg := OneWireConfig{DQ: m.oneWireDQ}
+	speed, err := parseChoice("speed", m.oneWireSpeed, "standard", "overdrive")
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Overdrive = speed == 1
+	return cfg, nil
+}
+
+// oneWireDecoder times the low pulses on DQ: resets, presence pulses and
+// bit slots. The bits of each transaction are interpreted once it ends.
+type oneWireDecoder struct {
+	cfg         OneWireConfig
+	dq          int
+	usPerSample float64
+
+	started   bool
+	prev      uint64
+	overdrive bool
+	fall      int64 // Where DQ last went low
+	resetEnd  int64 // Where the last reset pulse ended, -1 before one
+
+	current *OneWireTransaction
+	bits    []byte
+	family  int // Family of the last ROM seen
+
+	transactions []OneWireTransaction
+}
+
+func newOneWireDecoder(s *Session, cfg OneWireConfig) (*oneWireDecoder, error) {
+	if s.SampleRate == 0 {
+		return nil, fmt.Errorf("capture has no sample rate")
+	}
+	d := &oneWireDecoder{cfg: cfg, overdrive: cfg.Overdrive, resetEnd: -1, family: -1}
+	d.usPerSample = 1e6 / float64(s.SampleRate)
+	// The sample point of a bit slot needs a few samples to be placed
+	if speed := d.speed(); oneWireSampleAt[speed] < 3*d.usPerSample {
+		return nil, fmt.Errorf("sample rate %d is too low for 1-Wire at %s speed", s.SampleRate, []string{"standard", "overdrive"}[speed])
+	}
+	var err error
+	if d.dq, err = resolveChannel(s, "DQ", cfg.DQ); err != nil {
+		return nil, err
+	}
+	if d.dq < 0 {
+		return nil, errMissingPin("DQ")
+	}
+	return d, nil
+}
+
+// decodeOneWire decodes every transaction in a session.
+func decodeOneWire(s *Session, cfg OneWireConfig) ([]OneWireTransaction, error) {
+	d, err := newOneWireDecoder(s, cfg)
+	if err != nil {
+		return nil, err
+	}
+	decodeSession(s, d)
+	d.finish()
+	return d.transactions, nil
+}
+
+// speed indexes the timing tables: 0 at standard speed, 1 at overdrive.
+func (d *oneWireDecoder) speed() int {
+	if d.overdrive {
+		return 1
+	}
+	return 0
+}
+
+func (d *oneWireDecoder) feed(n int64, sample uint64) {
+	v := sample >> d.dq & 1
+	if !d.started {
+		d.started = true
+		d.prev = v
+		d.fall = -1
+		return
+	}
+	prev := d.prev
+	d.prev = v
+	if v == prev {
+		return
+	}
+	if v == 0 {
+		d.fall = n
+		return
+	}
+	if d.fall < 0 {
+		return
+	}
+
+	// Rising edge: what the low pulse was depends on its length
+	low := float64(n-d.fall) * d.usPerSample
+	speed := d.speed()
+	switch {
+	case low >= oneWireReset[speed]:
+		d.finish()
+		// A standard-speed reset also returns every device to standard speed
+		d.overdrive = d.overdrive && low < oneWireReset[0]
+		d.current = &OneWireTransaction{Start: d.fall, Reset: true}
+		d.resetEnd = n
+	case d.resetEnd >= 0 && float64(d.fall-d.resetEnd)*d.usPerSample < oneWireRecovery[speed]:
+		d.current.Presence = true
+	default:
+		if d.current == nil {
+			d.current = &OneWireTransaction{Start: d.fall}
+		}
+		bit := byte(1)
+		if low >= oneWireSampleAt[speed] {
+			bit = 0
+		}
+		d.bits = append(d.bits, bit)
+		d.resetEnd = -1
+		// Devices switch to overdrive right after these ROM commands
+		if d.current.Reset && len(d.bits) == 8 {
+			if cmd := bitsToByte(d.bits); cmd == oneWireOverdriveSkipROM || cmd == oneWireOverdriveMatch {
+				d.overdrive = true
+			}
+		}
+	}
+	d.current.End = n
+}
+
+// pendingStart is the start of the open transaction, or of the low pulse
+// in progress that may begin one, or -1.
+func (d *oneWireDecoder) pendingStart() int64 {
+	start := int64(-1)
+	if d.current != nil {
+		start = d.current.Start
+	}
+	if d.started && d.prev == 0 && d.fall >= 0 {
+		start = earliest(start, d.fall)
+	}
+	return start
+}
+
+// finish closes the current transaction, if any.
+func (d *oneWireDecoder) finish() {
+	t := d.current
+	bits := d.bits
+	d.current = nil
+	d.bits = nil
+	d.resetEnd = -1
+	if t == nil {
+		return
+	}
+	t.Overdrive = d.overdrive
+	t.parse(bits)
+	if len(t.ROM) == 8 {
+		d.family = int(t.ROM[0])
+	}
+	t.Family = d.family
+	d.transactions = append(d.transactions, *t)
+}
+
+// parse interprets the bits of a transaction: after a reset the ROM command
+// and the ROM it carries, then the function command and its data.
+func (t *OneWireTransaction) parse(bits []byte) {
+	t.ROMCommand, t.Function = -1, -1
+	next := func() (int, bool) {
+		if len(bits) < 8 {
+			return 0, false
+		}
+		v := bitsToByte(bits)
+		bits = bits[8:]
+		return int(v), true
+	}
+	defer func() { t.Partial = len(bits) }()
+
+	if t.Reset {
+		cmd, ok := next()
+		if !ok {
+			return
+		}
+		t.ROMCommand = cmd
+		switch cmd {
+		case oneWireReadROM, oneWireMatchROM, oneWireOverdriveMatch:
+			for len(t.ROM) < 8 {
+				b, ok := next()
+				if !ok {
+					return
+				}
+				t.ROM = append(t.ROM, byte(b))
+			}
+		case oneWireSearchROM, oneWireAlarmSearch:
+			// Each ROM bit is read, read inverted, then written by the
+			// master to pick the branch of devices that stays selected
+			if len(bits) < 3*64 {
+				return
+			}
+			rom := make([]byte, 64)
+			for i := range rom {
+				rom[i] = bits[3*i+2]
+			}
+			for i := 0; i < 8; i++ {
+				t.ROM = append(t.ROM, bitsToByte(rom[8*i:]))
+			}
+			bits = bits[3*64:]
+		case oneWireSkipROM, oneWireOverdriveSkipROM:
+		default:
+			// Not a ROM command: the function command follows the reset
+			t.ROMCommand = -1
+			t.Function = cmd
+		}
+	}
+	if t.Function < 0 {
+		cmd, ok := next()
+		if !ok {
+			return
+		}
+		t.Function = cmd
+	}
+	for {
+		b, ok := next()
+		if !ok {
+			return
+		}
+		t.Data = append(t.Data, byte(b))
+	}
+}
+
+// bytes lists the bytes of a transaction in the order they were
+// sent, for the software trigger.
+func (t OneWireTransaction) bytes() []byte {
+	var b []byte
+	if t.ROMCommand >= 0 {
+		b = append(b, byte(t.ROMCommand))
+	}
+	b = append(b, t.ROM...)
+	if t.Function >= 0 {
+		b = append(b, byte(t.Function))
+	}
+	return append(b, t.Data...)
+}
+
+// bitsToByte packs the first eight bits, which are sent LSB first.
+func bitsToByte(bits []byte) byte {
+	var v byte
+	for i := 0; i < 8; i++ {
+		v |= bits[i] << i
+	}
+	return v
+}
+
+// crc8 is the Dallas/Maxim CRC of the ROM and scratchpads. Over data that
+// ends in its CRC the result is zero.
+func crc8(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		for i := 0; i < 8; i++ {
+			mix := (crc ^ b) & 1
+			crc >>= 1
+			if mix != 0 {
+				crc ^= 0x8C
+			}
+			b >>= 1
+		}
+	}
+	return crc
+}
+
+var oneWireHeader = []string{"time", "presence", "rom_cmd", "rom", "family", "rom_crc", "function", "data", "info"}
+
+// oneWireRow formats a transaction as a CSV row.
+func oneWireRow(s *Session, t OneWireTransaction) []string {
+	presence := ""
+	if t.Reset {
+		presence = map[bool]string{true: "yes", false: "no"}[t.Presence]
+	}
+	romCmd := ""
+	if t.ROMCommand >= 0 {
+		romCmd = oneWireROMCommands[t.ROMCommand]
+	}
+	rom := fmt.Sprintf("%X", t.ROM)
+	romCRC := ""
+	if len(t.ROM) == 8 {
+		romCRC = map[bool]string{true: "OK", false: "BAD"}[crc8(t.ROM) == 0]
+	}
+	family := ""
+	if t.Family >= 0 {
+		family = oneWireFamilies[t.Family]
+		if family == "" {
+			family = fmt.Sprintf("0x%02X", t.Family)
+		}
+	}
+
+	function := ""
+	_, thermometer := thermometerFamilies[t.Family]
+	if t.Function >= 0 {
+		function = fmt.Sprintf("0x%02X", t.Function)
+		if name, ok := thermometerCommands[t.Function]; ok && thermometer {
+			function = name
+		}
+	}
+	var data []string
+	for _, b := range t.Data {
+		data = append(data, fmt.Sprintf("%02X", b))
+	}
+
+	var info []string
+	if thermometer && t.Function == 0xBE && len(t.Data) >= 9 {
+		raw := int16(uint16(t.Data[1])<<8 | uint16(t.Data[0]))
+		info = append(info, fmt.Sprintf("%.4f °C", float64(raw)*thermometerFamilies[t.Family]))
+		info = append(info, "scratchpad CRC "+map[bool]string{true: "OK", false: "BAD"}[crc8(t.Data[:9]) == 0])
+	}
+	if t.Overdrive {
+		info = append(info, "overdrive")
+	}
+	if t.Partial > 0 {
+		info = append(info, fmt.Sprintf("%d stray bits", t.Partial))
+	}
+
+	return []string{
+		fmt.Sprintf("%.9f", s.Seconds(t.Start)),
+		presence,
+		romCmd,
+		rom,
+		family,
+		romCRC,
+		function,
+		strings.Join(data, " "),
+		strings.Join(info, "; "),
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// oneWireBus drives DQ=0 of a synthetic capture at 1 MHz, so one sample is
+// one microsecond.
+type oneWireBus struct {
+	*signal
+	overdrive bool
+}
+
+func newOneWireBus() *oneWireBus {
+	b := &oneWireBus{signal: newSignal("DQ")}
+	b.set(0, 1).hold(100)
+	return b
+}
+
+// reset sends a reset pulse, answered by a presence pulse if present.
+func (b *oneWireBus) reset(present bool) *oneWireBus {
+	if b.overdrive {
+		b.set(0, 0).hold(70).set(0, 1).hold(3)
+		if present {
+			b.set(0, 0).hold(10)
+		}
+		b.set(0, 1).hold(60)
+		return b
+	}
+	b.set(0, 0).hold(480).set(0, 1).hold(30)
+	if present {
+		b.set(0, 0).hold(120)
+	}
+	b.set(0, 1).hold(500)
+	return b
+}
+
+// bytes sends bytes LSB first; reads look the same on the wire.
+func (b *oneWireBus) bytes(data ...byte) *oneWireBus {
+	for _, v := range data {
+		for i := 0; i < 8; i++ {
+			b.bit(int(v >> i & 1))
+		}
+	}
+	return b
+}
+
+func (b *oneWireBus) bit(v int) *oneWireBus {
+	low, slot := 6, 70
+	if v == 0 {
+		low = 60
+	}
+	if b.overdrive {
+		low, slot = 1, 10
+		if v == 0 {
+			low = 8
+		}
+	}
+	b.set(0, 0).hold(low).set(0, 1).hold(slot - low)
+	return b
+}
+
+// withCRC appends the Dallas/Maxim CRC.
+func withCRC(data ...byte) []byte {
+	return append(data, crc8(data))
+}
+
+func TestDecodeOneWire(t *testing.T) {
+	rom := withCRC(0x28, 0xFF, 0x4C, 0x60, 0x91, 0x16, 0x04)
+	// 25.0625 °C at 12-bit resolution
+	scratchpad := withCRC(0x91, 0x01, 0x4B, 0x46, 0x7F, 0xFF, 0x0F, 0x10)
+
+	bus := newOneWireBus()
+	bus.reset(true).bytes(oneWireReadROM).bytes(rom...)
+	bus.reset(true).bytes(oneWireSkipROM, 0x44)
+	bus.reset(true).bytes(oneWireMatchROM).bytes(rom...).bytes(0xBE).bytes(scratchpad...)
+	bus.reset(false)
+	// Search ROM: each bit, its complement, then the branch taken
+	bus.reset(true).bytes(oneWireSearchROM)
+	for _, v := range rom {
+		for i := 0; i < 8; i++ {
+			bit := int(v >> i & 1)
+			bus.bit(bit).bit(1 - bit).bit(bit)
+		}
+	}
+	bus.reset(true).bytes(oneWireOverdriveSkipROM)
+	bus.overdrive = true
+	bus.bytes(0x44).reset(true).bytes(oneWireSkipROM, 0xB4).bit(1)
+
+	s := bus.session(1000000)
+	got, err := decodeOneWire(s, OneWireConfig{DQ: "D0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 7 {
+		t.Fatalf("got %d transactions, want 7: %+v", len(got), got)
+	}
+
+	if tr := got[0]; !tr.Reset || !tr.Presence || tr.ROMCommand != oneWireReadROM || !slices.Equal(tr.ROM, rom) || tr.Function != -1 {
+		t.Errorf("Read ROM = %+v", tr)
+	}
+	// Skip ROM names the function of the device last seen
+	if row := oneWireRow(s, got[1]); row[2] != "Skip ROM" || row[4] != "DS18B20" || row[6] != "Convert T" {
+		t.Errorf("Skip ROM row = %q", row)
+	}
+	want := []string{"yes", "Match ROM", "28FF4C60911604" + formatHex(uint32(rom[7]), 8), "DS18B20", "OK", "Read Scratchpad",
+		"91 01 4B 46 7F FF 0F 10 " + formatHex(uint32(scratchpad[8]), 8), "25.0625 °C; scratchpad CRC OK"}
+	if row := oneWireRow(s, got[2]); !slices.Equal(row[1:], want) {
+		t.Errorf("Match ROM row = %q\nwant %q", row, want)
+	}
+	if tr := got[3]; tr.Presence || tr.ROMCommand != -1 {
+		t.Errorf("reset without presence = %+v", tr)
+	}
+	if tr := got[4]; tr.ROMCommand != oneWireSearchROM || !slices.Equal(tr.ROM, rom) || tr.Partial != 0 {
+		t.Errorf("Search ROM = %+v", tr)
+	}
+	if tr := got[5]; tr.Function != 0x44 || !tr.Overdrive {
+		t.Errorf("Overdrive Skip ROM = %+v", tr)
+	}
+	if row := oneWireRow(s, got[6]); row[6] != "Read Power Supply" || row[8] != "overdrive; 1 stray bits" {
+		t.Errorf("overdrive row = %q", row)
+	}
+
+	// A corrupted ROM fails its CRC
+	got[0].ROM[3] ^= 0x10
+	if row := oneWireRow(s, got[0]); row[5] != "BAD" {
+		t.Errorf("rom_crc = %q, want BAD", row[5])
+	}
+}
+
+func TestOneWireConfig(t *testing.T) {
+	m := initialModel(newDemoBackend())
+	m.oneWireSpeed = "fast"
+	if _, err := oneWireConfig(m); err == nil {
+		t.Error("speed fast accepted")
+	}
+	m.oneWireSpeed = "overdrive"
+	cfg, err := oneWireConfig(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Overdrive slots are sampled 2 µs in, too fast for 1 MHz
+	if _, err := newOneWireDecoder(newSignal("DQ").session(1000000), cfg); err == nil {
+		t.Error("1 MHz accepted for overdrive")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -26,6 +27,30 @@ func formatSampleRate(rate string) string {
 	return fmt.Sprintf("%d Hz", rateInt)
 }
 
+// triggerLabel shows the trigger of the selected protocol: a preset by name
+// with its spec, or a custom spec.
+func (m model) triggerLabel() string {
+	value := *m.triggerField()
+	if spec := m.triggerSpec(); spec != "" && spec != value {
+		return fmt.Sprintf("%s (%s)", value, spec)
+	}
+	return value
+}
+
+// i2cAddressFilters lists the addresses the I2C buses are limited to.
+func (m model) i2cAddressFilters() []string {
+	var addrs []string
+	for _, bm := range m.busModels() {
+		if bm.protocol != ProtocolI2C {
+			continue
+		}
+		if cfg, err := i2cConfig(bm); err == nil && cfg.Address >= 0 {
+			addrs = append(addrs, fmt.Sprintf("0x%02X", cfg.Address))
+		}
+	}
+	return addrs
+}
+
 func (m model) renderDevicesPanel(width, height int) string {
 	isActive := m.activePanel == panelDevices
 	style := inactivePanelStyle
@@ -64,85 +89,44 @@ func (m model) renderConfigPanel(width, height int) string {
 	}
 
 	var content strings.Builder
-	content.WriteString(panelTitleStyle.Render("Configuration") + "\n\n")
+	title := "Configuration"
+	if m.multiBus() {
+		title += fmt.Sprintf(" (bus %d/%d)", m.activeBus+1, len(m.buses))
+	}
+	content.WriteString(panelTitleStyle.Render(title) + "\n\n")
 
 	// Protocol selection
-	protocolText := "Protocol: "
-	switch m.protocol {
-	case ProtocolSPI:
-		protocolText += "SPI"
-	case ProtocolI2C:
-		protocolText += "I2C"
-	case ProtocolUART:
-		protocolText += "UART"
-	}
+	protocolText := "Protocol: " + m.protocol.String()
 	if isActive && m.cursor == 0 {
 		content.WriteString("> " + selectedStyle.Render(protocolText) + "\n\n")
 	} else {
 		content.WriteString("  " + protocolText + "\n\n")
 	}
 
-	// Pin configuration
-	if m.protocol == ProtocolSPI {
-		fields := []struct{ label, value string }{
-			{"CLK", m.spiCLK},
-			{"MOSI", m.spiMOSI},
-			{"MISO", m.spiMISO},
-			{"CS", m.spiCS},
-			{"CPOL", m.spiCPOL},
-			{"CPHA", m.spiCPHA},
-		}
-
-		for i, field := range fields {
-			cursor := " "
-			value := field.value
-			if isActive && m.cursor == i+1 {
-				cursor = ">"
-				if m.editing {
-					value = m.editBuffer + "█"
-				}
-				value = selectedStyle.Render(value)
-			}
-			content.WriteString(fmt.Sprintf("%s %-4s: %s\n", cursor, field.label, value))
-		}
-	} else if m.protocol == ProtocolI2C {
-		fields := []struct{ label, value string }{
-			{"SDA", m.i2cSDA},
-			{"SCL", m.i2cSCL},
-			{"Addr", m.i2cAddress},
-		}
-
-		for i, field := range fields {
-			cursor := " "
-			value := field.value
-			if isActive && m.cursor == i+1 {
-				cursor = ">"
-				if m.editing {
-					value = m.editBuffer + "█"
-				}
-				value = selectedStyle.Render(value)
-			}
-			content.WriteString(fmt.Sprintf("%s %-4s: %s\n", cursor, field.label, value))
-		}
-	} else if m.protocol == ProtocolUART {
-		fields := []struct{ label, value string }{
-			{"TX", m.uartTX},
-			{"RX", m.uartRX},
-			{"Baud", m.uartBaud},
-		}
+	// Pin configuration, scrolled so the cursor stays visible
+	fields := m.configFields()
+	labelWidth := 4
+	for _, field := range fields {
+		labelWidth = max(labelWidth, len(field.label))
+	}
+	visible := height - 6 // Padding, title and protocol rows
+	first := 0
+	if len(fields) > visible {
+		first = min(max(m.cursor-visible, 0), len(fields)-visible)
+	}
 
-		for i, field := range fields {
-			cursor := " "
-			value := field.value
-			if isActive && m.cursor == i+1 {
-				cursor = ">"
-				if m.editing {
-					value = m.editBuffer + "█"
-				}
-				value = selectedStyle.Render(value)
+	for i := first; i < len(fields) && i < first+visible; i++ {
+		field := fields[i]
+		cursor := " "
+		value := *field.value
+		if isActive && m.cursor == i+1 {
+			cursor = ">"
+			if m.editing {
+				value = m.editBuffer + "█"
 			}
-			content.WriteString(fmt.Sprintf("%s %-4s: %s\n", cursor, field.label, value))
+			value = selectedStyle.Render(value)
 		}
+		content.WriteString(fmt.Sprintf("%s %-*s: %s\n", cursor, labelWidth, field.label, value))
 	}
 
 	return style.Width(width).Height(height).Render(content.String())
@@ -161,12 +145,27 @@ func (m model) renderCapturePanel(width, height int) string {
 	// Format sample rate nicely
 	sampleRateDisplay := formatSampleRate(m.sampleRate)
 
+	// In continuous mode the duration is a limit rather than a window
+	durationLabel := "Duration"
+	if m.continuous {
+		durationLabel = "Limit"
+	}
 	fields := []struct{ label, value string }{
 		{"Rate", sampleRateDisplay},
-		{"Duration", m.duration},
+		{durationLabel, m.duration},
 		{"Output", m.outputFile},
+		{"Trigger", m.triggerLabel()},
+		{"Pre-trig", m.preTrigger + "%"},
+		{"Match", m.softTrigger},
+		{"Before", m.softBefore},
+		{"After", m.softAfter},
+		{"Analog", m.analogChannels},
+		{"Thresh", m.analogThreshold + " V"},
 	}
 
+	// An open dropdown lists its options below its field
+	var options []string
+	at, selected := -1, 0
 	for i, field := range fields {
 		cursor := " "
 		value := field.value
@@ -174,62 +173,84 @@ func (m model) renderCapturePanel(width, height int) string {
 			cursor = ">"
 			if i == 0 && m.selectingSampleRate {
 				value = selectedStyle.Render(value + " ▼")
+				for _, opt := range m.sampleRateOptions {
+					options = append(options, formatSampleRate(opt))
+				}
+				selected = m.sampleRateCursor
 			} else if i == 1 && m.selectingDuration {
 				value = selectedStyle.Render(value + " ▼")
+				options, selected = m.durationOptions, m.durationCursor
+			} else if i == 3 && m.selectingTrigger {
+				value = selectedStyle.Render(value + " ▼")
+				options, selected = m.triggerOptions(), m.triggerCursor
 			} else if m.editing {
 				value = m.editBuffer + "█"
 			} else {
 				value = selectedStyle.Render(value)
 			}
-		}
-		content.WriteString(fmt.Sprintf("%s %-8s: %s\n", cursor, field.label, value))
-
-		// Show sample rate dropdown
-		if i == 0 && isActive && m.cursor == 0 && m.selectingSampleRate {
-			for j, opt := range m.sampleRateOptions {
-				dropdownCursor := "  "
-				optText := formatSampleRate(opt)
-				if j == m.sampleRateCursor {
-					dropdownCursor = "  ▸"
-					optText = selectedStyle.Render(optText)
-				}
-				content.WriteString(fmt.Sprintf("%s %s\n", dropdownCursor, optText))
+			if options != nil {
+				at = i
 			}
 		}
+		content.WriteString(fmt.Sprintf("%s %-8s: %s\n", cursor, field.label, value))
+	}
 
-		// Show duration dropdown
-		if i == 1 && isActive && m.cursor == 1 && m.selectingDuration {
-			for j, opt := range m.durationOptions {
-				dropdownCursor := "  "
-				optText := opt
-				if j == m.durationCursor {
-					dropdownCursor = "  ▸"
-					optText = selectedStyle.Render(opt)
-				}
-				content.WriteString(fmt.Sprintf("%s %s\n", dropdownCursor, optText))
-			}
-		}
+	// Mode toggle
+	cursor := " "
+	modeText := fmt.Sprintf("Mode: %s", map[bool]string{true: "Continuous", false: "Single"}[m.continuous])
+	if isActive && m.cursor == 10 {
+		cursor = ">"
+		modeText = selectedStyle.Render(modeText)
 	}
+	content.WriteString(fmt.Sprintf("\n%s %s\n", cursor, modeText))
 
 	// Filter toggle
-	cursor := " "
+	cursor = " "
 	filterText := fmt.Sprintf("Filter: %s", map[bool]string{true: "ON", false: "OFF"}[m.filterFrames])
-	if isActive && m.cursor == 3 {
+	if isActive && m.cursor == 11 {
 		cursor = ">"
 		filterText = selectedStyle.Render(filterText)
 	}
-	content.WriteString(fmt.Sprintf("\n%s %s\n", cursor, filterText))
+	// I2C address filters drop transactions too, so they are shown here
+	if addrs := m.i2cAddressFilters(); len(addrs) > 0 {
+		filterText += " " + dimTextStyle.Render("(I2C "+strings.Join(addrs, ", ")+" only)")
+	}
+	content.WriteString(fmt.Sprintf("%s %s\n", cursor, filterText))
 
 	// Start button
 	cursor = " "
 	startText := "[Start Capture]"
-	if isActive && m.cursor == 4 {
+	if isActive && m.cursor == captureStartSlot {
 		cursor = ">"
 		startText = selectedStyle.Render(startText)
 	}
-	content.WriteString(fmt.Sprintf("%s %s\n", cursor, startText))
+	content.WriteString(fmt.Sprintf("%s %s", cursor, startText))
 
-	return style.Width(width).Height(height).Render(content.String())
+	if at < 0 {
+		return style.Width(width).Height(height).Render(content.String())
+	}
+
+	// The dropdown covers the rows below its field, scrolled so its cursor
+	// stays inside the panel
+	lines := strings.Split(content.String(), "\n")
+	head := lines[:at+3] // Title, blank line and the fields up to the open one
+	room := max(height-2-len(head), 1)
+	first := 0
+	if len(options) > room {
+		first = min(max(selected-room+1, 0), len(options)-room)
+	}
+	var rows []string
+	for j := first; j < len(options) && j < first+room; j++ {
+		if j == selected {
+			rows = append(rows, "  ▸ "+selectedStyle.Render(options[j]))
+		} else {
+			rows = append(rows, "   "+options[j])
+		}
+	}
+	tail := lines[at+3:]
+	tail = tail[:min(len(tail), max(height-2-len(head)-len(rows), 0))]
+	body := slices.Concat(head, rows, tail)
+	return style.Width(width).Height(height).Render(strings.Join(body, "\n"))
 }
 
 func (m model) renderOutputPanel(width, height int) string {
@@ -240,9 +261,32 @@ func (m model) renderOutputPanel(width, height int) string {
 	}
 
 	var content strings.Builder
+	if m.selectingProfile {
+		content.WriteString(panelTitleStyle.Render("Profiles") + "\n\n")
+		m.renderProfiles(&content)
+		return style.Width(width).Height(height).Render(content.String())
+	}
 	content.WriteString(panelTitleStyle.Render("Output") + "\n\n")
 
-	if m.capturing {
+	if m.stream != nil {
+		// Show the most recent decoded rows as they arrive
+		frames := []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+		spinner := frames[m.captureSpinner%len(frames)]
+		records := max(len(m.outputData)-1, 0)
+		content.WriteString(fmt.Sprintf("%s Live: %d records shown (x: stop)\n\n", spinner, records))
+
+		maxLines := height - 6
+		if len(m.outputData) > 0 {
+			content.WriteString(m.outputData[0] + "\n")
+			first := max(1, len(m.outputData)-maxLines+1)
+			for _, line := range m.outputData[first:] {
+				if len(line) > width-6 {
+					line = line[:width-9] + "..."
+				}
+				content.WriteString(line + "\n")
+			}
+		}
+	} else if m.capturing {
 		// Show spinner
 		frames := []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
 		spinner := frames[m.captureSpinner%len(frames)]
@@ -271,6 +315,12 @@ func (m model) renderOutputPanel(width, height int) string {
 			}
 		}
 		content.WriteString("[" + bar + "]")
+	} else if m.showTrace && m.session != nil {
+		// Show waveforms of the last capture
+		content.WriteString(dimTextStyle.Render(m.sessionFile+": "+describeSession(m.session)) + "\n\n")
+		for _, line := range m.trace {
+			content.WriteString(line + "\n")
+		}
 	} else if len(m.outputData) > 0 {
 		// Show captured data
 		maxLines := height - 4
@@ -302,6 +352,8 @@ func (m model) renderStatusPanel(width, height int) string {
 	statusStyle := normalTextStyle
 	if strings.Contains(m.statusMsg, "Error") || strings.Contains(m.statusMsg, "failed") {
 		statusStyle = errorStyle
+	} else if strings.Contains(strings.ToLower(m.statusMsg), "cancel") || strings.HasPrefix(m.statusMsg, "Warning") {
+		statusStyle = warningStyle
 	} else if strings.Contains(m.statusMsg, "complete") || strings.Contains(m.statusMsg, "Ready") {
 		statusStyle = successStyle
 	}
@@ -312,13 +364,27 @@ func (m model) renderStatusPanel(width, height int) string {
 }
 
 func (m model) renderStatusBar() string {
-	helpText := "s: start • f: filter • d: duration • tab: next panel • 1-5: jump • ↑↓/jk: navigate • q: quit"
-	if m.editing {
+	helpText := "s: start • f: filter • d: duration • w: waveform • r: rescan • p: profiles • tab: next panel • 1-5: jump • ↑↓/jk: navigate • q: quit"
+	if m.activePanel == panelConfiguration {
+		buses := "a: add bus • "
+		if m.multiBus() {
+			buses += "[/]: switch bus • -: remove bus • "
+		}
+		helpText = buses + helpText
+	}
+	if m.activePanel == panelConfiguration && m.protocol.overUART() {
+		helpText = "b: auto-baud • " + helpText
+	}
+	if m.editing || m.namingProfile {
 		helpText = "enter: save • esc: cancel"
-	} else if m.selectingDuration || m.selectingSampleRate {
+	} else if m.selectingProfile {
+		helpText = "enter: load • n: save as new • s: overwrite • r: rename • d: delete • esc: close"
+	} else if m.selectingDuration || m.selectingSampleRate || m.selectingTrigger {
 		helpText = "↑↓/jk: select • enter: confirm • esc: cancel"
+	} else if m.stream != nil {
+		helpText = "Capturing continuously • x: stop"
 	} else if m.capturing {
-		helpText = "Capturing... please wait"
+		helpText = "Capturing... please wait • x: cancel"
 	}
 
 	return statusBarStyle.Width(m.width).Render(helpText)
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// profileNames lists the saved profiles and those of the project in the
+// order they are shown.
+func (m model) profileNames() []string {
+	names := make([]string, 0, len(m.config.Profiles))
+	for name := range m.config.Profiles {
+		names = append(names, name)
+	}
+	if m.project != nil {
+		for name := range m.project.Profiles {
+			if _, saved := m.config.Profiles[name]; !saved {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupProfile returns a profile by name. A saved profile hides a project
+// profile of the same name.
+func (m model) lookupProfile(name string) (s Settings, fromProject bool) {
+	if s, ok := m.config.Profiles[name]; ok {
+		return s, false
+	}
+	if m.project != nil {
+		return m.project.Profiles[name], true
+	}
+	return nil, false
+}
+
+// loadSettings restores the config at m.configPath. Problems are reported
+// in the status bar rather than stopping the launch.
+func (m *model) loadSettings() {
+	c, err := loadConfig(m.configPath)
+	if err != nil {
+		// Saving over a config that couldn't be read would lose its profiles
+		m.statusMsg = "Warning: " + err.Error()
+		m.configPath = ""
+		return
+	}
+	m.config = c
+	if warning := m.applySettings(c.Settings); warning != "" {
+		m.statusMsg = "Warning: " + warning
+	}
+}
+
+// saveSettings stores the current settings along with the profiles. It does
+// nothing when the model has no config file, as in tests.
+func (m *model) saveSettings() error {
+	if m.configPath == "" {
+		return nil
+	}
+	m.config.Settings = m.settings()
+	return saveConfig(m.configPath, m.config)
+}
+
+// updateProfiles handles keys while the profile list is open: loading,
+// saving, renaming and deleting profiles.
+func (m model) updateProfiles(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.namingProfile {
+		switch msg.String() {
+		case "enter":
+			m.nameProfile(strings.TrimSpace(m.editBuffer))
+		case "esc":
+			m.namingProfile = false
+			m.editBuffer = ""
+		case "backspace":
+			if len(m.editBuffer) > 0 {
+				m.editBuffer = m.editBuffer[:len(m.editBuffer)-1]
+			}
+		default:
+			if len(msg.String()) == 1 {
+				m.editBuffer += msg.String()
+			}
+		}
+		return m, nil
+	}
+
+	names := m.profileNames()
+	selected := ""
+	if m.profileCursor < len(names) {
+		selected = names[m.profileCursor]
+	}
+	// Project profiles belong to the repository and are only loaded here
+	if _, fromProject := m.lookupProfile(selected); fromProject {
+		switch msg.String() {
+		case "r", "d", "delete":
+			m.statusMsg = fmt.Sprintf("Error: %s is defined in %s", selected, m.project.Path)
+			return m, nil
+		}
+	}
+	switch msg.String() {
+	case "up", "k":
+		if m.profileCursor > 0 {
+			m.profileCursor--
+		}
+	case "down", "j":
+		if m.profileCursor < len(names)-1 {
+			m.profileCursor++
+		}
+	case "enter":
+		if selected != "" {
+			m.profile = selected
+			m.statusMsg = "Profile loaded: " + selected
+			settings, _ := m.lookupProfile(selected)
+			if warning := m.applySettings(settings); warning != "" {
+				m.statusMsg = "Warning: " + selected + ": " + warning
+			}
+			m.selectingProfile = false
+		}
+	case "n":
+		// Save the current settings under a new name
+		m.namingProfile = true
+		m.renamingProfile = ""
+		m.editBuffer = ""
+	case "s":
+		if selected != "" {
+			m.config.Profiles[selected] = m.settings()
+			m.profile = selected
+			m.statusMsg = "Profile saved: " + selected
+			m.storeProfiles()
+		}
+	case "r":
+		if selected != "" {
+			m.namingProfile = true
+			m.renamingProfile = selected
+			m.editBuffer = selected
+		}
+	case "d", "delete":
+		if selected != "" {
+			delete(m.config.Profiles, selected)
+			if m.profile == selected {
+				m.profile = ""
+			}
+			m.profileCursor = max(0, min(m.profileCursor, len(names)-2))
+			m.statusMsg = "Profile deleted: " + selected
+			m.storeProfiles()
+		}
+	case "esc", "p":
+		m.selectingProfile = false
+	}
+	return m, nil
+}
+
+// nameProfile finishes typing a profile name: it saves the current settings
+// as a new profile, or renames m.renamingProfile.
+func (m *model) nameProfile(name string) {
+	if name == "" {
+		m.statusMsg = "Error: profile name is empty"
+		return
+	}
+	if _, exists := m.config.Profiles[name]; exists && name != m.renamingProfile {
+		m.statusMsg = fmt.Sprintf("Error: profile %s already exists", name)
+		return
+	}
+
+	if old := m.renamingProfile; old != "" {
+		m.config.Profiles[name] = m.config.Profiles[old]
+		if name != old {
+			delete(m.config.Profiles, old)
+		}
+		if m.profile == old {
+			m.profile = name
+		}
+		m.statusMsg = fmt.Sprintf("Profile renamed: %s → %s", old, name)
+	} else {
+		m.config.Profiles[name] = m.settings()
+		m.profile = name
+		m.statusMsg = "Profile saved: " + name
+	}
+	m.namingProfile = false
+	m.renamingProfile = ""
+	m.editBuffer = ""
+	for i, n := range m.profileNames() {
+		if n == name {
+			m.profileCursor = i
+		}
+	}
+	m.storeProfiles()
+}
+
+// storeProfiles writes the profiles as soon as they change, so they survive
+// a crash.
+func (m *model) storeProfiles() {
+	if err := m.saveSettings(); err != nil {
+		m.statusMsg = "Error: " + err.Error()
+	}
+}
+
+// renderProfiles lists the profiles in the Output panel.
+func (m model) renderProfiles(content *strings.Builder) {
+	names := m.profileNames()
+	if len(names) == 0 && !m.namingProfile {
+		content.WriteString(dimTextStyle.Render("No profiles yet; n saves the current settings as one") + "\n")
+	}
+	for i, name := range names {
+		cursor := "  "
+		text := name
+		if name == m.profile {
+			text += " •"
+		}
+		p, fromProject := m.lookupProfile(name)
+		if fromProject {
+			text += " (project)"
+		}
+		if m.namingProfile && name == m.renamingProfile {
+			text = m.editBuffer + "█"
+		}
+		if i == m.profileCursor {
+			cursor = "▸ "
+			text = selectedStyle.Render(text)
+		}
+		content.WriteString(fmt.Sprintf("%s%s  %s\n", cursor, text,
+			dimTextStyle.Render(fmt.Sprintf("%s, %s, %s", p["protocol"], formatSampleRate(p["rate"]), p["duration"]))))
+	}
+	if m.namingProfile && m.renamingProfile == "" {
+		content.WriteString("\nName: " + selectedStyle.Render(m.editBuffer+"█") + "\n")
+	}
+}
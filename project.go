@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// projectFile is the name of the per-repository settings file.
+const projectFile = ".lazysig.yaml"
+
+// Project is a .lazysig.yaml: the wiring of the board a repository targets.
+// Its settings seed the model on launch, ahead of the last session's.
+type Project struct {
+	Path     string
+	Settings Settings            // Keys as in config.toml
+	Output   string              // Output file pattern, see outputName
+	Profiles map[string]Settings // Offered in the profile list, read-only
+	// I2C register names by device address, then register address
+	Registers map[int]map[int]string
+}
+
+// findProject looks for a project file in dir and its parents. It returns
+// "" when there is none.
+func findProject(dir string) string {
+	for {
+		path := filepath.Join(dir, projectFile)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// loadProject reads a project file. Besides the settings it has these
+// sections:
+//
+//	output:
+//	  dir: captures
+//	  name: "{protocol}-{date}-{time}.csv"
+//	profiles:
+//	  imu:
+//	    protocol: i2c
+//	    addr: "0x68"
+//	registers:
+//	  "0x68":
+//	    0x75: WHO_AM_I
+//	buses:
+//	  flash:
+//	    protocol: spi
+//	    cs: D3
+//	  imu:
+//	    protocol: i2c
+func loadProject(path string) (*Project, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseYAML(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	p := &Project{Path: path, Settings: Settings{}, Profiles: map[string]Settings{}, Registers: map[int]map[int]string{}}
+	for key, value := range doc {
+		switch v := value.(type) {
+		case string:
+			p.Settings[key] = v
+		case map[string]any:
+			if err := p.section(key, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+	}
+
+	// A relative output directory is kept next to the project file
+	if p.Output != "" && !filepath.IsAbs(p.Output) {
+		p.Output = filepath.Join(filepath.Dir(path), p.Output)
+	}
+	return p, nil
+}
+
+// section reads one of the nested sections of a project file.
+func (p *Project) section(key string, m map[string]any) error {
+	switch key {
+	case "output":
+		dir, _ := m["dir"].(string)
+		name, _ := m["name"].(string)
+		if name == "" {
+			name = "{protocol}-{date}-{time}.csv"
+		}
+		p.Output = filepath.Join(dir, name)
+	case "profiles":
+		for name, value := range m {
+			settings, ok := value.(map[string]any)
+			if !ok {
+				return fmt.Errorf("profile %s must be a map of settings", name)
+			}
+			p.Profiles[name] = Settings{}
+			for k, v := range settings {
+				s, ok := v.(string)
+				if !ok {
+					return fmt.Errorf("profile %s: %s must be a value", name, k)
+				}
+				p.Profiles[name][k] = s
+			}
+		}
+	case "registers":
+		for device, value := range m {
+			addr, err := strconv.ParseUint(device, 0, 10)
+			if err != nil {
+				return fmt.Errorf("register map %q must be keyed by an I2C address", device)
+			}
+			regs, ok := value.(map[string]any)
+			if !ok {
+				return fmt.Errorf("register map %s must map registers to names", device)
+			}
+			p.Registers[int(addr)] = map[int]string{}
+			for reg, name := range regs {
+				r, err := strconv.ParseUint(reg, 0, 16)
+				s, ok := name.(string)
+				if err != nil || !ok {
+					return fmt.Errorf("register map %s: %s must be a register address and name", device, reg)
+				}
+				p.Registers[int(addr)][int(r)] = s
+			}
+		}
+	case "buses":
+		// Kept as in config.toml, in the order of their labels
+		var labels []string
+		for label, value := range m {
+			settings, ok := value.(map[string]any)
+			if !ok {
+				return fmt.Errorf("bus %s must be a map of settings", label)
+			}
+			labels = append(labels, label)
+			for k, v := range settings {
+				s, ok := v.(string)
+				if !ok {
+					return fmt.Errorf("bus %s: %s must be a value", label, k)
+				}
+				p.Settings["bus."+label+"."+k] = s
+			}
+		}
+		sort.Strings(labels)
+		p.Settings["buses"] = strings.Join(labels, ",")
+	default:
+		return fmt.Errorf("unknown section %s", key)
+	}
+	return nil
+}
+
+// useProject seeds the model from the project file at path. Problems are
+// reported in the status bar rather than stopping the launch.
+func (m *model) useProject(path string) {
+	p, err := loadProject(path)
+	if err != nil {
+		m.statusMsg = "Warning: " + err.Error()
+		return
+	}
+	m.statusMsg = "Project: " + path
+	if warning := m.applyProject(p); warning != "" {
+		m.statusMsg = "Warning: " + projectFile + ": " + warning
+	}
+}
+
+// applyProject seeds the model from a project. It returns a warning for
+// settings that could not be applied, or "" if there were none.
+func (m *model) applyProject(p *Project) string {
+	m.project = p
+	m.registers = p.Registers
+	if p.Output != "" {
+		m.outputPattern = p.Output
+	}
+	return m.applySettings(p.Settings)
+}
+
+// outputName expands an output file pattern for a capture started at t.
+// {protocol}, {date} and {time} are replaced, e.g.
+// "captures/{protocol}-{date}-{time}.csv" becomes
+// "captures/i2c-2024-05-01-143005.csv".
+func outputName(pattern string, protocol Protocol, t time.Time) string {
+	return strings.NewReplacer(
+		"{protocol}", strings.ToLower(protocol.String()),
+		"{date}", t.Format("2006-01-02"),
+		"{time}", t.Format("150405"),
+	).Replace(pattern)
+}
+
+// nextOutputFile names the output file of a new capture from the output
+// pattern, if there is one, and creates its directory.
+func (m *model) nextOutputFile(t time.Time) error {
+	if m.outputPattern == "" {
+		return nil
+	}
+	m.outputFile = outputName(m.outputPattern, m.protocol, t)
+	return os.MkdirAll(filepath.Dir(m.outputFile), 0755)
+}
+
+// parseYAML reads the subset of YAML used by project files: nested maps of
+// scalars, with comments and quoted strings. Nested maps are
+// map[string]any, scalars are strings.
+func parseYAML(data string) (map[string]any, error) {
+	type line struct {
+		n, indent int
+		text      string
+	}
+	var lines []line
+	for n, raw := range strings.Split(data, "\n") {
+		text := strings.TrimRight(stripYAMLComment(raw), " \r")
+		if strings.TrimSpace(text) == "" || text == "---" {
+			continue
+		}
+		trimmed := strings.TrimLeft(text, " ")
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: indent with spaces, not tabs", n+1)
+		}
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			return nil, fmt.Errorf("line %d: lists are not supported", n+1)
+		}
+		lines = append(lines, line{n + 1, len(text) - len(trimmed), trimmed})
+	}
+
+	var block func(i, indent int) (map[string]any, int, error)
+	block = func(i, indent int) (map[string]any, int, error) {
+		m := map[string]any{}
+		for i < len(lines) && lines[i].indent >= indent {
+			l := lines[i]
+			if l.indent > indent {
+				return nil, 0, fmt.Errorf("line %d: unexpected indent", l.n)
+			}
+			key, value, ok := strings.Cut(l.text, ":")
+			if strings.HasPrefix(l.text, `"`) || strings.HasPrefix(l.text, "'") {
+				// A quoted key may contain a colon
+				k, rest, err := yamlScalar(l.text)
+				key, value, ok = k, strings.TrimPrefix(rest, ":"), err == nil && strings.HasPrefix(rest, ":")
+			}
+			key = strings.TrimSpace(key)
+			if !ok || key == "" {
+				return nil, 0, fmt.Errorf("line %d: expected key: value", l.n)
+			}
+			if _, dup := m[key]; dup {
+				return nil, 0, fmt.Errorf("line %d: %s is set twice", l.n, key)
+			}
+			i++
+
+			if value = strings.TrimSpace(value); value != "" {
+				v, rest, err := yamlScalar(value)
+				if err != nil || rest != "" {
+					return nil, 0, fmt.Errorf("line %d: bad value for %s", l.n, key)
+				}
+				m[key] = v
+				continue
+			}
+			if i < len(lines) && lines[i].indent > indent {
+				child, next, err := block(i, lines[i].indent)
+				if err != nil {
+					return nil, 0, err
+				}
+				m[key], i = child, next
+			} else {
+				m[key] = ""
+			}
+		}
+		return m, i, nil
+	}
+
+	if len(lines) == 0 {
+		return map[string]any{}, nil
+	}
+	m, i, err := block(0, lines[0].indent)
+	if err == nil && i < len(lines) {
+		err = fmt.Errorf("line %d: unexpected indent", lines[i].n)
+	}
+	return m, err
+}
+
+// yamlScalar reads a plain, single- or double-quoted scalar and returns it
+// with the rest of the text.
+func yamlScalar(s string) (value, rest string, err error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		return tomlValue(s) // Same escapes as TOML basic strings
+	case strings.HasPrefix(s, "'"):
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				i++
+				continue
+			}
+			return strings.ReplaceAll(s[1:i], "''", "'"), strings.TrimSpace(s[i+1:]), nil
+		}
+		return "", "", fmt.Errorf("unterminated string %s", s)
+	}
+	return strings.TrimSpace(s), "", nil
+}
+
+// stripYAMLComment removes a comment that starts with " #" outside quotes.
+// A quote only opens a string at the start of a scalar, so the apostrophe
+// in "name: Bob's board # note" is part of the text.
+func stripYAMLComment(s string) string {
+	quote := byte(0)
+	prev := byte(0) // Last non-blank character outside quotes
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' || c == '\'' && quote == c && i+1 < len(s) && s[i+1] == c {
+				i++ // An escaped character, or '' in single quotes
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && strings.IndexByte("\x00:-,[{", prev) >= 0:
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		}
+		if c != ' ' && c != '\t' && quote == 0 {
+			prev = c
+		}
+	}
+	return s
+}
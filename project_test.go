@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseYAML(t *testing.T) {
+	doc, err := parseYAML(`---
+# Board A wiring
+protocol: i2c   # the sensor bus
+name: 'it''s # not a comment'
+board: Bob's board # a quote inside text
+size: 5" display
+output:
+  dir: captures
+  name: "{protocol}-{time}.csv"
+registers:
+  "0x68":
+      0x75: WHO_AM_I
+empty:
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"protocol": "i2c",
+		"name":     "it's # not a comment",
+		"board":    "Bob's board",
+		"size":     `5" display`,
+		"output":   map[string]any{"dir": "captures", "name": "{protocol}-{time}.csv"},
+		"registers": map[string]any{
+			"0x68": map[string]any{"0x75": "WHO_AM_I"},
+		},
+		"empty": "",
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("parseYAML = %v, want %v", doc, want)
+	}
+
+	for _, bad := range []string{
+		"pins:\n\tclk: D2",
+		"pins:\n  - D2",
+		"a: 1\n  b: 2",
+		"a: 1\na: 2",
+		"just text",
+		`a: "unterminated`,
+	} {
+		if _, err := parseYAML(bad); err == nil {
+			t.Errorf("parseYAML(%q) succeeded", bad)
+		}
+	}
+}
+
+// writeProject writes a project file to dir.
+func writeProject(t *testing.T, dir, data string) string {
+	t.Helper()
+	path := filepath.Join(dir, projectFile)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testProject = `protocol: i2c
+addr: any
+rate: 4M
+output:
+  dir: captures
+profiles:
+  eeprom:
+    addr: "0x50"
+registers:
+  "0x50":
+    0xA5: CONFIG
+`
+
+func TestFindProject(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "firmware", "src")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got := findProject(sub); got != "" {
+		t.Errorf("found %s before it was written", got)
+	}
+	path := writeProject(t, root, testProject)
+	if got := findProject(sub); got != path {
+		t.Errorf("findProject = %q, want %q", got, path)
+	}
+}
+
+func TestProjectSeedsModel(t *testing.T) {
+	dir := t.TempDir()
+	p, err := loadProject(writeProject(t, dir, testProject))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := initialModel(newDemoBackend())
+	if warning := m.applyProject(p); warning != "" {
+		t.Fatal(warning)
+	}
+	if m.protocol != ProtocolI2C || m.i2cAddress != "any" || m.sampleRate != "4000000" {
+		t.Errorf("settings not applied: %v", m.settings())
+	}
+	if s, fromProject := m.lookupProfile("eeprom"); !fromProject || s["addr"] != "0x50" {
+		t.Errorf("project profile = %v, %v", s, fromProject)
+	}
+
+	at := time.Date(2024, 5, 1, 14, 30, 5, 0, time.Local)
+	if err := m.nextOutputFile(at); err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "captures", "i2c-2024-05-01-143005.csv"); m.outputFile != want {
+		t.Errorf("output file = %q, want %q", m.outputFile, want)
+	}
+
+	// Transactions are labelled with the registers they address
+	out := filepath.Join(t.TempDir(), "out.csv")
+	if err := decodeToCSV(context.Background(), filepath.Join("demo", "capture.sr"), out, ProtocolI2C, m); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if !strings.HasSuffix(lines[0], ",stop,register") {
+		t.Errorf("header = %q", lines[0])
+	}
+	for _, line := range lines[1:] {
+		if !strings.HasSuffix(line, ",CONFIG") {
+			t.Errorf("row %q has no register", line)
+		}
+	}
+}
+
+func TestCLIProject(t *testing.T) {
+	dir := t.TempDir()
+	writeProject(t, dir, testProject)
+	sub := filepath.Join(dir, "src")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(sub)
+
+	var stdout, stderr bytes.Buffer
+	if code := runCLI(newDemoBackend(), []string{"capture", "--rate", "24M"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "captures", "i2c-*.csv"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("captures = %v, %v; stderr %s", files, err, stderr.String())
+	}
+}
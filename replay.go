@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+//go:embed demo
+var demoFiles embed.FS
+
+// replayBackend serves recorded sigrok-cli output instead of talking to
+// hardware. It backs the test suite and --demo mode.
+//
+// The recording directory holds scan.txt (`--scan` output), show.txt
+// (`--show` output for every device) and capture.sr (the session every
+// acquisition returns).
+type replayBackend struct {
+	files    fs.FS
+	realTime bool // Acquisitions take their requested duration
+
+	mu       sync.Mutex       // Captures run in their own goroutines
+	requests []AcquireRequest // Every acquisition, in order
+}
+
+func newReplayBackend(files fs.FS) *replayBackend {
+	return &replayBackend{files: files}
+}
+
+// newDemoBackend replays the recording embedded in the binary.
+func newDemoBackend() *replayBackend {
+	files, err := fs.Sub(demoFiles, "demo")
+	if err != nil {
+		panic(err)
+	}
+	return newReplayBackend(files)
+}
+
+func (b *replayBackend) Scan() ([]byte, error) {
+	return fs.ReadFile(b.files, "scan.txt")
+}
+
+func (b *replayBackend) Show(device string) ([]byte, error) {
+	return fs.ReadFile(b.files, "show.txt")
+}
+
+// record adds an acquisition to the requests.
+func (b *replayBackend) record(req AcquireRequest) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests = append(b.requests, req)
+}
+
+func (b *replayBackend) Acquire(ctx context.Context, req AcquireRequest) error {
+	b.record(req)
+
+	if d, err := time.ParseDuration(req.Duration); err == nil && b.realTime {
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	data, err := fs.ReadFile(b.files, "capture.sr")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(req.OutputFile, data, 0644)
+}
+
+// Stream plays the recorded capture in a loop until the reader is closed,
+// about ten times a second in real time and as fast as it is read otherwise.
+func (b *replayBackend) Stream(req AcquireRequest) (*Session, io.ReadCloser, error) {
+	b.record(req)
+
+	data, err := fs.ReadFile(b.files, "capture.sr")
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := readSession(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		for {
+			if _, err := w.Write(s.Logic); err != nil {
+				return
+			}
+			if b.realTime {
+				time.Sleep(100 * time.Millisecond)
+			}
+		}
+	}()
+
+	layout := *s
+	layout.Logic = nil
+	return &layout, r, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SoftTrigger fires on decoded data rather than on edges. All of its set
+// fields must match the same record: one I2C transaction, or consecutive
+// SPI words or UART frames in the same direction.
+type SoftTrigger struct {
+	Address  int      // I2C address, -1 for any
+	Read     int      // I2C direction: 0 write, 1 read, -1 either
+	Sequence []uint32 // Words, bytes or characters to find, nil for any
+}
+
+// errNoMatch reports a capture in which the software trigger never fired.
+var errNoMatch = errors.New("software trigger did not match")
+
+// parseSoftTrigger reads a software trigger such as "addr=0x50/w",
+// "bytes=9F" or "text=OK". Conditions are separated by ";", so an I2C
+// trigger can require both: "addr=0x50/w; bytes=00 10". An empty spec or
+// "none" means no software trigger and returns nil.
+func parseSoftTrigger(spec string) (*SoftTrigger, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || strings.EqualFold(spec, triggerNone) {
+		return nil, nil
+	}
+
+	t := &SoftTrigger{Address: -1, Read: -1}
+	for _, part := range strings.Split(spec, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("match %q must be addr=, bytes= or text=", part)
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "addr":
+			addr, rw, _ := strings.Cut(strings.TrimSpace(value), "/")
+			v, err := strconv.ParseUint(addr, 0, 16)
+			if err != nil || v > 0x3FF {
+				return nil, fmt.Errorf("match address must be a 7- or 10-bit number, got %q", addr)
+			}
+			t.Address = int(v)
+			if rw != "" {
+				if t.Read, err = parseChoice("match direction", rw, "w", "r"); err != nil {
+					return nil, err
+				}
+			}
+		case "bytes":
+			// Hex words separated by spaces or commas, e.g. "9F" or "03 00 10"
+			for _, word := range strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == ',' }) {
+				v, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(word), "0x"), 16, 32)
+				if err != nil {
+					return nil, fmt.Errorf("match byte %q is not hex", word)
+				}
+				t.Sequence = append(t.Sequence, uint32(v))
+			}
+		case "text":
+			for _, c := range []byte(strings.Trim(strings.TrimSpace(value), `"`)) {
+				t.Sequence = append(t.Sequence, uint32(c))
+			}
+		default:
+			return nil, fmt.Errorf("match %q must be addr=, bytes= or text=", part)
+		}
+	}
+	if t.Address < 0 && len(t.Sequence) == 0 {
+		return nil, fmt.Errorf("match %q has nothing to look for", spec)
+	}
+	return t, nil
+}
+
+// softTrigger validates the software trigger settings for the selected
+// protocol, or buses. It returns nil when no software trigger is set.
+func softTrigger(m model) (*SoftTrigger, error) {
+	t, err := parseSoftTrigger(m.softTrigger)
+	if err != nil || t == nil {
+		return nil, err
+	}
+	isI2C := func(bm model) bool { return bm.protocol == ProtocolI2C }
+	if t.Address >= 0 && !slices.ContainsFunc(m.busModels(), isI2C) {
+		return nil, fmt.Errorf("match on an address needs I2C")
+	}
+	return t, nil
+}
+
+// softWindow returns the time kept before and after a software trigger
+// match.
+func softWindow(m model) (before, after time.Duration, err error) {
+	before, err = time.ParseDuration(m.softBefore)
+	if err != nil || before < 0 {
+		return 0, 0, fmt.Errorf("time before match must be a duration, got %q", m.softBefore)
+	}
+	after, err = time.ParseDuration(m.softAfter)
+	if err != nil || after <= 0 {
+		return 0, 0, fmt.Errorf("time after match must be a positive duration, got %q", m.softAfter)
+	}
+	return before, after, nil
+}
+
+// samplesIn converts a duration to a sample count.
+func samplesIn(d time.Duration, sampleRate uint64) int64 {
+	return int64(d.Seconds() * float64(sampleRate))
+}
+
+// trimToMatch cuts a recorded session down to the window around the first
+// software trigger match, for captures with analog channels, which cannot
+// be streamed. Without a software trigger it does nothing.
+func trimToMatch(ctx context.Context, srFile string, m model) error {
+	t, err := softTrigger(m)
+	if err != nil || t == nil {
+		return err
+	}
+	before, after, err := softWindow(m)
+	if err != nil {
+		return err
+	}
+
+	s, err := openSession(srFile)
+	if err != nil {
+		return err
+	}
+	// The window is cut from the session as recorded, not the digitized one
+	decodable, err := decodableSession(s, m)
+	if err != nil {
+		return err
+	}
+	dec, err := newCaptureDecoder(m, decodable)
+	if err != nil {
+		return err
+	}
+	dec.matcher = newSoftMatcher(t)
+	if err := feedSession(ctx, decodable, dec); err != nil {
+		return err
+	}
+	dec.drain()
+	if !dec.matcher.fired {
+		return errNoMatch
+	}
+
+	sm := dec.matcher
+	window := s.Slice(sm.from-samplesIn(before, s.SampleRate), sm.at+samplesIn(after, s.SampleRate))
+	return writeSession(srFile, window)
+}
+
+// softMatcher runs a SoftTrigger over the records of a protocol decoder
+// and remembers where it first fired. A nil matcher ignores every record,
+// so decoders can report to it unconditionally.
+type softMatcher struct {
+	t      *SoftTrigger
+	recent [2][]matchedWord // Latest words per direction, oldest first
+
+	fired bool
+	at    int64 // Start of the first matching record
+	from  int64 // Start of the frame it belongs to, e.g. CS going active
+}
+
+// matchedWord is a decoded word, the sample it started at and the start of
+// its frame.
+type matchedWord struct {
+	frame, start int64
+	value        uint32
+}
+
+func newSoftMatcher(t *SoftTrigger) *softMatcher {
+	if t == nil {
+		return nil
+	}
+	return &softMatcher{t: t}
+}
+
+// word checks a word (SPI) or character (UART) in one direction against
+// the sequence; dir is 0 or 1 and keeps MOSI and MISO, or TX and RX, apart.
+// A window cut from frame onwards decodes the word in the same way.
+func (sm *softMatcher) word(dir int, frame, start int64, value uint32) {
+	if sm == nil || sm.fired || len(sm.t.Sequence) == 0 {
+		return
+	}
+	seq := sm.t.Sequence
+	recent := append(sm.recent[dir], matchedWord{frame, start, value})
+	if len(recent) > len(seq) {
+		recent = recent[len(recent)-len(seq):]
+	}
+	sm.recent[dir] = recent
+	if len(recent) < len(seq) {
+		return
+	}
+	for i, w := range recent {
+		if w.value != seq[i] {
+			return
+		}
+	}
+	sm.fire(recent[0].frame, recent[0].start)
+}
+
+// transaction checks an I2C transaction against the address, direction
+// and data sequence.
+func (sm *softMatcher) transaction(t I2CTransaction) {
+	if sm == nil || sm.fired {
+		return
+	}
+	if sm.t.Address >= 0 && t.Address != sm.t.Address {
+		return
+	}
+	if sm.t.Read >= 0 && t.Read != (sm.t.Read == 1) {
+		return
+	}
+	if len(sm.t.Sequence) > 0 {
+		data := make([]byte, len(t.Data))
+		for i, b := range t.Data {
+			data[i] = b.Value
+		}
+		seq := make([]byte, len(sm.t.Sequence))
+		for i, v := range sm.t.Sequence {
+			if v > 0xFF {
+				return
+			}
+			seq[i] = byte(v)
+		}
+		if !bytes.Contains(data, seq) {
+			return
+		}
+	}
+	sm.fire(t.Start, t.Start)
+}
+
+func (sm *softMatcher) fire(from, at int64) {
+	sm.fired = true
+	sm.from = from
+	sm.at = at
+}
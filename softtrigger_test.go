@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseSoftTrigger(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    *SoftTrigger
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"bytes=9F", &SoftTrigger{Address: -1, Read: -1, Sequence: []uint32{0x9F}}, false},
+		{"bytes=03 00,0x10", &SoftTrigger{Address: -1, Read: -1, Sequence: []uint32{3, 0, 0x10}}, false},
+		{`text="OK"`, &SoftTrigger{Address: -1, Read: -1, Sequence: []uint32{'O', 'K'}}, false},
+		{"addr=0x50/w", &SoftTrigger{Address: 0x50, Read: 0}, false},
+		{"addr=0x50; bytes=00", &SoftTrigger{Address: 0x50, Read: -1, Sequence: []uint32{0}}, false},
+		{"addr=0x800", nil, true},
+		{"addr=0x50/x", nil, true},
+		{"bytes=G1", nil, true},
+		{"bytes=", nil, true},
+		{"cmd=9F", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSoftTrigger(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSoftTrigger(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if (got == nil) != (tt.want == nil) || got != nil && (got.Address != tt.want.Address ||
+			got.Read != tt.want.Read || !slices.Equal(got.Sequence, tt.want.Sequence)) {
+			t.Errorf("parseSoftTrigger(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestCaptureSoftTrigger(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol Protocol
+		match    string
+		samples  int64
+		want     []string // Decoded rows of the window, without times
+	}{
+		{"UART text", ProtocolUART, "text=OK", 205 * 24, []string{",4F,", ",4B,"}},
+		// The window starts inside the write, so the read is a plain START
+		{"I2C read", ProtocolI2C, "addr=0x50/r", 205 * 24, []string{"START,0x50,R,ACK,3C,NACK,,STOP"}},
+		// The window starts before CS went active at 10 us, not before the
+		// matching word at 31.5 us
+		{"SPI bytes", ProtocolSPI, "bytes=A5", 226.5 * 24, []string{"88,00", "00,E4", "A5,3C", "FF,01"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			m := initialModel(newDemoBackend())
+			m.protocol = tt.protocol
+			m.softTrigger = tt.match
+			m.softBefore = "5us"
+			m.softAfter = "200us"
+
+			if err := runCapture(context.Background(), m, captureFile); err != nil {
+				t.Fatal(err)
+			}
+			s, err := openSession(captureFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.NumSamples(); got != tt.samples {
+				t.Errorf("window has %d samples, want %d", got, tt.samples)
+			}
+
+			data, err := os.ReadFile(m.outputFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")[1:]
+			if len(lines) < len(tt.want) {
+				t.Fatalf("got rows %q, want %q first", lines, tt.want)
+			}
+			for i, want := range tt.want {
+				_, row, _ := strings.Cut(lines[i], ",")
+				if row != want {
+					t.Errorf("row %d = %q, want %q", i, row, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCaptureSoftTriggerNoMatch(t *testing.T) {
+	t.Chdir(t.TempDir())
+	m := initialModel(newDemoBackend())
+	m.protocol = ProtocolUART
+	m.softTrigger = "text=NO"
+
+	if err := runCapture(context.Background(), m, captureFile); !errors.Is(err, errNoMatch) {
+		t.Fatalf("err = %v, want errNoMatch", err)
+	}
+	if _, err := os.Stat(m.outputFile); !os.IsNotExist(err) {
+		t.Error("output written without a match")
+	}
+}
+
+func TestStreamSoftTrigger(t *testing.T) {
+	t.Chdir(t.TempDir())
+	m := initialModel(newDemoBackend())
+	m.protocol = ProtocolUART
+	m.continuous = true
+	m.duration = "10ms"
+	m.softTrigger = "text=OK"
+	m.softBefore = "5us"
+	m.softAfter = "200us"
+
+	_, done := collectStream(t, m, false)
+	if done.err != nil {
+		t.Fatal(done.err)
+	}
+	if !done.triggered {
+		t.Fatal("software trigger did not fire")
+	}
+
+	data, err := os.ReadFile(m.outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(data), "\n"); got != 3 {
+		t.Errorf("output has %d lines, want header and 2 frames:\n%s", got, data)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SPIConfig selects the pins, mode and framing of an SPI bus.
+type SPIConfig struct {
+	CLK, MOSI, MISO string   // Pins; MOSI and MISO may be empty
+	CS              []string // One chip select pin per device on the bus, if any
+
+	CPOL, CPHA   int
+	LSBFirst     bool
+	WordSize     int // 4 to 32 bits
+	CSActiveHigh bool
+}
+
+// SPIWord is one word shifted in both directions while CS was active.
+type SPIWord struct {
+	Start, End int64 // First and last sampling edge
+	Select     int64 // Where CS went active, or Start without CS
+	CS         int   // Index of the chip select that was active
+	MOSI, MISO uint32
+
+	// Partial is set when CS was already active as the capture began, so
+	// the word may be the tail of a transfer rather than its start
+	Partial bool
+}
+
+// spiConfig validates the SPI fields of the Configuration panel.
+func spiConfig(m model) (SPIConfig, error) {
+	cfg := SPIConfig{CLK: m.spiCLK, MOSI: m.spiMOSI, MISO: m.spiMISO, CS: csPins(m.spiCS)}
+	var err error
+	if cfg.CPOL, err = parseChoice("CPOL", m.spiCPOL, "0", "1"); err != nil {
+		return cfg, err
+	}
+	if cfg.CPHA, err = parseChoice("CPHA", m.spiCPHA, "0", "1"); err != nil {
+		return cfg, err
+	}
+	order, err := parseChoice("bit order", m.spiBitOrder, "MSB", "LSB")
+	if err != nil {
+		return cfg, err
+	}
+	cfg.LSBFirst = order == 1
+	if cfg.WordSize, err = parseIntRange("word size", m.spiWordSize, 4, 32); err != nil {
+		return cfg, err
+	}
+	csPol, err := parseChoice("CS polarity", m.spiCSPolarity, "low", "high")
+	if err != nil {
+		return cfg, err
+	}
+	cfg.CSActiveHigh = csPol == 1
+	return cfg, nil
+}
+
+// csPins splits the CS setting into its pins, e.g. "D3,D4" for two devices
+// sharing CLK, MOSI and MISO.
+func csPins(value string) []string {
+	var pins []string
+	for _, pin := range strings.Split(value, ",") {
+		if pin = strings.TrimSpace(pin); pin != "" {
+			pins = append(pins, pin)
+		}
+	}
+	return pins
+}
+
+// csRole names the i-th chip select of a capture: "CS" for the first, so
+// that the trigger presets refer to it, then "CS2", "CS3" and so on.
+func csRole(i int) string {
+	if i == 0 {
+		return "CS"
+	}
+	return fmt.Sprintf("CS%d", i+1)
+}
+
+// spiDecoder shifts in MOSI and MISO on every sampling edge of CLK.
+type spiDecoder struct {
+	cfg             SPIConfig
+	clk, mosi, miso int   // Bit indexes, -1 if not connected
+	cs              []int // Bit index of each chip select
+
+	started bool
+	prev    uint64
+	bits    int
+	start   int64
+	sel     int64 // Where CS last went active
+	seen    bool  // CS has changed state since the capture began
+	mosiVal uint32
+	misoVal uint32
+
+	words []SPIWord
+}
+
+func newSPIDecoder(s *Session, cfg SPIConfig) (*spiDecoder, error) {
+	d := &spiDecoder{cfg: cfg}
+	var err error
+	if d.clk, err = resolveChannel(s, "CLK", cfg.CLK); err != nil {
+		return nil, err
+	}
+	if d.clk < 0 {
+		return nil, errMissingPin("CLK")
+	}
+	if d.mosi, err = resolveChannel(s, "MOSI", cfg.MOSI); err != nil {
+		return nil, err
+	}
+	if d.miso, err = resolveChannel(s, "MISO", cfg.MISO); err != nil {
+		return nil, err
+	}
+	for i, pin := range cfg.CS {
+		ch, err := resolveChannel(s, csRole(i), pin)
+		if err != nil {
+			return nil, err
+		}
+		d.cs = append(d.cs, ch)
+	}
+	return d, nil
+}
+
+// decodeSPI decodes every complete word in a session.
+func decodeSPI(s *Session, cfg SPIConfig) ([]SPIWord, error) {
+	d, err := newSPIDecoder(s, cfg)
+	if err != nil {
+		return nil, err
+	}
+	decodeSession(s, d)
+	return d.words, nil
+}
+
+// selection returns the index of the active chip select, or -1 when none
+// is. Two at once is bus contention and decodes as neither.
+func (d *spiDecoder) selection(sample uint64) int {
+	if len(d.cs) == 0 {
+		return 0
+	}
+	active := -1
+	for i, ch := range d.cs {
+		level := sample>>ch&1 == 1
+		if level != d.cfg.CSActiveHigh {
+			continue
+		}
+		if active >= 0 {
+			return -1
+		}
+		active = i
+	}
+	return active
+}
+
+func (d *spiDecoder) feed(n int64, sample uint64) {
+	if !d.started {
+		d.started = true
+		d.prev = sample
+		return
+	}
+	prev := d.prev
+	d.prev = sample
+
+	// A word in progress is dropped whenever CS changes state
+	selection := d.selection(sample)
+	if selection != d.selection(prev) {
+		d.bits = 0
+		d.sel = n
+		d.seen = true
+		return
+	}
+	if selection < 0 {
+		return
+	}
+
+	clk := sample >> d.clk & 1
+	if clk == prev>>d.clk&1 {
+		return
+	}
+	// Modes 0 and 3 sample on the rising edge, modes 1 and 2 on the falling edge
+	rising := clk == 1
+	if rising != (d.cfg.CPOL == d.cfg.CPHA) {
+		return
+	}
+
+	if d.bits == 0 {
+		d.start = n
+		d.mosiVal = 0
+		d.misoVal = 0
+	}
+	d.mosiVal = d.shift(d.mosiVal, sample, d.mosi)
+	d.misoVal = d.shift(d.misoVal, sample, d.miso)
+	d.bits++
+
+	if d.bits == d.cfg.WordSize {
+		sel := d.sel
+		if len(d.cs) == 0 {
+			sel = d.start
+		}
+		d.words = append(d.words, SPIWord{Start: d.start, End: n, Select: sel, CS: selection, MOSI: d.mosiVal, MISO: d.misoVal,
+			Partial: len(d.cs) > 0 && !d.seen})
+		d.bits = 0
+	}
+}
+
+// pendingStart is where the word being clocked in started, or -1.
+func (d *spiDecoder) pendingStart() int64 {
+	if d.bits > 0 {
+		return d.start
+	}
+	return -1
+}
+
+func (d *spiDecoder) shift(v uint32, sample uint64, ch int) uint32 {
+	if ch < 0 {
+		return v
+	}
+	bit := uint32(sample >> ch & 1)
+	if d.cfg.LSBFirst {
+		return v | bit<<d.bits
+	}
+	return v<<1 | bit
+}
+
+// spiHeader is the CSV header of an SPI bus. With several chip selects a
+// cs column shows which device each word was for.
+func spiHeader(cfg SPIConfig) []string {
+	if len(cfg.CS) > 1 {
+		return []string{"time", "cs", "mosi", "miso"}
+	}
+	return []string{"time", "mosi", "miso"}
+}
+
+// spiRow formats a word as a CSV row. Unconnected lines are left empty.
+func spiRow(s *Session, cfg SPIConfig, w SPIWord) []string {
+	mosi, miso := "", ""
+	if cfg.MOSI != "" {
+		mosi = formatHex(w.MOSI, cfg.WordSize)
+	}
+	if cfg.MISO != "" {
+		miso = formatHex(w.MISO, cfg.WordSize)
+	}
+	row := []string{fmt.Sprintf("%.9f", s.Seconds(w.Start))}
+	if len(cfg.CS) > 1 {
+		row = append(row, cfg.CS[w.CS])
+	}
+	return append(row, mosi, miso)
+}
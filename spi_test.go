@@ -0,0 +1,191 @@
+package main
+
+import "testing"
+
+// spiSignal clocks out words on CLK=0, MOSI=1, MISO=2, CS=3 in the given
+// mode, framed by one CS assertion.
+func spiSignal(cfg SPIConfig, words [][2]uint32) *Session {
+	g := newSignal("CLK", "MOSI", "MISO", "CS")
+	csIdle := 1
+	if cfg.CSActiveHigh {
+		csIdle = 0
+	}
+	g.set(0, cfg.CPOL).set(3, csIdle).hold(4)
+	g.set(3, 1-csIdle)
+
+	for _, w := range words {
+		for i := 0; i < cfg.WordSize; i++ {
+			b := cfg.WordSize - 1 - i
+			if cfg.LSBFirst {
+				b = i
+			}
+			bit := func(v uint32) int { return int(v >> b & 1) }
+			// CPHA=0 sets data before the leading edge, CPHA=1 after it
+			if cfg.CPHA == 1 {
+				g.set(0, 1-cfg.CPOL).hold(1)
+			}
+			g.set(1, bit(w[0])).set(2, bit(w[1])).hold(2)
+			g.set(0, 1-cfg.CPOL).hold(2)
+			if cfg.CPHA == 1 {
+				g.set(0, cfg.CPOL).hold(2)
+			} else {
+				g.set(0, cfg.CPOL)
+			}
+		}
+		g.hold(3)
+	}
+	g.set(3, csIdle).hold(4)
+	return g.session(1000000)
+}
+
+func TestDecodeSPI(t *testing.T) {
+	words := [][2]uint32{{0x9F, 0x00}, {0x00, 0xEF}, {0x5A, 0x40}}
+	tests := []struct {
+		name string
+		cfg  SPIConfig
+		want [][2]uint32
+	}{
+		{"mode0", SPIConfig{WordSize: 8}, words},
+		{"mode1", SPIConfig{CPHA: 1, WordSize: 8}, words},
+		{"mode2", SPIConfig{CPOL: 1, WordSize: 8}, words},
+		{"mode3", SPIConfig{CPOL: 1, CPHA: 1, WordSize: 8}, words},
+		{"lsb", SPIConfig{LSBFirst: true, WordSize: 8}, words},
+		{"cs high", SPIConfig{CSActiveHigh: true, WordSize: 8}, words},
+		{"12 bit", SPIConfig{WordSize: 12}, [][2]uint32{{0xABC, 0x123}}},
+		{"32 bit", SPIConfig{WordSize: 32}, [][2]uint32{{0xDEADBEEF, 0x01020304}}},
+		{"4 bit", SPIConfig{WordSize: 4}, [][2]uint32{{0xA, 0x5}, {0x0, 0xF}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.CLK, cfg.MOSI, cfg.MISO, cfg.CS = "D0", "D1", "D2", []string{"D3"}
+			got, err := decodeSPI(spiSignal(cfg, tt.want), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d words, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, w := range tt.want {
+				if got[i].MOSI != w[0] || got[i].MISO != w[1] {
+					t.Errorf("word %d = %X/%X, want %X/%X", i, got[i].MOSI, got[i].MISO, w[0], w[1])
+				}
+				if got[i].End <= got[i].Start {
+					t.Errorf("word %d has empty span %d-%d", i, got[i].Start, got[i].End)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeSPIDropsPartialWord(t *testing.T) {
+	cfg := SPIConfig{CLK: "D0", MOSI: "D1", MISO: "D2", CS: []string{"D3"}, WordSize: 16}
+	// Eight clocks of a 16-bit word, then CS is released
+	s := spiSignal(SPIConfig{WordSize: 8}, [][2]uint32{{0xFF, 0xFF}})
+	got, err := decodeSPI(s, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("got %d words from a partial transfer, want 0", len(got))
+	}
+}
+
+func TestSPIFilter(t *testing.T) {
+	// Words of all zeros and all ones are data like any other
+	s := spiSignal(SPIConfig{WordSize: 8}, [][2]uint32{{0x00, 0xFF}, {0xFF, 0x00}})
+	m := initialModel(newDemoBackend())
+	m.protocol = ProtocolSPI
+	m.filterFrames = true
+	for _, tc := range []struct {
+		name string
+		s    *Session
+		want int
+	}{
+		{"whole", s, 2},
+		// The capture begins with CS already active
+		{"cut", s.Slice(5, s.NumSamples()), 0},
+	} {
+		dec, err := newProtocolDecoder(m, tc.s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decodeSession(tc.s, dec)
+		dec.flush(tc.s.NumSamples())
+		if got := len(dec.drain()); got != tc.want {
+			t.Errorf("%s: got %d words, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDecodeSPIChipSelects(t *testing.T) {
+	// Mode 0 words to the device on CS (3) and CS2 (4) in turn
+	g := newSignal("CLK", "MOSI", "MISO", "CS", "CS2")
+	g.set(3, 1).set(4, 1).hold(4)
+	transfer := func(cs int, v uint32) {
+		g.set(cs, 0).hold(2)
+		for i := 7; i >= 0; i-- {
+			g.set(1, int(v>>i&1)).hold(2).set(0, 1).hold(2).set(0, 0)
+		}
+		g.hold(2).set(cs, 1).hold(4)
+	}
+	transfer(3, 0x9F)
+	transfer(4, 0x05)
+	// Both selected at once is contention and is not decoded
+	g.set(3, 0)
+	transfer(4, 0xFF)
+	g.set(3, 1)
+	transfer(3, 0x03)
+
+	m := initialModel(newDemoBackend())
+	m.spiCLK, m.spiMOSI, m.spiMISO, m.spiCS = "D0", "D1", "D2", "D3, D4"
+	cfg, err := spiConfig(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := g.session(1000000)
+	got, err := decodeSPI(s, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		cs   int
+		mosi uint32
+	}{{0, 0x9F}, {1, 0x05}, {0, 0x03}}
+	if len(got) != len(want) {
+		t.Fatalf("got %d words, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if got[i].CS != w.cs || got[i].MOSI != w.mosi {
+			t.Errorf("word %d = CS %d %X, want CS %d %X", i, got[i].CS, got[i].MOSI, w.cs, w.mosi)
+		}
+	}
+	if row := spiRow(s, cfg, got[1]); row[1] != "D4" || row[2] != "05" {
+		t.Errorf("row = %v", row)
+	}
+
+	req, err := captureRequest(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "D2=MISO,D1=MOSI,D0=CLK,D3=CS,D4=CS2"; req.Channels != want {
+		t.Errorf("channels = %q, want %q", req.Channels, want)
+	}
+}
+
+func TestSPIConfigValidation(t *testing.T) {
+	m := initialModel(newDemoBackend())
+	if _, err := spiConfig(m); err != nil {
+		t.Fatalf("default config rejected: %v", err)
+	}
+	m.spiWordSize = "33"
+	if _, err := spiConfig(m); err == nil {
+		t.Error("word size 33 accepted")
+	}
+	m.spiWordSize = "8"
+	m.spiBitOrder = "middle"
+	if _, err := spiConfig(m); err == nil {
+		t.Error("bit order middle accepted")
+	}
+}
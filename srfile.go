@@ -0,0 +1,404 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Session is a sigrok session (.sr) file loaded into memory.
+type Session struct {
+	SampleRate uint64
+	UnitSize   int      // Bytes per logic sample
+	Probes     []string // Logic channel names indexed by bit, "" if disabled
+	Logic      []byte   // Bit-packed samples, UnitSize bytes each, little-endian
+
+	Analog []AnalogChannel
+}
+
+// AnalogChannel is one analog channel of a session, in the unit sigrok
+// recorded it in (usually volts). Sample i is taken with logic sample i.
+type AnalogChannel struct {
+	Name    string
+	Samples []float32
+}
+
+// openSession reads a .sr file without going through sigrok-cli.
+func openSession(path string) (*Session, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return readSession(f, info.Size())
+}
+
+func readSession(r io.ReaderAt, size int64) (*Session, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("not a sigrok session: %w", err)
+	}
+
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	metaFile, ok := files["metadata"]
+	if !ok {
+		return nil, fmt.Errorf("not a sigrok session: missing metadata")
+	}
+	meta, err := readMetadata(metaFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the first device is used; LazySig never records more than one
+	device := meta["device 1"]
+	if device == nil {
+		return nil, fmt.Errorf("session has no device")
+	}
+
+	s := &Session{UnitSize: 1}
+	if v, ok := device["samplerate"]; ok {
+		if s.SampleRate, err = parseSampleRate(v); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := device["unitsize"]; ok {
+		if s.UnitSize, err = strconv.Atoi(v); err != nil || s.UnitSize < 1 || s.UnitSize > 8 {
+			return nil, fmt.Errorf("invalid unitsize %q", v)
+		}
+	}
+
+	total, _ := strconv.Atoi(device["total probes"])
+	s.Probes = make([]string, total)
+	for i := range s.Probes {
+		s.Probes[i] = device[fmt.Sprintf("probe%d", i+1)]
+	}
+
+	// Logic data is split into numbered chunks: logic-1-1, logic-1-2, ...
+	if captureFile := device["capturefile"]; captureFile != "" {
+		if s.Logic, err = readChunks(files, captureFile); err != nil {
+			return nil, err
+		}
+	}
+
+	// Analog channels are numbered after the logic ones, e.g. analog9=A0,
+	// and stored as float32 chunks analog-1-9-1, analog-1-9-2, ...
+	var indexes []int
+	for key := range device {
+		if n, err := strconv.Atoi(strings.TrimPrefix(key, "analog")); err == nil && strings.HasPrefix(key, "analog") {
+			indexes = append(indexes, n)
+		}
+	}
+	sort.Ints(indexes)
+	for _, n := range indexes {
+		data, err := readChunks(files, fmt.Sprintf("analog-1-%d", n))
+		if err != nil {
+			return nil, err
+		}
+		ch := AnalogChannel{Name: device[fmt.Sprintf("analog%d", n)], Samples: make([]float32, len(data)/4)}
+		for i := range ch.Samples {
+			ch.Samples[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+		}
+		s.Analog = append(s.Analog, ch)
+	}
+
+	return s, nil
+}
+
+// readChunks concatenates the chunks of one stream: the file called name,
+// or name-1, name-2, ... in order.
+func readChunks(files map[string]*zip.File, name string) ([]byte, error) {
+	type chunk struct {
+		index int
+		file  *zip.File
+	}
+	var chunks []chunk
+	for fileName, f := range files {
+		if fileName == name {
+			chunks = append(chunks, chunk{0, f})
+			continue
+		}
+		suffix, ok := strings.CutPrefix(fileName, name+"-")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(suffix); err == nil {
+			chunks = append(chunks, chunk{n, f})
+		}
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].index < chunks[j].index })
+
+	var out []byte
+	for _, c := range chunks {
+		rc, err := c.file.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", c.file.Name, err)
+		}
+		out = append(out, data...)
+	}
+	return out, nil
+}
+
+// writeSession saves a session as a .sr file that sigrok and openSession
+// can read.
+func writeSession(path string, s *Session) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(f)
+
+	var meta strings.Builder
+	meta.WriteString("[global]\nsigrok version=0.5.2\n\n[device 1]\n")
+	meta.WriteString("capturefile=logic-1\n")
+	fmt.Fprintf(&meta, "total probes=%d\n", len(s.Probes))
+	fmt.Fprintf(&meta, "samplerate=%s\n", sigrokRate(s.SampleRate))
+	fmt.Fprintf(&meta, "total analog=%d\n", len(s.Analog))
+	for i, probe := range s.Probes {
+		if probe != "" {
+			fmt.Fprintf(&meta, "probe%d=%s\n", i+1, probe)
+		}
+	}
+	for i, ch := range s.Analog {
+		fmt.Fprintf(&meta, "analog%d=%s\n", len(s.Probes)+i+1, ch.Name)
+	}
+	fmt.Fprintf(&meta, "unitsize=%d\n", s.UnitSize)
+
+	type entry struct {
+		name string
+		data []byte
+	}
+	entries := []entry{{"version", []byte("2")}, {"metadata", []byte(meta.String())}, {"logic-1-1", s.Logic}}
+	for i, ch := range s.Analog {
+		data := make([]byte, 4*len(ch.Samples))
+		for j, v := range ch.Samples {
+			binary.LittleEndian.PutUint32(data[j*4:], math.Float32bits(v))
+		}
+		entries = append(entries, entry{fmt.Sprintf("analog-1-%d-1", len(s.Probes)+i+1), data})
+	}
+
+	for _, entry := range entries {
+		w, err := zw.Create(entry.name)
+		if err == nil {
+			_, err = w.Write(entry.data)
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// sigrokRate formats a sample rate the way sigrok writes it, e.g. "24 MHz".
+func sigrokRate(rate uint64) string {
+	switch {
+	case rate >= 1e9 && rate%1e9 == 0:
+		return fmt.Sprintf("%d GHz", rate/1e9)
+	case rate >= 1e6 && rate%1e6 == 0:
+		return fmt.Sprintf("%d MHz", rate/1e6)
+	case rate >= 1e3 && rate%1e3 == 0:
+		return fmt.Sprintf("%d kHz", rate/1e3)
+	}
+	return fmt.Sprintf("%d Hz", rate)
+}
+
+// readMetadata parses the INI-style metadata file into sections.
+func readMetadata(f *zip.File) (map[string]map[string]string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	sections := make(map[string]map[string]string)
+	var current map[string]string
+
+	scanner := bufio.NewScanner(rc)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = make(map[string]string)
+			sections[line[1:len(line)-1]] = current
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || current == nil {
+			continue
+		}
+		current[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return sections, scanner.Err()
+}
+
+// parseSampleRate accepts plain numbers and sigrok-style rates such as
+// "24 MHz", "500kHz" or "24M".
+func parseSampleRate(s string) (uint64, error) {
+	v := strings.TrimSpace(s)
+	v = strings.TrimSuffix(v, "Hz")
+	v = strings.TrimSpace(v)
+
+	multiplier := 1.0
+	if n := len(v); n > 0 {
+		switch v[n-1] {
+		case 'k', 'K':
+			multiplier = 1e3
+		case 'M':
+			multiplier = 1e6
+		case 'G':
+			multiplier = 1e9
+		}
+		if multiplier != 1 {
+			v = strings.TrimSpace(v[:n-1])
+		}
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf("invalid sample rate %q", s)
+	}
+	return uint64(f*multiplier + 0.5), nil
+}
+
+// NumSamples returns the number of logic samples in the session.
+func (s *Session) NumSamples() int64 {
+	return int64(len(s.Logic) / s.UnitSize)
+}
+
+// Sample returns the logic levels of all channels at sample i, one bit per
+// channel.
+func (s *Session) Sample(i int64) uint64 {
+	if s.UnitSize == 1 {
+		return uint64(s.Logic[i])
+	}
+	var v uint64
+	base := int(i) * s.UnitSize
+	for b := s.UnitSize - 1; b >= 0; b-- {
+		v = v<<8 | uint64(s.Logic[base+b])
+	}
+	return v
+}
+
+// Slice returns the samples from start up to end as a new session. The
+// range is clamped to the samples available.
+func (s *Session) Slice(start, end int64) *Session {
+	start = max(start, 0)
+	end = min(end, s.NumSamples())
+	out := *s
+	out.Logic = nil
+	if start < end {
+		out.Logic = append([]byte(nil), s.Logic[start*int64(s.UnitSize):end*int64(s.UnitSize)]...)
+	}
+	out.Analog = nil
+	for _, ch := range s.Analog {
+		from, to := min(start, int64(len(ch.Samples))), min(end, int64(len(ch.Samples)))
+		ch.Samples = append([]float32(nil), ch.Samples[from:max(from, to)]...)
+		out.Analog = append(out.Analog, ch)
+	}
+	return &out
+}
+
+// Digitize returns a copy of the session in which every analog channel is
+// also a logic channel of the same name: high above threshold, low
+// otherwise. Protocol decoders can then use analog inputs as pins.
+func (s *Session) Digitize(threshold float64) (*Session, error) {
+	if len(s.Analog) == 0 {
+		return s, nil
+	}
+	bits := len(s.Probes) + len(s.Analog)
+	if bits > 64 {
+		return nil, fmt.Errorf("%d channels are too many to decode together", bits)
+	}
+
+	n := s.NumSamples()
+	for _, ch := range s.Analog {
+		n = max(n, int64(len(ch.Samples)))
+	}
+	out := &Session{SampleRate: s.SampleRate, UnitSize: (bits + 7) / 8, Analog: s.Analog}
+	out.Probes = append(append([]string(nil), s.Probes...), make([]string, len(s.Analog))...)
+	for i, ch := range s.Analog {
+		out.Probes[len(s.Probes)+i] = ch.Name
+	}
+
+	out.Logic = make([]byte, n*int64(out.UnitSize))
+	logic := s.NumSamples()
+	for i := int64(0); i < n; i++ {
+		var v uint64
+		if i < logic {
+			v = s.Sample(i)
+		}
+		for a, ch := range s.Analog {
+			if i < int64(len(ch.Samples)) && float64(ch.Samples[i]) > threshold {
+				v |= 1 << (len(s.Probes) + a)
+			}
+		}
+		base := i * int64(out.UnitSize)
+		for b := 0; b < out.UnitSize; b++ {
+			out.Logic[base+int64(b)] = byte(v >> (8 * b))
+		}
+	}
+	return out, nil
+}
+
+// Bit returns the level of channel ch at sample i.
+func (s *Session) Bit(i int64, ch int) bool {
+	return s.Sample(i)>>ch&1 == 1
+}
+
+// Channel resolves a channel name to its bit index. It accepts the probe
+// name stored in the session (e.g. "CLK") as well as hardware names such
+// as "D2" or "2".
+func (s *Session) Channel(name string) (int, error) {
+	for i, probe := range s.Probes {
+		if probe != "" && strings.EqualFold(probe, name) {
+			return i, nil
+		}
+	}
+
+	if n, ok := channelIndex(name); ok && n < len(s.Probes) {
+		return n, nil
+	}
+	return 0, fmt.Errorf("channel %s not found in capture", name)
+}
+
+// channelIndex parses a hardware channel name such as "D2" or "2".
+func channelIndex(name string) (int, bool) {
+	index := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "D")
+	n, err := strconv.Atoi(index)
+	return n, err == nil && n >= 0
+}
+
+// Seconds converts a sample index to a time offset.
+func (s *Session) Seconds(sample int64) float64 {
+	if s.SampleRate == 0 {
+		return 0
+	}
+	return float64(sample) / float64(s.SampleRate)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestOpenSession(t *testing.T) {
+	s, err := openSession("capture.sr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.SampleRate != 24000000 {
+		t.Errorf("SampleRate = %d, want 24000000", s.SampleRate)
+	}
+	if s.UnitSize != 1 {
+		t.Errorf("UnitSize = %d, want 1", s.UnitSize)
+	}
+	if got, want := s.NumSamples(), int64(12000000); got != want {
+		t.Errorf("NumSamples = %d, want %d", got, want)
+	}
+
+	wantProbes := []string{"MISO", "MOSI", "CLK", "CS", "", "", "", ""}
+	if len(s.Probes) != len(wantProbes) {
+		t.Fatalf("got %d probes, want %d", len(s.Probes), len(wantProbes))
+	}
+	for i, want := range wantProbes {
+		if s.Probes[i] != want {
+			t.Errorf("probe %d = %q, want %q", i, s.Probes[i], want)
+		}
+	}
+}
+
+func TestSessionChannel(t *testing.T) {
+	s := &Session{UnitSize: 1, Probes: []string{"MISO", "MOSI", "CLK", "CS"}}
+	tests := []struct {
+		name string
+		want int
+	}{
+		{"CLK", 2},
+		{"cs", 3},
+		{"D1", 1},
+		{"0", 0},
+	}
+	for _, tt := range tests {
+		got, err := s.Channel(tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("Channel(%q) = %d, %v; want %d", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := s.Channel("D7"); err == nil {
+		t.Error("Channel(D7) succeeded on a 4-channel capture")
+	}
+}
+
+func TestSessionSample(t *testing.T) {
+	s := &Session{UnitSize: 2, Logic: []byte{0x01, 0x80, 0xff, 0x00}}
+	if got := s.NumSamples(); got != 2 {
+		t.Fatalf("NumSamples = %d, want 2", got)
+	}
+	if got := s.Sample(0); got != 0x8001 {
+		t.Errorf("Sample(0) = %#x, want 0x8001", got)
+	}
+	if !s.Bit(0, 15) || s.Bit(1, 15) {
+		t.Error("Bit(_, 15) returned wrong levels")
+	}
+}
+
+func TestParseSampleRate(t *testing.T) {
+	tests := map[string]uint64{
+		"24 MHz":   24000000,
+		"500kHz":   500000,
+		"24M":      24000000,
+		"1.5 MHz":  1500000,
+		"24000000": 24000000,
+	}
+	for in, want := range tests {
+		got, err := parseSampleRate(in)
+		if err != nil || got != want {
+			t.Errorf("parseSampleRate(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	if _, err := parseSampleRate("fast"); err == nil {
+		t.Error("parseSampleRate(fast) succeeded")
+	}
+}
+
+func TestWriteSession(t *testing.T) {
+	s, err := openSession("capture.sr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	window := s.Slice(1000, 3000)
+
+	path := filepath.Join(t.TempDir(), "window.sr")
+	if err := writeSession(path, window); err != nil {
+		t.Fatal(err)
+	}
+	got, err := openSession(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SampleRate != s.SampleRate || got.UnitSize != s.UnitSize {
+		t.Errorf("rate/unitsize = %d/%d, want %d/%d", got.SampleRate, got.UnitSize, s.SampleRate, s.UnitSize)
+	}
+	if !slices.Equal(got.Probes, s.Probes) {
+		t.Errorf("Probes = %q, want %q", got.Probes, s.Probes)
+	}
+	if got.NumSamples() != 2000 || got.Sample(0) != s.Sample(1000) {
+		t.Errorf("window does not hold samples 1000 to 3000")
+	}
+}
+
+func TestAnalogSession(t *testing.T) {
+	// UART on an analog input: 3.3 V idle with some ripple, 0.2 V low
+	g := uartSignal(false, uartBits('K', 8))
+	var volts []float32
+	for i, v := range g.samples {
+		ripple := float32(i%3) * 0.05
+		if v&1 == 1 {
+			volts = append(volts, 3.3-ripple)
+		} else {
+			volts = append(volts, 0.2+ripple)
+		}
+	}
+	s := &Session{SampleRate: 1000000, UnitSize: 1, Analog: []AnalogChannel{{Name: "RX", Samples: volts}}}
+
+	path := filepath.Join(t.TempDir(), "analog.sr")
+	if err := writeSession(path, s); err != nil {
+		t.Fatal(err)
+	}
+	got, err := openSession(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Analog) != 1 || got.Analog[0].Name != "RX" || !slices.Equal(got.Analog[0].Samples, volts) {
+		t.Fatalf("analog channel not read back: %+v", got.Analog)
+	}
+	if lo, hi, _ := analogStats(volts); lo != float64(float32(0.2)) || hi != float64(float32(3.3)) {
+		t.Errorf("min/max = %v/%v", lo, hi)
+	}
+
+	m := initialModel(newDemoBackend())
+	m.protocol = ProtocolUART
+	m.uartTX = ""
+	m.uartRX = "A0"
+	m.uartBaud = "100000"
+	out := filepath.Join(t.TempDir(), "out.csv")
+	if err := decodeToCSV(context.Background(), path, out, ProtocolUART, m); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), ",,4B,\n") {
+		t.Errorf("analog RX not decoded at the threshold:\n%s", data)
+	}
+
+	trace := generateASCIITrace(got, 20)
+	if len(trace) != 2 || !strings.HasPrefix(trace[0], "RX: █") || !strings.Contains(trace[1], "min 0.200") {
+		t.Errorf("trace = %q", trace)
+	}
+}
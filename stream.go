@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxLiveLines caps the decoded lines kept for the Output panel during a
+// continuous capture; the CSV file receives every row.
+const maxLiveLines = 1000
+
+// streamRowsMsg carries rows decoded since the previous message.
+type streamRowsMsg struct {
+	rows [][]string
+}
+
+// streamDoneMsg reports the end of a continuous capture.
+type streamDoneMsg struct {
+	records   int
+	limited   bool // Stopped by the duration limit rather than the user
+	triggered bool // The software trigger fired and its window was saved
+	err       error
+}
+
+// captureStream is a running continuous capture.
+type captureStream struct {
+	msgs   chan tea.Msg // Nil for a single capture, which has no UI to update
+	reader io.ReadCloser
+
+	mu      sync.Mutex
+	stopped bool
+}
+
+// stop ends the capture; the decoding goroutine then reports streamDoneMsg.
+func (c *captureStream) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.stopped {
+		c.stopped = true
+		c.reader.Close()
+	}
+}
+
+// post delivers a message to the UI, if there is one.
+func (c *captureStream) post(msg tea.Msg) {
+	if c.msgs != nil {
+		c.msgs <- msg
+	}
+}
+
+func (c *captureStream) wasStopped() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stopped
+}
+
+// startStream begins a continuous capture that decodes samples as they
+// arrive. Decoded rows are appended to the output file and delivered to the
+// UI as streamRowsMsg until the duration limit is reached or stop is called.
+//
+// With a software trigger the rows are only shown; the capture ends once
+// the window after the first match has been recorded, and the window is
+// saved as captureFile and decoded to the output file.
+func startStream(m model) (*captureStream, tea.Cmd, error) {
+	req, err := captureRequest(m)
+	if err != nil {
+		return nil, nil, err
+	}
+	trigger, err := softTrigger(m)
+	if err != nil {
+		return nil, nil, err
+	}
+	before, after, err := softWindow(m)
+	if trigger != nil && err != nil {
+		return nil, nil, err
+	}
+	layout, reader, err := m.backend.Stream(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("capture failed: %w", err)
+	}
+
+	dec, err := newCaptureDecoder(m, layout)
+	if err != nil {
+		reader.Close()
+		return nil, nil, err
+	}
+
+	limit := int64(-1)
+	if d, err := time.ParseDuration(m.duration); err == nil && d > 0 {
+		limit = int64(d.Seconds() * float64(layout.SampleRate))
+	}
+
+	c := &captureStream{msgs: make(chan tea.Msg, 16), reader: reader}
+	if trigger != nil {
+		dec.matcher = newSoftMatcher(trigger)
+		go func() {
+			c.msgs <- streamRowsMsg{rows: [][]string{dec.header}}
+			c.msgs <- c.decodeTriggered(m, captureFile, layout, dec, limit, before, after)
+		}()
+		return c, c.wait(), nil
+	}
+
+	outFile, err := os.Create(m.outputFile)
+	if err != nil {
+		reader.Close()
+		return nil, nil, err
+	}
+
+	go func() {
+		defer outFile.Close()
+		writer := csv.NewWriter(outFile)
+		writer.Write(dec.header)
+		c.msgs <- streamRowsMsg{rows: [][]string{dec.header}}
+
+		records, limited, err := c.decode(layout, dec, limit, func(rows [][]string) { writer.WriteAll(rows) }, nil)
+		writer.Flush()
+		if err == nil {
+			err = writer.Error()
+		}
+		if err == nil {
+			err = writeExports(m.outputFile, dec.exports)
+		}
+		c.msgs <- streamDoneMsg{records: records, limited: limited, err: err}
+	}()
+
+	return c, c.wait(), nil
+}
+
+// decodeTriggered runs a stream with a software trigger, continuous or a
+// single capture of limit samples. Samples are kept
+// in a rolling buffer until the trigger fires, then until the window after
+// the match is complete.
+func (c *captureStream) decodeTriggered(m model, srFile string, layout *Session, dec *protocolDecoder, limit int64, before, after time.Duration) streamDoneMsg {
+	// A record is only matched once it is complete, so the buffer also
+	// holds 100 ms for records that started well before they ended
+	keep := samplesIn(before, layout.SampleRate) + int64(layout.SampleRate/10)
+	buf := &rollingBuffer{unitSize: layout.UnitSize, keep: keep}
+	end := int64(-1)
+	done := func(n int64) bool {
+		if end < 0 && dec.matcher.fired {
+			end = dec.matcher.at + samplesIn(after, layout.SampleRate)
+			buf.keep = -1 // Keep everything from here on
+		}
+		return end >= 0 && n >= end
+	}
+
+	records, limited, err := c.decode(layout, dec, limit, func([][]string) {}, func(data []byte, n int64) bool {
+		buf.add(data)
+		return done(n)
+	})
+	done(0) // The match may have come with the last rows
+	if err != nil || !dec.matcher.fired {
+		if err == nil {
+			err = errNoMatch
+		}
+		return streamDoneMsg{records: records, limited: limited, err: err}
+	}
+
+	from := dec.matcher.from - samplesIn(before, layout.SampleRate)
+	window := &Session{SampleRate: layout.SampleRate, UnitSize: layout.UnitSize, Probes: layout.Probes, Logic: buf.data}
+	window = window.Slice(from-buf.start, end-buf.start)
+	if err := writeSession(srFile, window); err != nil {
+		return streamDoneMsg{records: records, err: err}
+	}
+	if err := decodeToCSV(context.Background(), srFile, m.outputFile, m.protocol, m); err != nil {
+		return streamDoneMsg{records: records, err: err}
+	}
+	return streamDoneMsg{records: records, triggered: true}
+}
+
+// rollingBuffer holds the most recent raw samples of a stream.
+type rollingBuffer struct {
+	unitSize int
+	keep     int64 // Samples to keep, -1 to keep all
+	start    int64 // Index of the first sample held
+	data     []byte
+}
+
+func (b *rollingBuffer) add(chunk []byte) {
+	b.data = append(b.data, chunk...)
+	held := int64(len(b.data) / b.unitSize)
+	// Dropping only once twice the limit is held avoids copying the
+	// buffer for every chunk
+	if b.keep >= 0 && held > 2*b.keep {
+		drop := held - b.keep
+		b.data = append(b.data[:0], b.data[drop*int64(b.unitSize):]...)
+		b.start += drop
+	}
+}
+
+// decode feeds the stream through the decoder until it ends, writing and
+// forwarding rows at most ten times a second. If chunkFn is set it receives
+// every block of raw samples along with the total samples read so far, and
+// ends the capture by returning true.
+func (c *captureStream) decode(layout *Session, dec *protocolDecoder, limit int64, write func([][]string), chunkFn func(data []byte, n int64) bool) (int, bool, error) {
+	buf := make([]byte, 64*1024)
+	chunk := &Session{UnitSize: layout.UnitSize}
+	var pending [][]string
+	var n int64
+	records := 0
+	lastSend := time.Now()
+
+	send := func() {
+		rows := dec.drain()
+		write(rows)
+		records += len(rows)
+		pending = append(pending, rows...)
+		if len(pending) > 0 && time.Since(lastSend) > 100*time.Millisecond {
+			c.post(streamRowsMsg{rows: pending})
+			pending = nil
+			lastSend = time.Now()
+		}
+	}
+
+	var leftover []byte
+	for {
+		read, err := c.reader.Read(buf)
+		data := append(leftover, buf[:read]...)
+		whole := len(data) / layout.UnitSize * layout.UnitSize
+		chunk.Logic = data[:whole]
+		leftover = append([]byte(nil), data[whole:]...)
+
+		for i := int64(0); i < chunk.NumSamples(); i++ {
+			dec.feed(n, chunk.Sample(i))
+			n++
+			if n == limit {
+				c.stop()
+				if chunkFn != nil {
+					chunkFn(chunk.Logic[:(i+1)*int64(layout.UnitSize)], n)
+				}
+				dec.flush(n)
+				send()
+				c.post(streamRowsMsg{rows: pending})
+				return records, true, nil
+			}
+		}
+		send()
+		if chunkFn != nil && chunkFn(chunk.Logic, n) {
+			c.stop()
+			c.post(streamRowsMsg{rows: pending})
+			return records, false, nil
+		}
+
+		if err != nil {
+			dec.flush(n)
+			rows := dec.drain()
+			write(rows)
+			records += len(rows)
+			c.post(streamRowsMsg{rows: append(pending, rows...)})
+			// Closing the reader to stop the capture is not a failure
+			if errors.Is(err, io.EOF) || c.wasStopped() {
+				return records, false, nil
+			}
+			return records, false, err
+		}
+	}
+}
+
+// wait delivers the next message from the decoding goroutine.
+func (c *captureStream) wait() tea.Cmd {
+	return func() tea.Msg {
+		return <-c.msgs
+	}
+}
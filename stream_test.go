@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// collectStream runs a continuous capture to completion and returns the
+// rows that reached the UI.
+func collectStream(t *testing.T, m model, stop bool) ([]string, streamDoneMsg) {
+	t.Helper()
+	stream, _, err := startStream(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rows []string
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-stream.msgs:
+			switch msg := msg.(type) {
+			case streamRowsMsg:
+				for _, row := range msg.rows {
+					rows = append(rows, strings.Join(row, ","))
+				}
+				if stop && len(rows) > 1 {
+					stream.stop()
+				}
+			case streamDoneMsg:
+				return rows, msg
+			}
+		case <-timeout:
+			stream.stop()
+			t.Fatal("stream did not finish")
+		}
+	}
+}
+
+func TestStreamLimit(t *testing.T) {
+	t.Chdir(t.TempDir())
+	m := initialModel(newDemoBackend())
+	m.protocol = ProtocolUART
+	m.continuous = true
+	m.duration = "1ms" // Two loops of the 0.5 ms demo recording
+
+	rows, done := collectStream(t, m, false)
+	if done.err != nil {
+		t.Fatal(done.err)
+	}
+	if !done.limited {
+		t.Error("stream was not stopped by the limit")
+	}
+	if done.records != 8 {
+		t.Errorf("records = %d, want 8", done.records)
+	}
+	if len(rows) != 9 || rows[0] != "time,tx,rx,error" {
+		t.Errorf("rows = %q", rows)
+	}
+
+	data, err := os.ReadFile(m.outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(data), "\n"); got != 9 {
+		t.Errorf("output file has %d lines, want 9", got)
+	}
+}
+
+func TestStreamStop(t *testing.T) {
+	t.Chdir(t.TempDir())
+	m := initialModel(newDemoBackend())
+	m.continuous = true
+	m.duration = "Custom..."
+
+	_, done := collectStream(t, m, true)
+	if done.err != nil {
+		t.Fatal(done.err)
+	}
+	if done.limited {
+		t.Error("stream reported the limit after being stopped")
+	}
+	if done.records == 0 {
+		t.Error("no records decoded before stopping")
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// SWDConfig selects the pins of an Arm Serial Wire Debug port.
+type SWDConfig struct {
+	CLK, IO string
+}
+
+// SWDTransfer is one request of the debugger with the target's ACK and
+// the data, or a line reset or JTAG-to-SWD switch sequence.
+type SWDTransfer struct {
+	Start, End int64
+	Event      string // "line reset" or "JTAG to SWD"; empty for transfers
+
+	AP       bool  // Access port rather than debug port
+	Read     bool  // Read rather than write
+	Addr     uint8 // A[3:2] of the request, as a byte address
+	ParityOK bool  // Of the request
+
+	ACK      int // 3 bits, OK is 0b001; -1 if the request got none
+	HasData  bool
+	Data     uint32
+	DataOK   bool   // Data parity
+	Register string // Name of the register, from the last SELECT
+	Select   uint32 // SELECT at the time of the transfer
+}
+
+// SWD ACK responses, sent LSB first.
+const (
+	swdOK    = 0b001
+	swdWait  = 0b010
+	swdFault = 0b100
+)
+
+// swdLineReset is how many cycles SWDIO stays high for a line reset, and
+// swdSwitch the JTAG-to-SWD select sequence that follows one, LSB first.
+const (
+	swdLineReset = 50
+	swdSwitch    = 0xE79E
+)
+
+// swdDecoder follows SWD transfers cycle by cycle. The debugger changes
+// SWDIO on falling edges of SWCLK, so its bits are read on rising edges;
+// the target changes it on rising edges and is read on falling ones.
+type swdDecoder struct {
+	clk, io int
+	prevClk int
+	started bool
+
+	active bool // In a transfer, from its start bit
+	cycle  int  // Cycles since the start bit
+	end    int  // Cycle at which the debugger drives again
+	cur    SWDTransfer
+	bits   []byte
+
+	prevBit     byte
+	ones        int   // Consecutive high bits while idle
+	onesFrom    int64 // Where they started
+	switchBits  int   // Bits since a line reset, while checking for swdSwitch
+	switchShift uint32
+	switchFrom  int64
+	sel         uint32 // Last value written to SELECT
+
+	transfers []SWDTransfer
+}
+
+func newSWDDecoder(s *Session, cfg SWDConfig) (*swdDecoder, error) {
+	d := &swdDecoder{switchBits: -1}
+	var err error
+	if d.clk, err = resolveChannel(s, "SWCLK", cfg.CLK); err != nil {
+		return nil, err
+	}
+	if d.io, err = resolveChannel(s, "SWDIO", cfg.IO); err != nil {
+		return nil, err
+	}
+	if d.clk < 0 {
+		return nil, errMissingPin("SWCLK")
+	}
+	if d.io < 0 {
+		return nil, errMissingPin("SWDIO")
+	}
+	return d, nil
+}
+
+// decodeSWD decodes every transfer in a session.
+func decodeSWD(s *Session, cfg SWDConfig) ([]SWDTransfer, error) {
+	d, err := newSWDDecoder(s, cfg)
+	if err != nil {
+		return nil, err
+	}
+	decodeSession(s, d)
+	return d.transfers, nil
+}
+
+func (d *swdDecoder) feed(n int64, sample uint64) {
+	clk := int(sample >> d.clk & 1)
+	io := byte(sample >> d.io & 1)
+	if !d.started {
+		d.started = true
+		d.prevClk = clk
+		return
+	}
+	rising, falling := clk == 1 && d.prevClk == 0, clk == 0 && d.prevClk == 1
+	d.prevClk = clk
+
+	switch {
+	case rising:
+		if d.active {
+			d.cycle++
+			if d.cycle >= d.end {
+				d.active = false
+				d.prevBit = 0 // A request may follow straight away
+			}
+		}
+		// What looked like a request may have been the end of a line reset
+		if (!d.active || d.cycle < 8) && d.track(n, io) {
+			d.active = false
+		}
+		if d.active {
+			d.hostBit(n, io)
+		} else {
+			d.idle(n, io)
+		}
+	case falling && d.active:
+		d.targetBit(n, io)
+	}
+}
+
+// pendingStart is the start of the transfer in progress, or of a run of
+// ones or bits after a line reset that may still be reported, or -1.
+func (d *swdDecoder) pendingStart() int64 {
+	start := int64(-1)
+	if d.active {
+		start = d.cur.Start
+	}
+	if d.ones > 0 {
+		start = earliest(start, d.onesFrom)
+	}
+	if d.switchBits >= 0 {
+		start = earliest(start, d.switchFrom)
+	}
+	return start
+}
+
+// track follows the debugger's bits outside of transfers for line resets
+// and the switch sequence. It reports the end of a line reset.
+func (d *swdDecoder) track(n int64, v byte) bool {
+	reset := false
+	if v == 1 {
+		if d.ones == 0 {
+			d.onesFrom = n
+		}
+		d.ones++
+	} else {
+		if d.ones >= swdLineReset {
+			d.transfers = append(d.transfers, SWDTransfer{Start: d.onesFrom, End: n, Event: "line reset", ACK: -1})
+			d.switchBits, d.switchShift, d.switchFrom = 0, 0, n
+			reset = true
+		}
+		d.ones = 0
+	}
+	if d.switchBits >= 0 {
+		d.switchShift |= uint32(v) << d.switchBits
+		d.switchBits++
+		if d.switchBits == 16 {
+			if d.switchShift == swdSwitch {
+				d.transfers = append(d.transfers, SWDTransfer{Start: d.switchFrom, End: n, Event: "JTAG to SWD", ACK: -1})
+			}
+			d.switchBits = -1
+		}
+	}
+	return reset
+}
+
+// idle handles a bit of the debugger between transfers, which may be the
+// start bit of a request.
+func (d *swdDecoder) idle(n int64, v byte) {
+	// A start bit follows at least one low bit
+	if v == 1 && d.prevBit == 0 {
+		d.active, d.cycle, d.end = true, 0, 8
+		d.cur = SWDTransfer{Start: n, ACK: -1}
+		d.bits = append(d.bits[:0], v)
+	}
+	d.prevBit = v
+}
+
+// hostBit handles a bit the debugger drives during a transfer: the
+// request, then write data and its parity.
+func (d *swdDecoder) hostBit(n int64, v byte) {
+	t := &d.cur
+	switch {
+	case d.cycle < 8:
+		d.bits = append(d.bits, v)
+		if d.cycle < 7 {
+			return
+		}
+		// Start, APnDP, RnW, A[2:3], parity, stop and park
+		if d.bits[6] != 0 || d.bits[7] != 1 {
+			d.active = false
+			d.prevBit = v
+			return
+		}
+		t.AP, t.Read = d.bits[1] == 1, d.bits[2] == 1
+		t.Addr = d.bits[3]<<2 | d.bits[4]<<3
+		t.ParityOK = (d.bits[1]^d.bits[2]^d.bits[3]^d.bits[4])&1 == d.bits[5]
+		t.Select = d.sel
+		t.Register = swdRegister(t.AP, t.Read, t.Addr, d.sel)
+		d.ones, d.switchBits = 0, -1
+		d.end = 12 // Turnaround and ACK
+	case !t.Read && d.cycle >= 13 && d.cycle < 45:
+		t.Data |= uint32(v) << (d.cycle - 13)
+	case !t.Read && d.cycle == 45:
+		t.DataOK = parity32(t.Data) == v
+		d.finish(n)
+	}
+}
+
+// targetBit handles a bit the target drives: the ACK, then read data and
+// its parity.
+func (d *swdDecoder) targetBit(n int64, v byte) {
+	t := &d.cur
+	switch {
+	case d.cycle >= 9 && d.cycle < 12:
+		if d.cycle == 9 {
+			t.ACK = 0
+		}
+		t.ACK |= int(v) << (d.cycle - 9)
+		if d.cycle < 11 {
+			return
+		}
+		if t.ACK == swdOK {
+			t.HasData = true
+			d.end = 46 // Data, parity and turnaround in either order
+		} else {
+			d.end = 13 // Turnaround
+			d.finish(n)
+		}
+	case t.Read && d.cycle >= 12 && d.cycle < 44:
+		t.Data |= uint32(v) << (d.cycle - 12)
+	case t.Read && d.cycle == 44:
+		t.DataOK = parity32(t.Data) == v
+		d.finish(n)
+	}
+}
+
+// finish records the transfer and keeps track of SELECT, which picks the
+// access port and register banks of later transfers.
+func (d *swdDecoder) finish(n int64) {
+	t := d.cur
+	t.End = n
+	if !t.AP && !t.Read && t.Addr == 0x8 && t.ACK == swdOK && t.DataOK {
+		d.sel = t.Data
+	}
+	d.transfers = append(d.transfers, t)
+}
+
+// parity32 returns the even parity bit of a word.
+func parity32(v uint32) byte {
+	return byte(bits.OnesCount32(v) & 1)
+}
+
+// dpRegisters names the debug port registers by address and direction;
+// reads and writes of the same address reach different registers.
+var dpRegisters = map[uint8][2]string{
+	0x0: {"ABORT", "DPIDR"},
+	0x8: {"SELECT", "RESEND"},
+	0xC: {"TARGETSEL", "RDBUFF"},
+}
+
+// dpBankRegisters are the registers at DP address 0x4 by DPBANKSEL.
+var dpBankRegisters = []string{"CTRL/STAT", "DLCR", "TARGETID", "DLPIDR", "EVENTSTAT"}
+
+// memAPRegisters names the registers of a MEM-AP, the access port to
+// memory, by their address in the AP.
+var memAPRegisters = map[uint32]string{
+	0x00: "CSW",
+	0x04: "TAR",
+	0x0C: "DRW",
+	0x10: "BD0",
+	0x14: "BD1",
+	0x18: "BD2",
+	0x1C: "BD3",
+	0xF4: "CFG",
+	0xF8: "BASE",
+	0xFC: "IDR",
+}
+
+// swdRegister names the register an access reaches. Access port registers
+// are assumed to be those of a MEM-AP.
+func swdRegister(ap, read bool, addr uint8, sel uint32) string {
+	if ap {
+		reg := sel&0xF0 | uint32(addr)
+		if name, ok := memAPRegisters[reg]; ok {
+			return name
+		}
+		return fmt.Sprintf("AP 0x%02X", reg)
+	}
+	if addr == 0x4 {
+		if bank := int(sel & 0xF); bank < len(dpBankRegisters) {
+			return dpBankRegisters[bank]
+		}
+		return fmt.Sprintf("DP 0x4 bank %d", sel&0xF)
+	}
+	names := dpRegisters[addr]
+	if read {
+		return names[1]
+	}
+	return names[0]
+}
+
+// adiInfo describes the value of a debug register where that helps:
+// SELECT fields, IDs, and the data of AP reads, which is returned one
+// access late.
+func adiInfo(ap, read bool, reg string, data uint32, sel uint32) string {
+	var info []string
+	if ap && sel>>24 != 0 {
+		info = append(info, fmt.Sprintf("APSEL %d", sel>>24))
+	}
+	switch {
+	case ap && read:
+		info = append(info, "posted")
+	case reg == "SELECT":
+		info = append(info, fmt.Sprintf("APSEL %d, APBANKSEL 0x%X, DPBANKSEL %d", data>>24, data>>4&0xF, data&0xF))
+	case reg == "DPIDR":
+		info = append(info, dpidrText(data))
+	case reg == "RDBUFF":
+		info = append(info, "last AP read")
+	}
+	return strings.Join(info, "; ")
+}
+
+// dpidrText decodes a DPIDR: the debug port version, designer and part.
+func dpidrText(v uint32) string {
+	return fmt.Sprintf("DPv%d, %s, part 0x%02X, revision %d", v>>12&0xF, jep106Name(v>>1&0x7FF), v>>20&0xFF, v>>28)
+}
+
+// jep106Names are common designers by JEP106 code: the continuation count
+// in the upper bits, then the 7-bit identity.
+var jep106Names = map[uint32]string{
+	0x23B: "Arm",
+	0x020: "STMicroelectronics",
+	0x015: "NXP",
+	0x00E: "Freescale",
+	0x017: "Texas Instruments",
+	0x01F: "Atmel",
+	0x029: "Microchip",
+	0x049: "Xilinx",
+	0x06E: "Altera",
+	0x244: "Nordic",
+	0x4E5: "Raspberry Pi",
+}
+
+// jep106Name names a designer, or shows the code of an unknown one.
+func jep106Name(code uint32) string {
+	if name, ok := jep106Names[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("designer 0x%03X", code)
+}
+
+// swdACKText names an ACK response.
+func swdACKText(ack int) string {
+	switch ack {
+	case swdOK:
+		return "OK"
+	case swdWait:
+		return "WAIT"
+	case swdFault:
+		return "FAULT"
+	case 0b111:
+		return "NO RESPONSE"
+	case -1:
+		return ""
+	}
+	return fmt.Sprintf("INVALID 0b%03b", ack)
+}
+
+var swdHeader = []string{"time", "port", "rw", "reg", "ack", "data", "parity", "info"}
+
+// swdRow formats a transfer as a CSV row.
+func swdRow(s *Session, t SWDTransfer) []string {
+	time := fmt.Sprintf("%.9f", s.Seconds(t.Start))
+	if t.Event != "" {
+		return []string{time, "", "", "", "", "", "", t.Event}
+	}
+	port, rw := "DP", "W"
+	if t.AP {
+		port = "AP"
+	}
+	if t.Read {
+		rw = "R"
+	}
+	data, parity := "", ""
+	var info []string
+	if !t.ParityOK {
+		info = append(info, "request parity error")
+	}
+	if t.HasData {
+		data = fmt.Sprintf("0x%08X", t.Data)
+		parity = "OK"
+		if !t.DataOK {
+			parity = "BAD"
+		}
+		if i := adiInfo(t.AP, t.Read, t.Register, t.Data, t.Select); i != "" {
+			info = append(info, i)
+		}
+	}
+	return []string{time, port, rw, t.Register, swdACKText(t.ACK), data, parity, strings.Join(info, "; ")}
+}
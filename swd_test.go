@@ -0,0 +1,141 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// swdBus drives SWCLK=0 and SWDIO=1 of a synthetic capture, 10 samples
+// per cycle. The debugger changes SWDIO after falling edges of SWCLK, the
+// target on rising edges.
+type swdBus struct {
+	*signal
+}
+
+func newSWDBus() *swdBus {
+	b := &swdBus{newSignal("SWCLK", "SWDIO")}
+	b.set(0, 1).set(1, 0).hold(10)
+	return b
+}
+
+func (b *swdBus) host(bits ...int) *swdBus {
+	for _, v := range bits {
+		b.set(0, 0).hold(1).set(1, v).hold(4).set(0, 1).hold(5)
+	}
+	return b
+}
+
+func (b *swdBus) target(bits ...int) *swdBus {
+	for _, v := range bits {
+		b.set(0, 0).hold(5).set(0, 1).set(1, v).hold(5)
+	}
+	return b
+}
+
+// turn clocks a turnaround cycle, in which neither side drives SWDIO.
+func (b *swdBus) turn() *swdBus {
+	b.set(0, 0).hold(5).set(0, 1).hold(5)
+	return b
+}
+
+// swdWord returns the bits of a data word, LSB first, and its parity;
+// flip inverts the parity.
+func swdWord(v uint32, flip bool) []int {
+	var bits []int
+	for i := 0; i < 32; i++ {
+		bits = append(bits, int(v>>i&1))
+	}
+	p := int(parity32(v))
+	if flip {
+		p ^= 1
+	}
+	return append(bits, p)
+}
+
+func (b *swdBus) request(ap, read bool, addr uint8) *swdBus {
+	apBit, rBit := 0, 0
+	if ap {
+		apBit = 1
+	}
+	if read {
+		rBit = 1
+	}
+	a2, a3 := int(addr>>2&1), int(addr>>3&1)
+	return b.host(1, apBit, rBit, a2, a3, (apBit+rBit+a2+a3)&1, 0, 1)
+}
+
+func (b *swdBus) ack(ack int) *swdBus {
+	return b.target(ack&1, ack>>1&1, ack>>2&1)
+}
+
+func (b *swdBus) read(ap bool, addr uint8, ack int, data uint32, badParity bool) *swdBus {
+	b.request(ap, true, addr).turn().ack(ack)
+	if ack == swdOK {
+		b.target(swdWord(data, badParity)...)
+	}
+	return b.turn().host(0, 0)
+}
+
+func (b *swdBus) write(ap bool, addr uint8, ack int, data uint32) *swdBus {
+	b.request(ap, false, addr).turn().ack(ack).turn()
+	if ack == swdOK {
+		b.host(swdWord(data, false)...)
+	}
+	return b.host(0, 0)
+}
+
+func TestDecodeSWD(t *testing.T) {
+	ones := slices.Repeat([]int{1}, 50)
+	bus := newSWDBus()
+	bus.host(ones...)
+	for i := 0; i < 16; i++ {
+		bus.host(swdSwitch >> i & 1)
+	}
+	bus.host(ones...).host(0, 0)
+	bus.read(false, 0x0, swdOK, 0x2BA01477, false)
+	bus.write(false, 0x8, swdOK, 0x010000F0)
+	bus.read(true, 0xC, swdOK, 0x24770011, false)
+	bus.write(false, 0x8, swdOK, 0)
+	bus.write(true, 0x4, swdWait, 0)
+	bus.read(true, 0xC, swdOK, 0x12345678, true)
+	bus.read(false, 0x4, 0b111, 0, false)
+
+	s := bus.session(1000000)
+	got, err := decodeSWD(s, SWDConfig{CLK: "D0", IO: "D1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"", "", "", "", "", "", "line reset"},
+		{"", "", "", "", "", "", "JTAG to SWD"},
+		{"", "", "", "", "", "", "line reset"},
+		{"DP", "R", "DPIDR", "OK", "0x2BA01477", "OK", "DPv1, Arm, part 0xBA, revision 2"},
+		{"DP", "W", "SELECT", "OK", "0x010000F0", "OK", "APSEL 1, APBANKSEL 0xF, DPBANKSEL 0"},
+		{"AP", "R", "IDR", "OK", "0x24770011", "OK", "APSEL 1; posted"},
+		{"DP", "W", "SELECT", "OK", "0x00000000", "OK", "APSEL 0, APBANKSEL 0x0, DPBANKSEL 0"},
+		{"AP", "W", "TAR", "WAIT", "", "", ""},
+		{"AP", "R", "DRW", "OK", "0x12345678", "BAD", "posted"},
+		{"DP", "R", "CTRL/STAT", "NO RESPONSE", "", "", ""},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d transfers, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if row := swdRow(s, got[i]); !slices.Equal(row[1:], w) {
+			t.Errorf("transfer %d = %q\nwant %q", i, row[1:], w)
+		}
+	}
+}
+
+func TestSWDRequestParity(t *testing.T) {
+	// DP read of DPIDR with the parity bit clear
+	bus := newSWDBus()
+	bus.host(0, 1, 0, 1, 0, 0, 0, 0, 1).turn().ack(swdFault).turn().host(0, 0)
+	got, err := decodeSWD(bus.session(1000000), SWDConfig{CLK: "D0", IO: "D1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ParityOK || swdRow(bus.session(1000000), got[0])[7] != "request parity error" {
+		t.Errorf("got %+v", got)
+	}
+}
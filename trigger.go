@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TriggerCondition is the level or edge one channel must show for a
+// trigger to fire.
+type TriggerCondition struct {
+	Channel string // Protocol role (e.g. "CS") or hardware channel (e.g. "D3")
+	Match   string // "0" or "1" for a level; "r", "f" or "e" for an edge
+}
+
+// triggerPreset is a named trigger offered in the Capture panel.
+type triggerPreset struct {
+	name string
+	spec string
+}
+
+// triggerNone disables the trigger; the capture starts immediately.
+const triggerNone = "none"
+
+// parseTrigger reads a trigger in sigrok-cli syntax, e.g. "SDA=f,SCL=1".
+// All conditions must hold at the same sample for the trigger to fire.
+func parseTrigger(spec string) ([]TriggerCondition, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || strings.EqualFold(spec, triggerNone) {
+		return nil, nil
+	}
+
+	var conds []TriggerCondition
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(spec, ",") {
+		channel, match, ok := strings.Cut(strings.TrimSpace(part), "=")
+		channel = strings.TrimSpace(channel)
+		match = strings.ToLower(strings.TrimSpace(match))
+		if !ok || channel == "" {
+			return nil, fmt.Errorf("trigger condition %q must be CHANNEL=MATCH", part)
+		}
+		switch match {
+		case "0", "1", "r", "f", "e":
+		default:
+			return nil, fmt.Errorf("trigger on %s must be 0, 1, r, f or e, got %q", channel, match)
+		}
+		if seen[strings.ToUpper(channel)] {
+			return nil, fmt.Errorf("trigger uses %s twice", channel)
+		}
+		seen[strings.ToUpper(channel)] = true
+		conds = append(conds, TriggerCondition{Channel: channel, Match: match})
+	}
+	return conds, nil
+}
+
+// triggerPresets lists the common triggers for the selected protocol. The
+// specs use role names, which captureRequest maps to the configured pins.
+func (m model) triggerPresets() []triggerPreset {
+	presets := []triggerPreset{{triggerNone, ""}}
+	switch m.protocol {
+	case ProtocolSPI:
+		active, inactive := "CS=f", "CS=r"
+		if strings.EqualFold(m.spiCSPolarity, "high") {
+			active, inactive = inactive, active
+		}
+		presets = append(presets,
+			triggerPreset{"CS active", active},
+			triggerPreset{"CS inactive", inactive})
+	case ProtocolI2C:
+		presets = append(presets,
+			triggerPreset{"START", "SDA=f,SCL=1"},
+			triggerPreset{"STOP", "SDA=r,SCL=1"})
+	case ProtocolUART, ProtocolModbus:
+		// The start bit leaves the idle level
+		start := "f"
+		if strings.EqualFold(m.uartInvert, "yes") {
+			start = "r"
+		}
+		presets = append(presets,
+			triggerPreset{"TX start bit", "TX=" + start},
+			triggerPreset{"RX start bit", "RX=" + start})
+	case ProtocolOneWire:
+		// Resets and bit slots all begin by pulling DQ low
+		presets = append(presets, triggerPreset{"DQ low", "DQ=f"})
+	case ProtocolCAN:
+		// A start of frame is the first dominant bit after idle
+		line := "RX"
+		if m.canRX == "" {
+			line = "TX"
+		}
+		presets = append(presets, triggerPreset{"Start of frame", line + "=f"})
+	case ProtocolSWD:
+		// The clock only runs while the debugger is busy
+		presets = append(presets, triggerPreset{"SWCLK start", "SWCLK=r"})
+	case ProtocolJTAG:
+		presets = append(presets,
+			triggerPreset{"TCK start", "TCK=r"},
+			triggerPreset{"TMS rising", "TMS=r"})
+	case ProtocolI2S:
+		// Stereo I2S frames start with the left channel, WS low
+		start := "WS=r"
+		if m.i2sSlots == "2" && strings.EqualFold(m.i2sJustify, "i2s") {
+			start = "WS=f"
+		}
+		presets = append(presets, triggerPreset{"Frame start", start})
+	}
+	return presets
+}
+
+// triggerField returns the trigger setting of the selected protocol. It
+// holds a preset name or a custom spec.
+func (m *model) triggerField() *string {
+	switch m.protocol {
+	case ProtocolI2C:
+		return &m.i2cTrigger
+	case ProtocolUART:
+		return &m.uartTrigger
+	case ProtocolOneWire:
+		return &m.oneWireTrigger
+	case ProtocolCAN:
+		return &m.canTrigger
+	case ProtocolSWD:
+		return &m.swdTrigger
+	case ProtocolJTAG:
+		return &m.jtagTrigger
+	case ProtocolI2S:
+		return &m.i2sTrigger
+	case ProtocolModbus:
+		return &m.modbusTrigger
+	}
+	return &m.spiTrigger
+}
+
+// triggerSpec expands the trigger setting of the selected protocol to a
+// spec, resolving preset names.
+func (m model) triggerSpec() string {
+	value := *m.triggerField()
+	for _, p := range m.triggerPresets() {
+		if strings.EqualFold(value, p.name) {
+			return p.spec
+		}
+	}
+	return value
+}
+
+// channelAssignment maps a hardware pin to the name it is captured as: a
+// protocol role, or a hardware name such as "D5" for a trigger-only channel.
+type channelAssignment struct {
+	pin, role string
+}
+
+// resolveTrigger rewrites trigger conditions to the channel names of a
+// capture. Roles and their pins both map to the role name, which is how
+// sigrok-cli knows the channel once renamed; any other hardware channel is
+// added to the capture so it can be triggered on.
+func resolveTrigger(conds []TriggerCondition, channels []channelAssignment) (string, []channelAssignment, error) {
+	var parts []string
+	for _, c := range conds {
+		name := ""
+		for _, a := range channels {
+			// Bus roles such as "flash.CS" also match as "CS"
+			_, role, _ := strings.Cut(a.role, ".")
+			if strings.EqualFold(c.Channel, a.role) || strings.EqualFold(c.Channel, role) || samePin(c.Channel, a.pin) {
+				name = a.role
+				break
+			}
+		}
+		if name == "" {
+			n, ok := channelIndex(c.Channel)
+			if !ok {
+				return "", nil, fmt.Errorf("trigger channel %s is not a role or pin", c.Channel)
+			}
+			name = fmt.Sprintf("D%d", n)
+			channels = append(channels, channelAssignment{pin: name, role: name})
+		}
+		parts = append(parts, name+"="+c.Match)
+	}
+	return strings.Join(parts, ","), channels, nil
+}
+
+// samePin reports whether two hardware channel names refer to the same
+// channel, e.g. "D3" and "3".
+func samePin(a, b string) bool {
+	x, okA := channelIndex(a)
+	y, okB := channelIndex(b)
+	return okA && okB && x == y
+}
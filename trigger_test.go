@@ -0,0 +1,132 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseTrigger(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []TriggerCondition
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"none", nil, false},
+		{"CS=f", []TriggerCondition{{"CS", "f"}}, false},
+		{" SDA=F , SCL=1 ", []TriggerCondition{{"SDA", "f"}, {"SCL", "1"}}, false},
+		{"D5=e", []TriggerCondition{{"D5", "e"}}, false},
+		{"CS", nil, true},
+		{"=r", nil, true},
+		{"CS=x", nil, true},
+		{"CS=f,cs=1", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTrigger(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTrigger(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("parseTrigger(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestCaptureRequestTrigger(t *testing.T) {
+	tests := []struct {
+		name       string
+		protocol   Protocol
+		trigger    string
+		preTrigger string
+		wantSpec   string
+		wantChans  string
+		wantPre    int
+	}{
+		{"SPI default", ProtocolSPI, "CS active", "10", "CS=f", "D0=MISO,D1=MOSI,D2=CLK,D3=CS", 10},
+		{"I2C none", ProtocolI2C, "none", "10", "", "D0=SDA,D1=SCL", 0},
+		{"I2C START", ProtocolI2C, "START", "25", "SDA=f,SCL=1", "D0=SDA,D1=SCL", 25},
+		{"pin of a role", ProtocolI2C, "D1=r", "0", "SCL=r", "D0=SDA,D1=SCL", 0},
+		{"extra channel", ProtocolUART, "TX=f,D5=1", "50", "TX=f,D5=1", "D0=TX,D1=RX,D5", 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := initialModel(newDemoBackend())
+			m.protocol = tt.protocol
+			*m.triggerField() = tt.trigger
+			m.preTrigger = tt.preTrigger
+
+			req, err := captureRequest(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Trigger != tt.wantSpec {
+				t.Errorf("Trigger = %q, want %q", req.Trigger, tt.wantSpec)
+			}
+			if req.Channels != tt.wantChans {
+				t.Errorf("Channels = %q, want %q", req.Channels, tt.wantChans)
+			}
+			if req.PreTrigger != tt.wantPre {
+				t.Errorf("PreTrigger = %d, want %d", req.PreTrigger, tt.wantPre)
+			}
+		})
+	}
+}
+
+func TestCaptureRequestTriggerErrors(t *testing.T) {
+	m := initialModel(newDemoBackend())
+	m.spiTrigger = "MOSI=f,XYZ=1"
+	if _, err := captureRequest(m); err == nil {
+		t.Error("unknown trigger channel accepted")
+	}
+
+	m.spiTrigger = "CS active"
+	m.preTrigger = "100"
+	if _, err := captureRequest(m); err == nil {
+		t.Error("pre-trigger of 100% accepted")
+	}
+}
+
+func TestTriggerArgs(t *testing.T) {
+	req := AcquireRequest{Device: "fx2lafw:conn=1.43", SampleRate: "1000000", Trigger: "CS=f", PreTrigger: 20}
+	want := []string{"-d", "fx2lafw:conn=1.43", "--config", "samplerate=1000000:captureratio=20", "-t", "CS=f"}
+	if got := req.args(); !slices.Equal(got, want) {
+		t.Errorf("args = %q, want %q", got, want)
+	}
+
+	// Without a trigger there is nothing to keep before it
+	req.Trigger = ""
+	want = []string{"-d", "fx2lafw:conn=1.43", "--config", "samplerate=1000000"}
+	if got := req.args(); !slices.Equal(got, want) {
+		t.Errorf("args = %q, want %q", got, want)
+	}
+}
+
+func TestTriggerDropdownFits(t *testing.T) {
+	m := initialModel(newDemoBackend())
+	m.activePanel = panelCaptureSettings
+	m = press(t, m, slices.Repeat([]string{"j"}, 20)...)
+	if m.cursor != captureStartSlot {
+		t.Fatalf("cursor = %d, want it to stop at %d", m.cursor, captureStartSlot)
+	}
+	closed := strings.Count(m.renderCapturePanel(60, 18), "\n")
+	if closed+1 != 18+2 {
+		t.Errorf("panel has %d lines, want 18 and its border", closed+1)
+	}
+
+	m.cursor = 3
+	m = press(t, m, "enter")
+	if !m.selectingTrigger {
+		t.Fatal("trigger dropdown not open")
+	}
+	// Scroll down to Custom..., the last option
+	m = press(t, m, slices.Repeat([]string{"j"}, len(m.triggerOptions()))...)
+	panel := m.renderCapturePanel(60, 18)
+	if got := strings.Count(panel, "\n"); got != closed {
+		t.Errorf("panel has %d lines with the dropdown open, %d closed", got+1, closed+1)
+	}
+	if !strings.Contains(panel, "Custom...") {
+		t.Errorf("selected option not shown:\n%s", panel)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UART parity modes.
+const (
+	ParityNone = iota
+	ParityOdd
+	ParityEven
+	ParityMark
+	ParitySpace
+)
+
+// UARTConfig selects the pins and frame format of a UART link.
+type UARTConfig struct {
+	TX, RX string // Pins; either may be empty
+
+	Baud     int
+	DataBits int     // 5 to 9
+	Parity   int     // One of the Parity* constants
+	StopBits float64 // 1, 1.5 or 2
+	Inverted bool    // Idle low instead of idle high
+}
+
+// UARTFrame is one character received on either line.
+type UARTFrame struct {
+	Start, End int64
+	RX         bool // Received on the RX line rather than TX
+	Value      uint16
+
+	ParityError  bool
+	FramingError bool // Stop bit was not at the idle level
+}
+
+// uartConfig validates the UART fields of the Configuration panel.
+func uartConfig(m model) (UARTConfig, error) {
+	cfg := UARTConfig{TX: m.uartTX, RX: m.uartRX}
+	var err error
+	if cfg.Baud, err = parseIntRange("baud rate", m.uartBaud, 1, 100000000); err != nil {
+		return cfg, err
+	}
+	if cfg.DataBits, err = parseIntRange("data bits", m.uartDataBits, 5, 9); err != nil {
+		return cfg, err
+	}
+	if cfg.Parity, err = parseChoice("parity", m.uartParity, "none", "odd", "even", "mark", "space"); err != nil {
+		return cfg, err
+	}
+	stop, err := parseChoice("stop bits", m.uartStopBits, "1", "1.5", "2")
+	if err != nil {
+		return cfg, err
+	}
+	cfg.StopBits = []float64{1, 1.5, 2}[stop]
+	invert, err := parseChoice("invert", m.uartInvert, "no", "yes")
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Inverted = invert == 1
+	return cfg, nil
+}
+
+// uartLine decodes frames on a single line by sampling the middle of every
+// bit after a start edge.
+type uartLine struct {
+	cfg     UARTConfig
+	ch      int
+	rx      bool
+	bitLen  float64 // Samples per bit
+	started bool
+	prev    int
+
+	inFrame  bool
+	start    int64
+	bit      int     // Index of the next bit to sample, 0 is the start bit
+	sampleAt float64 // Sample position of the next bit
+	frame    UARTFrame
+	ones     int // Data bits set, for the parity check
+}
+
+func (l *uartLine) level(sample uint64) int {
+	v := int(sample >> l.ch & 1)
+	if l.cfg.Inverted {
+		v ^= 1
+	}
+	return v
+}
+
+func (l *uartLine) feed(n int64, sample uint64, emit func(UARTFrame)) {
+	v := l.level(sample)
+	if !l.started {
+		l.started = true
+		l.prev = v
+		return
+	}
+	prev := l.prev
+	l.prev = v
+
+	if !l.inFrame {
+		// A falling edge out of idle begins the start bit
+		if prev == 1 && v == 0 {
+			l.inFrame = true
+			l.start = n
+			l.bit = 0
+			l.sampleAt = float64(n) + l.bitLen/2
+			l.frame = UARTFrame{Start: n, RX: l.rx}
+			l.ones = 0
+		}
+		return
+	}
+	if float64(n) < l.sampleAt {
+		return
+	}
+
+	dataEnd := 1 + l.cfg.DataBits
+	parityBit := dataEnd
+	if l.cfg.Parity == ParityNone {
+		parityBit = -1
+	}
+	stopBit := dataEnd
+	if parityBit >= 0 {
+		stopBit++
+	}
+
+	switch {
+	case l.bit == 0:
+		// Glitch shorter than half a bit: not a start bit
+		if v != 0 {
+			l.inFrame = false
+			return
+		}
+	case l.bit < dataEnd:
+		l.frame.Value |= uint16(v) << (l.bit - 1)
+		l.ones += v
+	case l.bit == parityBit:
+		l.frame.ParityError = !l.parityOK(v)
+	default:
+		if v != 1 {
+			l.frame.FramingError = true
+		}
+		// With two stop bits the second one is checked as well
+		if l.bit == stopBit && l.cfg.StopBits == 2 {
+			break
+		}
+		frameBits := float64(stopBit) + l.cfg.StopBits
+		l.frame.End = l.start + int64(frameBits*l.bitLen+0.5)
+		emit(l.frame)
+		// After a framing error the line is still low, so the next start
+		// bit is only found once it has returned to idle
+		l.inFrame = false
+		return
+	}
+	l.bit++
+	l.sampleAt = float64(l.start) + (float64(l.bit)+0.5)*l.bitLen
+}
+
+func (l *uartLine) parityOK(v int) bool {
+	switch l.cfg.Parity {
+	case ParityOdd:
+		return (l.ones+v)%2 == 1
+	case ParityEven:
+		return (l.ones+v)%2 == 0
+	case ParityMark:
+		return v == 1
+	case ParitySpace:
+		return v == 0
+	}
+	return true
+}
+
+// uartDecoder decodes the TX and RX lines independently, so the direction
+// of every frame comes from the line it was seen on.
+type uartDecoder struct {
+	lines   []*uartLine
+	frames  []UARTFrame
+	flushed bool // No more samples will arrive
+}
+
+func newUARTDecoder(s *Session, cfg UARTConfig) (*uartDecoder, error) {
+	if s.SampleRate == 0 {
+		return nil, fmt.Errorf("capture has no sample rate")
+	}
+	bitLen := float64(s.SampleRate) / float64(cfg.Baud)
+	if bitLen < 2 {
+		return nil, fmt.Errorf("sample rate %d is too low for %d baud", s.SampleRate, cfg.Baud)
+	}
+
+	d := &uartDecoder{}
+	for _, line := range []struct {
+		role, pin string
+		rx        bool
+	}{{"TX", cfg.TX, false}, {"RX", cfg.RX, true}} {
+		ch, err := resolveChannel(s, line.role, line.pin)
+		if err != nil {
+			return nil, err
+		}
+		if ch >= 0 {
+			d.lines = append(d.lines, &uartLine{cfg: cfg, ch: ch, rx: line.rx, bitLen: bitLen})
+		}
+	}
+	if len(d.lines) == 0 {
+		return nil, errMissingPin("TX or RX")
+	}
+	return d, nil
+}
+
+// decodeUART decodes every frame in a session, ordered by start time.
+func decodeUART(s *Session, cfg UARTConfig) ([]UARTFrame, error) {
+	d, err := newUARTDecoder(s, cfg)
+	if err != nil {
+		return nil, err
+	}
+	decodeSession(s, d)
+	return d.frames, nil
+}
+
+func (d *uartDecoder) feed(n int64, sample uint64) {
+	for _, l := range d.lines {
+		l.feed(n, sample, d.emit)
+	}
+}
+
+// emit keeps frames ordered by start time. A frame on one line can finish
+// after a shorter frame that started later on the other line.
+func (d *uartDecoder) emit(f UARTFrame) {
+	i := len(d.frames)
+	for i > 0 && d.frames[i-1].Start > f.Start {
+		i--
+	}
+	d.frames = append(d.frames, UARTFrame{})
+	copy(d.frames[i+1:], d.frames[i:])
+	d.frames[i] = f
+}
+
+// take removes and returns the frames that are final. While a frame is in
+// progress on one line, frames that started after it on the other line are
+// held back so the output stays in order.
+func (d *uartDecoder) take() []UARTFrame {
+	n := len(d.frames)
+	if !d.flushed {
+		for _, l := range d.lines {
+			if !l.inFrame {
+				continue
+			}
+			for n > 0 && d.frames[n-1].Start > l.start {
+				n--
+			}
+		}
+	}
+	done := append([]UARTFrame(nil), d.frames[:n]...)
+	d.frames = append(d.frames[:0], d.frames[n:]...)
+	return done
+}
+
+// pendingStart is the start of the earliest frame not yet taken: one held
+// back, or one still being received.
+func (d *uartDecoder) pendingStart() int64 {
+	start := int64(-1)
+	if len(d.frames) > 0 {
+		start = d.frames[0].Start
+	}
+	for _, l := range d.lines {
+		if l.inFrame {
+			start = earliest(start, l.start)
+		}
+	}
+	return start
+}
+
+var uartHeader = []string{"time", "tx", "rx", "error"}
+
+// uartRow formats a frame as a CSV row.
+func uartRow(s *Session, cfg UARTConfig, f UARTFrame) []string {
+	value := formatHex(uint32(f.Value), cfg.DataBits)
+	tx, rx := value, ""
+	if f.RX {
+		tx, rx = "", value
+	}
+
+	var errs []string
+	if f.ParityError {
+		errs = append(errs, "parity")
+	}
+	if f.FramingError {
+		errs = append(errs, "framing")
+	}
+
+	return []string{
+		fmt.Sprintf("%.9f", s.Seconds(f.Start)),
+		tx,
+		rx,
+		strings.Join(errs, " "),
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import "testing"
+
+// uartSignal sends frames on TX=0 and RX=1 at 10 samples per bit. Each
+// frame lists its line levels from the start bit to the last stop bit.
+func uartSignal(inverted bool, frames ...[]int) *signal {
+	idle := 1
+	if inverted {
+		idle = 0
+	}
+	g := newSignal("TX", "RX")
+	g.set(0, idle).set(1, idle).hold(25)
+	for i, bits := range frames {
+		ch := i % 2
+		for _, b := range bits {
+			if inverted {
+				b ^= 1
+			}
+			g.set(ch, b).hold(10)
+		}
+		g.set(ch, idle).hold(15)
+	}
+	return g
+}
+
+// uartBits builds the line levels of a frame.
+func uartBits(v, dataBits int, parity ...int) []int {
+	bits := []int{0}
+	for i := 0; i < dataBits; i++ {
+		bits = append(bits, v>>i&1)
+	}
+	bits = append(bits, parity...)
+	return append(bits, 1, 1)
+}
+
+func TestDecodeUART(t *testing.T) {
+	base := UARTConfig{TX: "D0", RX: "D1", Baud: 100000, DataBits: 8, StopBits: 1}
+
+	tests := []struct {
+		name   string
+		cfg    func(*UARTConfig)
+		frames [][]int
+		want   []UARTFrame
+	}{
+		{
+			name:   "8N1",
+			cfg:    func(*UARTConfig) {},
+			frames: [][]int{uartBits(0x48, 8), uartBits(0x4F, 8)},
+			want:   []UARTFrame{{Value: 0x48}, {Value: 0x4F, RX: true}},
+		},
+		{
+			name:   "7E1 with parity error",
+			cfg:    func(c *UARTConfig) { c.DataBits = 7; c.Parity = ParityEven },
+			frames: [][]int{uartBits(0x41, 7, 0), uartBits(0x41, 7, 1)},
+			want:   []UARTFrame{{Value: 0x41}, {Value: 0x41, RX: true, ParityError: true}},
+		},
+		{
+			name:   "9 data bits, odd parity",
+			cfg:    func(c *UARTConfig) { c.DataBits = 9; c.Parity = ParityOdd },
+			frames: [][]int{uartBits(0x1FF, 9, 0)},
+			want:   []UARTFrame{{Value: 0x1FF}},
+		},
+		{
+			name:   "framing error",
+			cfg:    func(*UARTConfig) {},
+			frames: [][]int{append(uartBits(0x55, 8)[:9], 0, 0, 0)},
+			want:   []UARTFrame{{Value: 0x55, FramingError: true}},
+		},
+		{
+			name:   "two stop bits",
+			cfg:    func(c *UARTConfig) { c.StopBits = 2 },
+			frames: [][]int{append(uartBits(0x12, 8)[:10], 0)},
+			want:   []UARTFrame{{Value: 0x12, FramingError: true}},
+		},
+		{
+			name:   "inverted",
+			cfg:    func(c *UARTConfig) { c.Inverted = true },
+			frames: [][]int{uartBits(0xA5, 8), uartBits(0x5A, 8)},
+			want:   []UARTFrame{{Value: 0xA5}, {Value: 0x5A, RX: true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			tt.cfg(&cfg)
+			got, err := decodeUART(uartSignal(cfg.Inverted, tt.frames...).session(1000000), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d frames, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, w := range tt.want {
+				g := got[i]
+				g.Start, g.End = 0, 0
+				if g != w {
+					t.Errorf("frame %d = %+v, want %+v", i, got[i], w)
+				}
+			}
+		})
+	}
+}